@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+//Writes that are applied atomically after all preconditions are checked
+type Batch struct {
+	Preconditions []precondition `json:"preconditions"`
+	Operations    []operation    `json:"operations"`
+	Encoding      string         `json:"encoding"`
+}
+
+type precondition struct {
+	Key     string `json:"key"`
+	Version uint64 `json:"version"`
+}
+
+type operation struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Ttl   uint   `json:"ttl"`
+}
+
+func NewBatch() *Batch {
+	return &Batch{Preconditions: []precondition{}, Operations: []operation{}, Encoding: base64Encoding}
+}
+
+//Batch is applied only if the key has given version, 0 means that the key must not exist
+func (batch *Batch) Require(key []byte, version uint64) *Batch {
+	batch.Preconditions = append(batch.Preconditions, precondition{Key: encode(key), Version: version})
+	return batch
+}
+
+func (batch *Batch) Put(key []byte, value []byte) *Batch {
+	return batch.PutWithTTL(key, value, 0)
+}
+
+//Put the value that expires after ttl, it's rounded up to seconds
+func (batch *Batch) PutWithTTL(key []byte, value []byte, ttl time.Duration) *Batch {
+	batch.Operations = append(batch.Operations, operation{
+		Op:    "put",
+		Key:   encode(key),
+		Value: encode(value),
+		Ttl:   uint((ttl + time.Second - 1) / time.Second),
+	})
+	return batch
+}
+
+func (batch *Batch) Delete(key []byte) *Batch {
+	batch.Operations = append(batch.Operations, operation{Op: "delete", Key: encode(key)})
+	return batch
+}
+
+//Apply the batch atomically, ErrConflict if a precondition doesn't hold
+//or the keys were changed by another transaction
+//the batch isn't retried if the connection fails because it could be already applied
+func (client *Client) Batch(ctx context.Context, batch *Batch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	_, _, err = client.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/_batch/txn",
+		body:        func() []byte { return body },
+		contentType: "application/json",
+	})
+	return err
+}
+
+func encode(value []byte) string {
+	return base64.StdEncoding.EncodeToString(value)
+}
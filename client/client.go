@@ -0,0 +1,346 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mimeOctetStream = "application/octet-stream"
+	base64Encoding  = "base64"
+	noVersion       = uint64(0) //version of the key that doesn't exist
+)
+
+//Settings of the client
+type Options struct {
+	Token         string        //bearer token, it's required if the server uses --auth-config
+	MaxRetries    int           //retries of failed request, 0 means no retries
+	RetryDelay    time.Duration //delay before the first retry, it doubles with every retry
+	MaxRetryDelay time.Duration //max delay between retries, Retry-After of the server is capped by it too
+	ScanPageSize  int           //keys requested by one request of scan, the server allows up to 1000
+	//client that sends requests, its transport keeps connections to the server
+	//nil means the client with a transport that keeps up to 64 idle connections
+	HTTPClient *http.Client
+}
+
+//Default settings
+func DefaultOptions() *Options {
+	return &Options{
+		MaxRetries:    3,
+		RetryDelay:    100 * time.Millisecond,
+		MaxRetryDelay: 5 * time.Second,
+		ScanPageSize:  1000,
+	}
+}
+
+//Client of wiskey http server, it's safe to use from multiple goroutines
+type Client struct {
+	baseURL    string //scheme, host and path of column family
+	httpClient *http.Client
+	options    Options
+}
+
+//Client of the server at given address, http://host:port, https://host:port or unix:///path/to/socket
+//nil options means default options
+func New(addr string, options *Options) (*Client, error) {
+	if options == nil {
+		options = DefaultOptions()
+	}
+	if options.MaxRetries < 0 || options.RetryDelay < 0 || options.MaxRetryDelay < 0 || options.ScanPageSize < 0 || options.ScanPageSize > 1000 {
+		return nil, errors.New("invalid client options")
+	}
+	client := &Client{options: *options, httpClient: options.HTTPClient}
+	if client.options.ScanPageSize == 0 {
+		client.options.ScanPageSize = DefaultOptions().ScanPageSize
+	}
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	if strings.HasPrefix(addr, "unix://") {
+		socket := strings.TrimPrefix(addr, "unix://")
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		addr = "http://unix"
+	}
+	parsed, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme of address %s", addr)
+	}
+	client.baseURL = strings.TrimSuffix(addr, "/")
+	if client.httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = 64
+		transport.MaxIdleConnsPerHost = 64
+		if dial != nil {
+			transport.DialContext = dial
+		}
+		client.httpClient = &http.Client{Transport: transport}
+	} else if dial != nil {
+		return nil, errors.New("unix socket can't be used with custom http client")
+	}
+	return client, nil
+}
+
+//The same client that works with given column family
+func (client *Client) Family(name string) *Client {
+	family := *client
+	family.baseURL = client.baseURL + "/ns/" + url.PathEscape(name)
+	return &family
+}
+
+//Request to the server, body is created for every attempt
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        func() []byte
+	contentType string
+	//request can be sent again if the connection failed after the request was sent
+	idempotent bool
+}
+
+//Send the request and retry it if the server is unavailable
+//responses with status >= 400 are returned as *Error
+func (client *Client) do(ctx context.Context, req request) (*http.Response, []byte, error) {
+	target := client.baseURL + req.path
+	if len(req.query) != 0 {
+		target += "?" + req.query.Encode()
+	}
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if req.body != nil {
+			body = bytes.NewReader(req.body())
+		}
+		httpRequest, err := http.NewRequestWithContext(ctx, req.method, target, body)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, values := range req.header {
+			httpRequest.Header[name] = values
+		}
+		if req.contentType != "" {
+			httpRequest.Header.Set("Content-Type", req.contentType)
+		}
+		if client.options.Token != "" {
+			httpRequest.Header.Set("Authorization", "Bearer "+client.options.Token)
+		}
+		response, err := client.httpClient.Do(httpRequest)
+		var retryAfter time.Duration
+		var respBody []byte
+		if err == nil {
+			//body is read fully so the connection can be reused
+			respBody, err = ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			if err == nil && response.StatusCode < 400 {
+				return response, respBody, nil
+			}
+			if err == nil {
+				err = responseError(response, respBody)
+				if response.StatusCode != http.StatusServiceUnavailable {
+					return response, respBody, err
+				}
+				if seconds, parseErr := strconv.Atoi(response.Header.Get("Retry-After")); parseErr == nil {
+					retryAfter = time.Duration(seconds) * time.Second
+				}
+			}
+		} else {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			if !req.idempotent && !dialError(err) {
+				return nil, nil, &Error{Message: err.Error(), kind: ErrUnavailable}
+			}
+			err = &Error{Message: err.Error(), kind: ErrUnavailable}
+		}
+		if attempt == client.options.MaxRetries {
+			return nil, nil, err
+		}
+		if err := client.wait(ctx, attempt, retryAfter); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+//Connection couldn't be opened so the request wasn't sent
+func dialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+//Sleep before the retry, delay doubles with every attempt and is randomized so clients don't retry together
+//the delay requested by the server is used if it's longer
+func (client *Client) wait(ctx context.Context, attempt int, retryAfter time.Duration) error {
+	delay := client.options.RetryDelay << uint(attempt)
+	if delay <= 0 || delay > client.options.MaxRetryDelay {
+		delay = client.options.MaxRetryDelay
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	if delay > client.options.MaxRetryDelay {
+		delay = client.options.MaxRetryDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Get the value and its version, ErrNotFound if the key doesn't exist
+func (client *Client) Get(ctx context.Context, key []byte) ([]byte, uint64, error) {
+	response, body, err := client.do(ctx, request{
+		method:     http.MethodGet,
+		path:       "/fetch",
+		query:      url.Values{"key": {string(key)}},
+		header:     http.Header{"Accept": {mimeOctetStream}},
+		idempotent: true,
+	})
+	if err != nil {
+		return nil, noVersion, err
+	}
+	version, err := parseETag(response.Header.Get("ETag"))
+	if err != nil {
+		return nil, noVersion, err
+	}
+	return body, version, nil
+}
+
+//Save the value of the key
+func (client *Client) Put(ctx context.Context, key []byte, value []byte) error {
+	return client.PutWithTTL(ctx, key, value, 0)
+}
+
+//Save the value that expires after ttl, it's rounded up to seconds, 0 means that value never expires
+func (client *Client) PutWithTTL(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
+	_, err := client.put(ctx, key, value, ttl, nil, true)
+	return err
+}
+
+//Replace the value only if the key has expected version, noVersion(0) means that the key must not exist
+//returns the new version of the key, ErrConflict if the version doesn't match
+func (client *Client) CompareAndSwap(ctx context.Context, key []byte, expectedVersion uint64, value []byte) (uint64, error) {
+	header := http.Header{"If-None-Match": {"*"}}
+	if expectedVersion != noVersion {
+		header = http.Header{"If-Match": {strconv.Quote(strconv.FormatUint(expectedVersion, 10))}}
+	}
+	response, err := client.put(ctx, key, value, 0, header, false)
+	if err != nil {
+		return noVersion, err
+	}
+	return parseETag(response.Header.Get("ETag"))
+}
+
+func (client *Client) put(ctx context.Context, key []byte, value []byte, ttl time.Duration, header http.Header, idempotent bool) (*http.Response, error) {
+	query := url.Values{"key": {string(key)}}
+	if ttl > 0 {
+		query.Set("ttl", strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10))
+	}
+	response, _, err := client.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/",
+		query:       query,
+		header:      header,
+		body:        func() []byte { return value },
+		contentType: mimeOctetStream,
+		idempotent:  idempotent,
+	})
+	return response, err
+}
+
+//Delete the key, deleting missing key isn't an error
+func (client *Client) Delete(ctx context.Context, key []byte) error {
+	_, _, err := client.do(ctx, request{
+		method:     http.MethodDelete,
+		path:       "/",
+		query:      url.Values{"key": {string(key)}},
+		idempotent: true,
+	})
+	return err
+}
+
+//Key and its value
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+//Keys in [start,end) in the order of the server, nil end means there is no upper bound
+//pages of ScanPageSize keys are requested until limit keys are read, 0 means no limit
+//keys that are written during the scan may be missed
+func (client *Client) Scan(ctx context.Context, start []byte, end []byte, limit int) ([]KeyValue, error) {
+	var items []KeyValue
+	query := url.Values{"start": {string(start)}, "encoding": {base64Encoding}}
+	if end != nil {
+		query.Set("end", string(end))
+	}
+	for {
+		pageSize := client.options.ScanPageSize
+		if limit != 0 && limit-len(items) < pageSize {
+			pageSize = limit - len(items)
+		}
+		query.Set("limit", strconv.Itoa(pageSize))
+		_, body, err := client.do(ctx, request{method: http.MethodGet, path: "/scan", query: query, idempotent: true})
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"items"`
+			More bool `json:"more"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("invalid scan response: %w", err)
+		}
+		for _, item := range page.Items {
+			key, err := base64.StdEncoding.DecodeString(item.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scan response: %w", err)
+			}
+			value, err := base64.StdEncoding.DecodeString(item.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scan response: %w", err)
+			}
+			items = append(items, KeyValue{Key: key, Value: value})
+		}
+		if !page.More || len(page.Items) == 0 || (limit != 0 && len(items) >= limit) {
+			return items, nil
+		}
+		query.Set("after", string(items[len(items)-1].Key))
+	}
+}
+
+func parseETag(etag string) (uint64, error) {
+	unquoted, err := strconv.Unquote(etag)
+	if err != nil {
+		unquoted = etag
+	}
+	version, err := strconv.ParseUint(unquoted, 10, 64)
+	if err != nil {
+		return noVersion, fmt.Errorf("invalid ETag %s", etag)
+	}
+	return version, nil
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	server "wiskey/http"
+	wiskey "wiskey/pkg"
+)
+
+//Start http server on unix socket, it's stopped when the test finishes
+func startServer(t *testing.T, options *wiskey.Options, clientOptions *Options) *Client {
+	dir := t.TempDir()
+	if options == nil {
+		options = wiskey.DefaultOptions()
+	}
+	options.Logger = wiskey.NopLogger()
+	db, err := wiskey.Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := server.DefaultConfig()
+	config.Socket = filepath.Join(dir, "wiskey.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.StartContext(ctx, db, config)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	client, err := New("unix://"+config.Socket, clientOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		_, _, err := client.Get(context.Background(), []byte("ready"))
+		if errors.Is(err, ErrNotFound) {
+			return client
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("server didn't start: %v", err)
+		}
+	}
+}
+
+func TestClient_PutGetDelete(t *testing.T) {
+	client := startServer(t, nil, nil)
+	ctx := context.Background()
+	key := []byte{'a', '/', 'b', ' ', 0xff}
+	value := []byte{0, 1, 0xfe}
+	if err := client.Put(ctx, key, value); err != nil {
+		t.Fatal(err)
+	}
+	got, version, err := client.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) || version == noVersion {
+		t.Errorf("expected %v with version, got %v with version %d", value, got, version)
+	}
+	if err := client.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.Get(ctx, key)
+	var clientErr *Error
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &clientErr) || clientErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestClient_CompareAndSwap(t *testing.T) {
+	client := startServer(t, nil, nil)
+	ctx := context.Background()
+	key := []byte("key")
+	version, err := client.CompareAndSwap(ctx, key, noVersion, []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CompareAndSwap(ctx, key, noVersion, []byte("again")); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected conflict for existing key, got %v", err)
+	}
+	newVersion, err := client.CompareAndSwap(ctx, key, version, []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CompareAndSwap(ctx, key, version, []byte("third")); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected conflict for old version, got %v", err)
+	}
+	value, current, err := client.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "second" || current != newVersion {
+		t.Errorf("expected second with version %d, got %s with version %d", newVersion, value, current)
+	}
+}
+
+func TestClient_Scan(t *testing.T) {
+	options := DefaultOptions()
+	options.ScanPageSize = 3
+	client := startServer(t, nil, options)
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if err := client.Put(ctx, []byte(fmt.Sprintf("k%02d", i)), []byte{byte(i), 0xff}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items, err := client.Scan(ctx, []byte("k02"), []byte("k08"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 6 {
+		t.Fatalf("expected 6 keys, got %d", len(items))
+	}
+	for i, item := range items {
+		if string(item.Key) != fmt.Sprintf("k%02d", i+2) || !bytes.Equal(item.Value, []byte{byte(i + 2), 0xff}) {
+			t.Errorf("unexpected item %d: %s=%v", i, item.Key, item.Value)
+		}
+	}
+	items, err = client.Scan(ctx, nil, nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 4 || string(items[3].Key) != "k03" {
+		t.Errorf("expected 4 keys up to k03, got %d", len(items))
+	}
+	items, err = client.Scan(ctx, []byte("z"), nil, 0)
+	if err != nil || len(items) != 0 {
+		t.Errorf("expected no keys, got %d, %v", len(items), err)
+	}
+}
+
+func TestClient_Batch(t *testing.T) {
+	client := startServer(t, nil, nil)
+	ctx := context.Background()
+	err := client.Batch(ctx, NewBatch().Put([]byte("a"), []byte{0xff}).Put([]byte("b"), []byte("2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, version, err := client.Get(ctx, []byte("a"))
+	if err != nil || !bytes.Equal(value, []byte{0xff}) {
+		t.Fatalf("expected batch value, got %v, %v", value, err)
+	}
+	//failed precondition doesn't apply any operation
+	err = client.Batch(ctx, NewBatch().Require([]byte("a"), version+1).Delete([]byte("a")).Put([]byte("c"), []byte("3")))
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected conflict, got %v", err)
+	}
+	if _, _, err := client.Get(ctx, []byte("c")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected c to be missing, got %v", err)
+	}
+	err = client.Batch(ctx, NewBatch().Require([]byte("a"), version).Delete([]byte("a")).Put([]byte("c"), []byte("3")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Get(ctx, []byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a to be deleted, got %v", err)
+	}
+	if value, _, err := client.Get(ctx, []byte("c")); err != nil || string(value) != "3" {
+		t.Errorf("expected c=3, got %s, %v", value, err)
+	}
+}
+
+func TestClient_Family(t *testing.T) {
+	options := wiskey.DefaultOptions()
+	options.ColumnFamilies = []wiskey.ColumnFamilyOptions{{Name: "cf"}}
+	client := startServer(t, options, nil)
+	ctx := context.Background()
+	if err := client.Family("cf").Put(ctx, []byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Get(ctx, []byte("key")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected key to be missing in default family, got %v", err)
+	}
+	if value, _, err := client.Family("cf").Get(ctx, []byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected value in column family, got %s, %v", value, err)
+	}
+	if err := client.Family("missing").Put(ctx, []byte("key"), []byte("value")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found for missing family, got %v", err)
+	}
+}
+
+//Server that is unavailable for given number of requests
+func unavailableServer(t *testing.T, failures int32) (*httptest.Server, *int32) {
+	requests := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"writes are stopped until compaction catches up"}`))
+			return
+		}
+		w.Header().Set("ETag", `"7"`)
+		w.Write([]byte("value"))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestClient_Retry(t *testing.T) {
+	server, requests := unavailableServer(t, 2)
+	options := DefaultOptions()
+	options.RetryDelay = time.Millisecond
+	client, err := New(server.URL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, version, err := client.Get(context.Background(), []byte("key"))
+	if err != nil || string(value) != "value" || version != 7 {
+		t.Fatalf("expected value after retries, got %s, %d, %v", value, version, err)
+	}
+	if *requests != 3 {
+		t.Errorf("expected 3 requests, got %d", *requests)
+	}
+
+	server, requests = unavailableServer(t, 10)
+	options.MaxRetries = 1
+	client, err = New(server.URL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.Get(context.Background(), []byte("key"))
+	var clientErr *Error
+	if !errors.Is(err, ErrUnavailable) || !errors.As(err, &clientErr) || clientErr.Message == "" {
+		t.Errorf("expected unavailable error with message, got %v", err)
+	}
+	if *requests != 2 {
+		t.Errorf("expected 2 requests, got %d", *requests)
+	}
+}
+
+func TestClient_Context(t *testing.T) {
+	server, _ := unavailableServer(t, 1000)
+	options := DefaultOptions()
+	options.MaxRetries = 1000
+	client, err := New(server.URL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = client.Get(ctx, []byte("key"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestClient_ConnectionRefused(t *testing.T) {
+	options := DefaultOptions()
+	options.RetryDelay = time.Millisecond
+	client, err := New("unix://"+filepath.Join(t.TempDir(), "missing.sock"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Batch(context.Background(), NewBatch().Put([]byte("key"), []byte("value")))
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected unavailable error, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrUnavailable = errors.New("server is unavailable")
+)
+
+//Error of the request, it wraps ErrNotFound, ErrConflict or ErrUnavailable if it's one of them
+type Error struct {
+	StatusCode int    //status of the response, 0 if the server didn't respond
+	Message    string //error message of the server
+	kind       error
+}
+
+func (err *Error) Error() string {
+	message := err.Message
+	if message == "" && err.kind != nil {
+		message = err.kind.Error()
+	}
+	if err.StatusCode == 0 {
+		return "wiskey: " + message
+	}
+	return "wiskey: " + strconv.Itoa(err.StatusCode) + " " + message
+}
+
+func (err *Error) Unwrap() error {
+	return err.kind
+}
+
+//Error from the response with status >= 400
+func responseError(response *http.Response, body []byte) error {
+	err := &Error{StatusCode: response.StatusCode}
+	var message struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &message) == nil {
+		err.Message = message.Error
+	}
+	switch response.StatusCode {
+	case http.StatusNotFound:
+		err.kind = ErrNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		err.kind = ErrConflict
+	case http.StatusServiceUnavailable:
+		err.kind = ErrUnavailable
+	}
+	return err
+}
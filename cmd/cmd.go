@@ -1,12 +1,62 @@
 package cmd
 
-import "github.com/jessevdk/go-flags"
+import (
+	"errors"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wiskey/http"
+	"wiskey/memcached"
+	wiskey "wiskey/pkg"
+)
 
 type options struct {
-	SStablePath  string `short:"s" long:"sstable" description:"A path to sstable directory" required:"true"`
-	Vlog         string `short:"v"  description:"A path to vlog file" required:"true"`
-	Checkpoint   string `short:"c" long:"checkpoint"  description:"A path to checkpoint file" required:"true"`
-	MemtableSize int    `short:"m" long:"memtable" description:"size of memtable" default:"20"`
+	Dir             string        `short:"d" long:"dir" description:"A path to data directory with vlog, checkpoint and sstables"`
+	SStablePath     string        `short:"s" long:"sstable" description:"A path to sstable directory, it's used with -v and -c instead of -d"`
+	Vlog            string        `short:"v"  description:"A path to vlog file"`
+	Checkpoint      string        `short:"c" long:"checkpoint"  description:"A path to checkpoint file"`
+	MemtableSize    int           `short:"m" long:"memtable" description:"size of memtable" default:"20"`
+	MergeOperator   string        `long:"merge-operator" description:"merge operator that is used for merge requests" choice:"int64add" choice:"append"`
+	Comparator      string        `long:"comparator" description:"order of keys, can't be changed after sstables were created" choice:"bytewise" choice:"reverse-bytewise" default:"bytewise"`
+	ReadOnly        bool          `long:"read-only" description:"open the store without the lock and reject writes"`
+	LogLevel        string        `long:"log-level" description:"minimal level of log messages" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info"`
+	Stall           stallOptions  `group:"Write stalls"`
+	Server          serverOptions `group:"HTTP server"`
+	GrpcAddr        string        `long:"grpc-addr" description:"tcp address of grpc server, grpc server isn't started if it's empty"`
+	RedisAddr       string        `long:"redis-addr" description:"tcp address of redis protocol server, it isn't started if it's empty"`
+	MemcachedAddr   string        `long:"memcached-addr" description:"tcp address of memcached text protocol server, it isn't started if it's empty"`
+	MemcachedFamily string        `long:"memcached-family" description:"column family that is served by memcached server, default one if it's empty, flags of items are kept in <family>-flags(memcached-flags for the default one) that is opened automatically"`
+	Families        []string      `short:"f" long:"family" description:"column family to open in format name[:memtable size[:merge interval in seconds]], can be repeated"`
+}
+
+//Triggers of write stalls, 0 disables the trigger
+type stallOptions struct {
+	SlowdownSStables     int           `long:"slowdown-sstables" description:"slow down writes when column family has this many sstables"`
+	StopSStables         int           `long:"stop-sstables" description:"reject writes when column family has this many sstables"`
+	SlowdownPendingBytes int64         `long:"slowdown-pending-bytes" description:"slow down writes when sstables waiting for the merge have this size"`
+	StopPendingBytes     int64         `long:"stop-pending-bytes" description:"reject writes when sstables waiting for the merge have this size"`
+	SlowdownVlogGarbage  int64         `long:"slowdown-vlog-garbage" description:"slow down writes when vlog has this many bytes of garbage"`
+	StopVlogGarbage      int64         `long:"stop-vlog-garbage" description:"reject writes when vlog has this many bytes of garbage"`
+	SlowdownDelay        time.Duration `long:"slowdown-delay" description:"delay of every write during slowdown" default:"1ms"`
+	IORateLimit          int64         `long:"io-rate-limit" description:"bytes per second written by flush and merge, 0 means unlimited"`
+}
+
+//Settings of http server
+type serverOptions struct {
+	Addr              string        `long:"addr" description:"tcp address to listen on" default:":8080"`
+	Socket            string        `long:"socket" description:"path to unix socket to listen on instead of tcp address"`
+	TLSCert           string        `long:"tls-cert" description:"path to TLS certificate, it's used with --tls-key"`
+	TLSKey            string        `long:"tls-key" description:"path to TLS private key"`
+	TLSClientCA       string        `long:"tls-client-ca" description:"path to CA that signs client certificates, clients without certificate are rejected"`
+	ReadHeaderTimeout time.Duration `long:"read-header-timeout" description:"max time to read request headers, 0 means no timeout" default:"10s"`
+	ReadTimeout       time.Duration `long:"read-timeout" description:"max time to read the whole request, 0 means no timeout" default:"1m"`
+	WriteTimeout      time.Duration `long:"write-timeout" description:"max time to write the response, 0 means no timeout" default:"1m"`
+	IdleTimeout       time.Duration `long:"idle-timeout" description:"max time to keep idle connection, 0 means no timeout" default:"2m"`
+	AuthConfig        string        `long:"auth-config" description:"path to json file with bearer tokens and hmac secrets, requests without token are rejected"`
+	MaxValueSize      int64         `long:"max-value-size" description:"max size of value in bytes, bigger values are rejected with 413, 0 means unlimited" default:"16777216"`
 }
 
 func Parse() (*options, error) {
@@ -15,6 +65,107 @@ func Parse() (*options, error) {
 	if err != nil {
 		return nil, err
 	}
+	if options.Dir == "" && (options.SStablePath == "" || options.Vlog == "" || options.Checkpoint == "") {
+		return nil, errors.New("either data directory -d or sstable directory -s, vlog -v and checkpoint -c are required")
+	}
+	if options.Dir != "" && (options.SStablePath != "" || options.Vlog != "" || options.Checkpoint != "") {
+		return nil, errors.New("data directory -d can't be used together with -s, -v and -c")
+	}
 	return &options, nil
 
 }
+
+//Settings of the database
+func (o *options) DBOptions() (*wiskey.Options, error) {
+	dbOptions := wiskey.DefaultOptions()
+	dbOptions.MemtableSize = o.MemtableSize
+	dbOptions.ReadOnly = o.ReadOnly
+	level, err := wiskey.ParseLogLevel(o.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	dbOptions.Logger = wiskey.NewTextLogger(os.Stderr, level)
+	dbOptions.WriteStall = wiskey.WriteStallOptions{
+		SlowdownSStables:     o.Stall.SlowdownSStables,
+		StopSStables:         o.Stall.StopSStables,
+		SlowdownPendingBytes: o.Stall.SlowdownPendingBytes,
+		StopPendingBytes:     o.Stall.StopPendingBytes,
+		SlowdownVlogGarbage:  o.Stall.SlowdownVlogGarbage,
+		StopVlogGarbage:      o.Stall.StopVlogGarbage,
+		SlowdownDelay:        o.Stall.SlowdownDelay,
+	}
+	dbOptions.IORateLimit = o.Stall.IORateLimit
+	comparator, err := wiskey.ComparatorByName(o.Comparator)
+	if err != nil {
+		return nil, err
+	}
+	dbOptions.Comparator = comparator
+	if o.MergeOperator != "" {
+		operator, err := wiskey.MergeOperatorByName(o.MergeOperator)
+		if err != nil {
+			return nil, err
+		}
+		dbOptions.MergeOperator = operator
+	}
+	families, err := o.columnFamilies()
+	if err != nil {
+		return nil, err
+	}
+	dbOptions.ColumnFamilies = families
+	return dbOptions, nil
+}
+
+//Parse column families, memtable size and merge interval are the same as in default family if not specified
+func (o *options) columnFamilies() ([]wiskey.ColumnFamilyOptions, error) {
+	var families []wiskey.ColumnFamilyOptions
+	for _, family := range o.Families {
+		parts := strings.Split(family, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("invalid column family %s", family)
+		}
+		familyOptions := wiskey.ColumnFamilyOptions{Name: parts[0]}
+		if len(parts) > 1 {
+			size, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid memtable size of column family %s", family)
+			}
+			familyOptions.MemtableSize = size
+		}
+		if len(parts) > 2 {
+			gc, err := strconv.ParseUint(parts[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid merge interval of column family %s", family)
+			}
+			familyOptions.MergeInterval = uint(gc)
+		}
+		families = append(families, familyOptions)
+	}
+	//flags of memcached items are kept in their own family that is opened with the same settings as the default one
+	if o.MemcachedAddr != "" {
+		flagsFamily := memcached.FlagsFamily(o.MemcachedFamily)
+		for _, family := range families {
+			if family.Name == flagsFamily {
+				return families, nil
+			}
+		}
+		families = append(families, wiskey.ColumnFamilyOptions{Name: flagsFamily})
+	}
+	return families, nil
+}
+
+//Settings of http server
+func (o *options) ServerConfig() *http.Config {
+	return &http.Config{
+		Addr:              o.Server.Addr,
+		Socket:            o.Server.Socket,
+		TLSCert:           o.Server.TLSCert,
+		TLSKey:            o.Server.TLSKey,
+		TLSClientCA:       o.Server.TLSClientCA,
+		ReadHeaderTimeout: o.Server.ReadHeaderTimeout,
+		ReadTimeout:       o.Server.ReadTimeout,
+		WriteTimeout:      o.Server.WriteTimeout,
+		IdleTimeout:       o.Server.IdleTimeout,
+		MaxValueSize:      o.Server.MaxValueSize,
+		AuthConfig:        o.Server.AuthConfig,
+	}
+}
@@ -6,4 +6,6 @@ require (
 	github.com/emirpasic/gods v1.12.0
 	github.com/gin-gonic/gin v1.7.2
 	github.com/jessevdk/go-flags v1.5.0
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
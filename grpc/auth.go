@@ -0,0 +1,97 @@
+package grpc
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"wiskey/http"
+)
+
+//Key of the grant in the context of the request
+type grantKey struct{}
+
+//Stream with the context that keeps the grant
+type grantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *grantStream) Context() context.Context {
+	return stream.ctx
+}
+
+//Find the grant of bearer token in authorization metadata, the same tokens as http server are accepted
+//nil authenticator means that auth is disabled
+func authenticate(ctx context.Context, auth *http.Authenticator) (context.Context, error) {
+	if auth == nil {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "bearer token is required")
+	}
+	grant, err := auth.Authenticate(strings.TrimPrefix(values[0], "Bearer "))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(ctx, grantKey{}, grant), nil
+}
+
+func authenticateUnary(auth *http.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, auth)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, request)
+	}
+}
+
+func authenticateStream(auth *http.Authenticator) grpc.StreamServerInterceptor {
+	return func(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), auth)
+		if err != nil {
+			return err
+		}
+		return handler(server, &grantStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+//Grant of the request, nil if auth is disabled
+func requestGrant(ctx context.Context) *http.Grant {
+	grant, _ := ctx.Value(grantKey{}).(*http.Grant)
+	return grant
+}
+
+//Check that the request is allowed to run the operation with given keys, PERMISSION_DENIED is returned otherwise
+func authorize(ctx context.Context, operation string, keys ...[]byte) error {
+	grant := requestGrant(ctx)
+	if grant == nil {
+		return nil
+	}
+	if !grant.Allows(operation) {
+		return status.Errorf(codes.PermissionDenied, "%s operation is not allowed", operation)
+	}
+	for _, key := range keys {
+		if !grant.AllowsKey(key) {
+			return status.Errorf(codes.PermissionDenied, "key %q is not allowed", key)
+		}
+	}
+	return nil
+}
+
+//Check that the request is allowed to read all keys in [start,end), nil end means there is no upper bound
+func authorizeRange(ctx context.Context, operation string, start []byte, end []byte) error {
+	if err := authorize(ctx, operation); err != nil {
+		return err
+	}
+	grant := requestGrant(ctx)
+	if grant != nil && !grant.AllowsRange(start, end) {
+		return status.Errorf(codes.PermissionDenied, "range [%q,%q) is not allowed", start, end)
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// WiskeyClient is the client API for Wiskey service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WiskeyClient interface {
+	// Get the value of the key
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Save the value of the key
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	// Delete the key
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Apply puts and deletes atomically
+	Batch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// Stream live keys in [start,end) in sorted order
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (Wiskey_ScanClient, error)
+	// Stream changes of keys with the prefix until the client cancels the call
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Wiskey_WatchClient, error)
+}
+
+type wiskeyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWiskeyClient(cc grpc.ClientConnInterface) WiskeyClient {
+	return &wiskeyClient{cc}
+}
+
+func (c *wiskeyClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/wiskey.v1.Wiskey/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wiskeyClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, "/wiskey.v1.Wiskey/Put", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wiskeyClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/wiskey.v1.Wiskey/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wiskeyClient) Batch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/wiskey.v1.Wiskey/Batch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wiskeyClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (Wiskey_ScanClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Wiskey_ServiceDesc.Streams[0], "/wiskey.v1.Wiskey/Scan", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &wiskeyScanClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Wiskey_ScanClient interface {
+	Recv() (*KeyValue, error)
+	grpc.ClientStream
+}
+
+type wiskeyScanClient struct {
+	grpc.ClientStream
+}
+
+func (x *wiskeyScanClient) Recv() (*KeyValue, error) {
+	m := new(KeyValue)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *wiskeyClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Wiskey_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Wiskey_ServiceDesc.Streams[1], "/wiskey.v1.Wiskey/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &wiskeyWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Wiskey_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type wiskeyWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *wiskeyWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WiskeyServer is the server API for Wiskey service.
+// All implementations must embed UnimplementedWiskeyServer
+// for forward compatibility
+type WiskeyServer interface {
+	// Get the value of the key
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Save the value of the key
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	// Delete the key
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Apply puts and deletes atomically
+	Batch(context.Context, *BatchRequest) (*BatchResponse, error)
+	// Stream live keys in [start,end) in sorted order
+	Scan(*ScanRequest, Wiskey_ScanServer) error
+	// Stream changes of keys with the prefix until the client cancels the call
+	Watch(*WatchRequest, Wiskey_WatchServer) error
+	mustEmbedUnimplementedWiskeyServer()
+}
+
+// UnimplementedWiskeyServer must be embedded to have forward compatible implementations.
+type UnimplementedWiskeyServer struct {
+}
+
+func (UnimplementedWiskeyServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedWiskeyServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedWiskeyServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedWiskeyServer) Batch(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Batch not implemented")
+}
+func (UnimplementedWiskeyServer) Scan(*ScanRequest, Wiskey_ScanServer) error {
+	return status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedWiskeyServer) Watch(*WatchRequest, Wiskey_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedWiskeyServer) mustEmbedUnimplementedWiskeyServer() {}
+
+// UnsafeWiskeyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WiskeyServer will
+// result in compilation errors.
+type UnsafeWiskeyServer interface {
+	mustEmbedUnimplementedWiskeyServer()
+}
+
+func RegisterWiskeyServer(s grpc.ServiceRegistrar, srv WiskeyServer) {
+	s.RegisterService(&Wiskey_ServiceDesc, srv)
+}
+
+func _Wiskey_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WiskeyServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wiskey.v1.Wiskey/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WiskeyServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wiskey_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WiskeyServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wiskey.v1.Wiskey/Put",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WiskeyServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wiskey_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WiskeyServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wiskey.v1.Wiskey/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WiskeyServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wiskey_Batch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WiskeyServer).Batch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wiskey.v1.Wiskey/Batch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WiskeyServer).Batch(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wiskey_Scan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WiskeyServer).Scan(m, &wiskeyScanServer{stream})
+}
+
+type Wiskey_ScanServer interface {
+	Send(*KeyValue) error
+	grpc.ServerStream
+}
+
+type wiskeyScanServer struct {
+	grpc.ServerStream
+}
+
+func (x *wiskeyScanServer) Send(m *KeyValue) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Wiskey_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WiskeyServer).Watch(m, &wiskeyWatchServer{stream})
+}
+
+type Wiskey_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type wiskeyWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *wiskeyWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Wiskey_ServiceDesc is the grpc.ServiceDesc for Wiskey service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Wiskey_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "wiskey.v1.Wiskey",
+	HandlerType: (*WiskeyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _Wiskey_Get_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _Wiskey_Put_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Wiskey_Delete_Handler,
+		},
+		{
+			MethodName: "Batch",
+			Handler:    _Wiskey_Batch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			Handler:       _Wiskey_Scan_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _Wiskey_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "wiskey.proto",
+}
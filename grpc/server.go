@@ -0,0 +1,304 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"time"
+	"wiskey/grpc/pb"
+	"wiskey/http"
+	. "wiskey/pkg"
+)
+
+const (
+	watchBuffer     = 1024     //changes that can wait until they are sent to watch stream
+	messageOverhead = 64 << 10 //key, family and ttl next to the value in a message
+)
+
+//gRPC server of the tree, it works with the same column families as http server
+type Server struct {
+	server   *grpc.Server
+	listener net.Listener
+	stop     chan struct{}
+}
+
+//Listen on tcp address, requests are served after Serve is called
+//tokens, TLS and max value size are the same as in the config of http server, nil config means defaults
+func Listen(db *DB, addr string, config *http.Config) (*Server, error) {
+	if config == nil {
+		config = http.DefaultConfig()
+	}
+	auth, err := config.Auth()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(authenticateUnary(auth)),
+		grpc.StreamInterceptor(authenticateStream(auth)),
+		grpc.MaxRecvMsgSize(maxMessageSize(config.MaxValueSize)),
+	}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{server: grpc.NewServer(options...), listener: listener, stop: make(chan struct{})}
+	pb.RegisterWiskeyServer(server.server, &service{lsm: db.LsmTree, stop: server.stop, maxValueSize: config.MaxValueSize})
+	return server, nil
+}
+
+//Address the server listens on
+func (server *Server) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+//Messages can keep one value of max size, 0 means unlimited
+func maxMessageSize(maxValueSize int64) int {
+	if maxValueSize == 0 || maxValueSize > math.MaxInt32-messageOverhead {
+		return math.MaxInt32
+	}
+	return int(maxValueSize) + messageOverhead
+}
+
+//Serve requests until the server is stopped
+func (server *Server) Serve() error {
+	err := server.server.Serve(server.listener)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+//Close watch streams and wait for in-flight requests until the context is done
+func (server *Server) Shutdown(ctx context.Context) {
+	close(server.stop)
+	done := make(chan struct{})
+	go func() {
+		server.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		server.server.Stop()
+	}
+}
+
+type service struct {
+	pb.UnimplementedWiskeyServer
+	lsm          *LsmTree
+	stop         chan struct{}
+	maxValueSize int64 //0 means unlimited
+}
+
+//Default column family for empty name
+func (s *service) family(name string) (*LsmTree, error) {
+	if name == "" {
+		return s.lsm, nil
+	}
+	family, found := s.lsm.ColumnFamily(name)
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "column family %s is not opened", name)
+	}
+	return family, nil
+}
+
+func (s *service) Get(ctx context.Context, request *pb.GetRequest) (*pb.GetResponse, error) {
+	if err := authorize(ctx, http.PermissionRead, request.GetKey()); err != nil {
+		return nil, err
+	}
+	lsm, err := s.family(request.GetFamily())
+	if err != nil {
+		return nil, err
+	}
+	value, version, found, err := lsm.GetWithVersion(request.GetKey())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.GetResponse{Found: found, Value: value, Version: version}, nil
+}
+
+func (s *service) Put(ctx context.Context, request *pb.PutRequest) (*pb.PutResponse, error) {
+	if err := authorize(ctx, http.PermissionWrite, request.GetKey()); err != nil {
+		return nil, err
+	}
+	if err := s.checkValueSize(request); err != nil {
+		return nil, err
+	}
+	lsm, err := s.family(request.GetFamily())
+	if err != nil {
+		return nil, err
+	}
+	entry := putEntry(request)
+	err = lsm.Put(&entry)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.PutResponse{}, nil
+}
+
+func (s *service) Delete(ctx context.Context, request *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if err := authorize(ctx, http.PermissionWrite, request.GetKey()); err != nil {
+		return nil, err
+	}
+	lsm, err := s.family(request.GetFamily())
+	if err != nil {
+		return nil, err
+	}
+	err = lsm.Delete(request.GetKey())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+//Apply all operations atomically
+func (s *service) Batch(ctx context.Context, request *pb.BatchRequest) (*pb.BatchResponse, error) {
+	keys := make([][]byte, 0, len(request.GetOperations()))
+	for _, operation := range request.GetOperations() {
+		if put := operation.GetPut(); put != nil {
+			keys = append(keys, put.GetKey())
+			if err := s.checkValueSize(put); err != nil {
+				return nil, err
+			}
+		} else if del := operation.GetDelete(); del != nil {
+			keys = append(keys, del.GetKey())
+		}
+	}
+	if err := authorize(ctx, http.PermissionWrite, keys...); err != nil {
+		return nil, err
+	}
+	lsm, err := s.family(request.GetFamily())
+	if err != nil {
+		return nil, err
+	}
+	tx := lsm.Begin()
+	for _, operation := range request.GetOperations() {
+		if put := operation.GetPut(); put != nil {
+			entry := putEntry(put)
+			err = tx.PutEntry(&entry)
+		} else if del := operation.GetDelete(); del != nil {
+			err = tx.Delete(del.GetKey())
+		} else {
+			err = status.Error(codes.InvalidArgument, "operation has to be put or delete")
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, statusError(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.BatchResponse{}, nil
+}
+
+//Stream keys in [start,end), empty end means there is no upper bound
+func (s *service) Scan(request *pb.ScanRequest, stream pb.Wiskey_ScanServer) error {
+	var end []byte
+	if len(request.GetEnd()) != 0 {
+		end = request.GetEnd()
+	}
+	if err := authorizeRange(stream.Context(), http.PermissionRead, request.GetStart(), end); err != nil {
+		return err
+	}
+	lsm, err := s.family(request.GetFamily())
+	if err != nil {
+		return err
+	}
+	iterator := lsm.NewIterator(request.GetStart(), end)
+	for sent := uint32(0); request.GetLimit() == 0 || sent < request.GetLimit(); sent++ {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		if !iterator.Next() {
+			break
+		}
+		err = stream.Send(&pb.KeyValue{Key: iterator.Key(), Value: iterator.Value()})
+		if err != nil {
+			return err
+		}
+	}
+	return statusError(iterator.Err())
+}
+
+//Stream changes of keys with the prefix until the client goes away or the server is stopped
+func (s *service) Watch(request *pb.WatchRequest, stream pb.Wiskey_WatchServer) error {
+	if err := authorize(stream.Context(), http.PermissionRead, request.GetPrefix()); err != nil {
+		return err
+	}
+	lsm, err := s.family(request.GetFamily())
+	if err != nil {
+		return err
+	}
+	watcher := lsm.Watch(request.GetPrefix(), watchBuffer)
+	defer watcher.Close()
+	for {
+		select {
+		case change, ok := <-watcher.Changes():
+			if !ok {
+				return statusError(watcher.Err())
+			}
+			err = stream.Send(&pb.WatchEvent{
+				Type:    pb.WatchEvent_Type(change.Type), //enum values are in the same order as change types
+				Key:     change.Key,
+				End:     change.End,
+				Value:   change.Value,
+				Version: change.Version,
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-s.stop:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+//Values bigger than max value size are rejected with RESOURCE_EXHAUSTED
+func (s *service) checkValueSize(request *pb.PutRequest) error {
+	if s.maxValueSize != 0 && int64(len(request.GetValue())) > s.maxValueSize {
+		return status.Errorf(codes.ResourceExhausted, "value of key %q is bigger than %d bytes", request.GetKey(), s.maxValueSize)
+	}
+	return nil
+}
+
+func putEntry(request *pb.PutRequest) TableEntry {
+	if request.GetTtlSeconds() != 0 {
+		return NewEntryWithTTL(request.GetKey(), request.GetValue(), time.Duration(request.GetTtlSeconds())*time.Second)
+	}
+	return NewEntry(request.GetKey(), request.GetValue())
+}
+
+//Status with the code that matches the error of the tree
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, ErrWatcherLagged), errors.Is(err, ErrConflict):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, ErrWriteStall), errors.Is(err, ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrReadOnly), errors.Is(err, ErrVersionMismatch):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,331 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+	"wiskey/grpc/pb"
+	"wiskey/http"
+	. "wiskey/pkg"
+)
+
+// Open the store in temporary directory and serve it on a random port
+func startTestServer(t *testing.T, config *http.Config, dialOptions ...grpc.DialOption) (*DB, pb.WiskeyClient) {
+	options := DefaultOptions()
+	options.Logger = NopLogger()
+	db, err := Open(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := Listen(db, "127.0.0.1:0", config)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	go server.Serve()
+	if len(dialOptions) == 0 {
+		dialOptions = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	conn, err := grpc.Dial(server.Addr().String(), dialOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Shutdown(context.Background())
+		db.Close()
+	})
+	return db, pb.NewWiskeyClient(conn)
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func assertCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("expected %s, got %v", code, err)
+	}
+}
+
+func scanKeys(t *testing.T, ctx context.Context, client pb.WiskeyClient, request *pb.ScanRequest) ([]string, error) {
+	stream, err := client.Scan(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for {
+		kv, err := stream.Recv()
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, string(kv.GetKey()))
+	}
+}
+
+func TestGetPut(t *testing.T) {
+	_, client := startTestServer(t, nil)
+	ctx := context.Background()
+	response, err := client.Get(ctx, &pb.GetRequest{Key: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetFound() {
+		t.Fatal("missing key is found")
+	}
+	_, err = client.Put(ctx, &pb.PutRequest{Key: []byte("key"), Value: []byte("value")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = client.Get(ctx, &pb.GetRequest{Key: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.GetFound() || string(response.GetValue()) != "value" || response.GetVersion() == 0 {
+		t.Fatalf("unexpected response %v", response)
+	}
+	_, err = client.Get(ctx, &pb.GetRequest{Family: "unknown", Key: []byte("key")})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestDelete(t *testing.T) {
+	_, client := startTestServer(t, nil)
+	ctx := context.Background()
+	_, err := client.Put(ctx, &pb.PutRequest{Key: []byte("key"), Value: []byte("value")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Key: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Get(ctx, &pb.GetRequest{Key: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetFound() {
+		t.Fatal("deleted key is found")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	_, client := startTestServer(t, nil)
+	ctx := context.Background()
+	_, err := client.Put(ctx, &pb.PutRequest{Key: []byte("a"), Value: []byte("1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Batch(ctx, &pb.BatchRequest{Operations: []*pb.Operation{
+		{Op: &pb.Operation_Put{Put: &pb.PutRequest{Key: []byte("b"), Value: []byte("2")}}},
+		{Op: &pb.Operation_Delete{Delete: &pb.DeleteRequest{Key: []byte("a")}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := scanKeys(t, ctx, client, &pb.ScanRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "b" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	//nothing is applied if one of operations is invalid
+	_, err = client.Batch(ctx, &pb.BatchRequest{Operations: []*pb.Operation{
+		{Op: &pb.Operation_Put{Put: &pb.PutRequest{Key: []byte("c"), Value: []byte("3")}}},
+		{},
+	}})
+	assertCode(t, err, codes.InvalidArgument)
+	response, err := client.Get(ctx, &pb.GetRequest{Key: []byte("c")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.GetFound() {
+		t.Fatal("put of invalid batch is applied")
+	}
+}
+
+func TestScan(t *testing.T) {
+	_, client := startTestServer(t, nil)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		_, err := client.Put(ctx, &pb.PutRequest{Key: []byte(key), Value: []byte(key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]struct {
+		request *pb.ScanRequest
+		keys    string
+	}{
+		"all":      {&pb.ScanRequest{}, "abcde"},
+		"range":    {&pb.ScanRequest{Start: []byte("b"), End: []byte("d")}, "bc"},
+		"no end":   {&pb.ScanRequest{Start: []byte("c")}, "cde"},
+		"limit":    {&pb.ScanRequest{Start: []byte("b"), Limit: 2}, "bc"},
+		"no match": {&pb.ScanRequest{Start: []byte("x")}, ""},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			keys, err := scanKeys(t, ctx, client, test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			joined := ""
+			for _, key := range keys {
+				joined += key
+			}
+			if joined != test.keys {
+				t.Fatalf("expected %s, got %s", test.keys, joined)
+			}
+		})
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	config := http.DefaultConfig()
+	config.MaxValueSize = 8
+	_, client := startTestServer(t, config)
+	ctx := context.Background()
+	_, err := client.Put(ctx, &pb.PutRequest{Key: []byte("key"), Value: []byte("12345678")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Put(ctx, &pb.PutRequest{Key: []byte("key"), Value: []byte("123456789")})
+	assertCode(t, err, codes.ResourceExhausted)
+	_, err = client.Batch(ctx, &pb.BatchRequest{Operations: []*pb.Operation{
+		{Op: &pb.Operation_Put{Put: &pb.PutRequest{Key: []byte("key"), Value: []byte("123456789")}}},
+	}})
+	assertCode(t, err, codes.ResourceExhausted)
+	//message is rejected before it's decoded
+	_, err = client.Put(ctx, &pb.PutRequest{Key: []byte("key"), Value: bytes.Repeat([]byte("a"), messageOverhead+8)})
+	assertCode(t, err, codes.ResourceExhausted)
+}
+
+func TestAuth(t *testing.T) {
+	content, err := json.Marshal(http.AuthConfig{Tokens: []http.StaticToken{
+		{Token: "reader", Grant: http.Grant{Name: "reader", Operations: []string{http.PermissionRead}, Prefixes: []string{"a"}}},
+		{Token: "writer", Grant: http.Grant{Name: "writer", Operations: []string{http.PermissionRead, http.PermissionWrite}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := http.DefaultConfig()
+	config.AuthConfig = filepath.Join(t.TempDir(), "auth.json")
+	if err := ioutil.WriteFile(config.AuthConfig, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, client := startTestServer(t, config)
+	put := &pb.PutRequest{Key: []byte("a1"), Value: []byte("value")}
+
+	_, err = client.Get(context.Background(), &pb.GetRequest{Key: []byte("a1")})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = client.Get(withToken("unknown"), &pb.GetRequest{Key: []byte("a1")})
+	assertCode(t, err, codes.Unauthenticated)
+	_, err = scanKeys(t, context.Background(), client, &pb.ScanRequest{})
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = client.Put(withToken("writer"), put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Put(withToken("reader"), put)
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = client.Delete(withToken("reader"), &pb.DeleteRequest{Key: []byte("a1")})
+	assertCode(t, err, codes.PermissionDenied)
+	_, err = client.Batch(withToken("reader"), &pb.BatchRequest{Operations: []*pb.Operation{{Op: &pb.Operation_Put{Put: put}}}})
+	assertCode(t, err, codes.PermissionDenied)
+
+	response, err := client.Get(withToken("reader"), &pb.GetRequest{Key: []byte("a1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response.GetValue()) != "value" {
+		t.Fatalf("unexpected value %s", response.GetValue())
+	}
+	_, err = client.Get(withToken("reader"), &pb.GetRequest{Key: []byte("b1")})
+	assertCode(t, err, codes.PermissionDenied)
+	keys, err := scanKeys(t, withToken("reader"), client, &pb.ScanRequest{Start: []byte("a"), End: []byte("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	_, err = scanKeys(t, withToken("reader"), client, &pb.ScanRequest{Start: []byte("a")})
+	assertCode(t, err, codes.PermissionDenied)
+	watch, err := client.Watch(withToken("reader"), &pb.WatchRequest{Prefix: []byte("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = watch.Recv()
+	assertCode(t, err, codes.PermissionDenied)
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := http.DefaultConfig()
+	config.TLSCert = filepath.Join(dir, "cert.pem")
+	config.TLSKey = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(config.TLSCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(config.TLSKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	creds := credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: "localhost"})
+	_, client := startTestServer(t, config, grpc.WithTransportCredentials(creds))
+	_, err = client.Put(context.Background(), &pb.PutRequest{Key: []byte("key"), Value: []byte("value")})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	. "wiskey/pkg"
+)
+
+const (
+	defaultGcEntries = 2 //amount of vlog entries checked by gc if it's not given
+)
+
+//Sstable with its key range
+type SStable struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	FirstKey string `json:"first_key"`
+	LastKey  string `json:"last_key"`
+	Encoding string `json:"encoding,omitempty"` //base64 if keys are base64 encoded
+}
+
+//Routes to maintain the database, column family is chosen with cf query parameter
+func adminRoutes(router gin.IRoutes, db *DB) {
+	family := func(c *gin.Context) (*LsmTree, bool) {
+		name := c.Query("cf")
+		if name == "" {
+			return db.LsmTree, true
+		}
+		lsm, found := db.ColumnFamily(name)
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "column family " + name + " is not opened"})
+		}
+		return lsm, found
+	}
+	//flush memtable to sstable
+	router.POST("/flush", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		adminResult(c, lsm.Flush())
+	})
+	//merge sstables with keys in [start,end), missing start or end means unbounded range
+	router.POST("/compact", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		var start, end []byte
+		if value, present := c.GetQuery("start"); present {
+			start = []byte(value)
+		}
+		if value, present := c.GetQuery("end"); present {
+			end = []byte(value)
+		}
+		adminResult(c, lsm.CompactRange(start, end))
+	})
+	//run vlog gc for the given amount of entries
+	router.POST("/gc", func(c *gin.Context) {
+		entries := defaultGcEntries
+		if value, present := c.GetQuery("entries"); present {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "entries has to be a number"})
+				return
+			}
+			entries = parsed
+		}
+		adminResult(c, db.CompressVlogEntries(entries))
+	})
+	//list sstables from the oldest to the newest
+	router.GET("/sstables", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		sstables, err := lsm.SStables()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response := []SStable{}
+		for _, sstable := range sstables {
+			table := SStable{Path: sstable.Path, Size: sstable.Size}
+			if c.Query("encoding") == base64Encoding || !allValid([][]byte{sstable.FirstKey, sstable.LastKey}) {
+				table.Encoding = base64Encoding
+			}
+			table.FirstKey = encodeValue(sstable.FirstKey, table.Encoding)
+			table.LastKey = encodeValue(sstable.LastKey, table.Encoding)
+			response = append(response, table)
+		}
+		c.JSON(http.StatusOK, response)
+	})
+	//settings the database was opened with
+	router.GET("/config", func(c *gin.Context) {
+		options := db.Options()
+		mergeOperator := ""
+		if options.MergeOperator != nil {
+			mergeOperator = options.MergeOperator.Name()
+		}
+		families := []gin.H{}
+		for _, family := range options.ColumnFamilies {
+			families = append(families, gin.H{"name": family.Name, "memtable_size": family.MemtableSize, "merge_interval": family.MergeInterval})
+		}
+		stall := options.WriteStall
+		c.JSON(http.StatusOK, gin.H{
+			"memtable_size":   options.MemtableSize,
+			"merge_interval":  options.MergeInterval,
+			"comparator":      options.Comparator.Name(),
+			"merge_operator":  mergeOperator,
+			"column_families": families,
+			"write_stall": gin.H{
+				"slowdown_sstables":      stall.SlowdownSStables,
+				"stop_sstables":          stall.StopSStables,
+				"slowdown_pending_bytes": stall.SlowdownPendingBytes,
+				"stop_pending_bytes":     stall.StopPendingBytes,
+				"slowdown_vlog_garbage":  stall.SlowdownVlogGarbage,
+				"stop_vlog_garbage":      stall.StopVlogGarbage,
+				"slowdown_delay":         stall.SlowdownDelay.String(),
+			},
+			"io_rate_limit": options.IORateLimit,
+			"read_only":     options.ReadOnly,
+		})
+	})
+}
+
+//Response of admin operation
+func adminResult(c *gin.Context, err error) {
+	if errors.Is(err, ErrReadOnly) {
+		readOnly(c)
+	} else if errors.Is(err, ErrInvalidOptions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	} else if errors.Is(err, ErrClosed) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	} else {
+		c.Status(http.StatusOK)
+	}
+}
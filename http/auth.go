@@ -0,0 +1,288 @@
+package http
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionAdmin = "admin" //flush, compaction, gc, sstables, config and metrics
+	grantKey        = "grant" //key of the grant in gin context
+)
+
+var (
+	errInvalidToken = errors.New("invalid token")
+)
+
+//Content of auth config file
+//static tokens are compared as is, HMAC tokens are signed by one of the secrets
+//and keep their grant inside, several secrets allow to rotate them
+type AuthConfig struct {
+	Tokens      []StaticToken `json:"tokens"`
+	HMACSecrets []string      `json:"hmac_secrets"`
+}
+
+//Token with its grant
+type StaticToken struct {
+	Token string `json:"token"`
+	Grant
+}
+
+//Operations and key prefixes that token is allowed to use
+//empty prefixes mean that all keys are allowed
+type Grant struct {
+	Name       string   `json:"name"` //name of the client that is written to logs
+	Operations []string `json:"operations"`
+	Prefixes   []string `json:"prefixes"`
+	ExpiresAt  int64    `json:"expires_at,omitempty"` //unix time after which HMAC token is rejected, 0 means never
+}
+
+//Checks tokens of requests, it is shared by all servers of the tree
+type Authenticator struct {
+	tokens  []StaticToken
+	secrets [][]byte
+}
+
+//Load auth config file
+func LoadAuth(path string) (*Authenticator, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config AuthConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("invalid auth config %s: %w", path, err)
+	}
+	auth := &Authenticator{tokens: config.Tokens}
+	for _, token := range config.Tokens {
+		if token.Token == "" {
+			return nil, fmt.Errorf("token of %s is empty in auth config %s", token.Name, path)
+		}
+		if err := token.Grant.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, secret := range config.HMACSecrets {
+		if secret == "" {
+			return nil, fmt.Errorf("empty hmac secret in auth config %s", path)
+		}
+		auth.secrets = append(auth.secrets, []byte(secret))
+	}
+	if len(auth.tokens) == 0 && len(auth.secrets) == 0 {
+		return nil, fmt.Errorf("auth config %s has neither tokens nor hmac secrets", path)
+	}
+	return auth, nil
+}
+
+func (grant *Grant) validate() error {
+	for _, operation := range grant.Operations {
+		if operation != PermissionRead && operation != PermissionWrite && operation != PermissionAdmin {
+			return fmt.Errorf("unknown operation %s of %s", operation, grant.Name)
+		}
+	}
+	return nil
+}
+
+//Find the grant of the token
+func (auth *Authenticator) Authenticate(token string) (*Grant, error) {
+	for i := range auth.tokens {
+		if subtle.ConstantTimeCompare([]byte(auth.tokens[i].Token), []byte(token)) == 1 {
+			return &auth.tokens[i].Grant, nil
+		}
+	}
+	if len(auth.secrets) == 0 {
+		return nil, errInvalidToken
+	}
+	return verifyToken(auth.secrets, token)
+}
+
+//HMAC token is base64 encoded json of the grant and base64 encoded HMAC-SHA256 of it separated by dot
+func SignToken(secret []byte, grant Grant) (string, error) {
+	payload, err := json.Marshal(grant)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func verifyToken(secrets [][]byte, token string) (*Grant, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			continue
+		}
+		var grant Grant
+		if err := json.Unmarshal(payload, &grant); err != nil {
+			return nil, errInvalidToken
+		}
+		if grant.ExpiresAt != 0 && time.Now().Unix() > grant.ExpiresAt {
+			return nil, errors.New("token is expired")
+		}
+		if err := grant.validate(); err != nil {
+			return nil, errInvalidToken
+		}
+		return &grant, nil
+	}
+	return nil, errInvalidToken
+}
+
+//Check if the grant allows the operation
+func (grant *Grant) Allows(operation string) bool {
+	for _, allowed := range grant.Operations {
+		if allowed == operation {
+			return true
+		}
+	}
+	return false
+}
+
+//Check if the key starts with one of the prefixes
+func (grant *Grant) AllowsKey(key []byte) bool {
+	if len(grant.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range grant.Prefixes {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+//Check if all keys in [start,end) start with the same prefix
+func (grant *Grant) AllowsRange(start []byte, end []byte) bool {
+	if len(grant.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range grant.Prefixes {
+		if bytes.HasPrefix(start, []byte(prefix)) && (bytes.HasPrefix(end, []byte(prefix)) || bytes.Equal(end, prefixEnd([]byte(prefix)))) {
+			return true
+		}
+	}
+	return false
+}
+
+//The smallest key that is bigger than all keys with the prefix, nil if there is no such key
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+//Find the grant of bearer token, requests without valid token are rejected with 401
+//nil authenticator means that auth is disabled, public paths don't need a token
+func authenticate(auth *Authenticator, public ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auth == nil {
+			return
+		}
+		for _, path := range public {
+			if c.FullPath() == path {
+				return
+			}
+		}
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			unauthorized(c, errors.New("bearer token is required"))
+			return
+		}
+		grant, err := auth.Authenticate(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			unauthorized(c, err)
+			return
+		}
+		c.Set(grantKey, grant)
+	}
+}
+
+func unauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", `Bearer realm="wiskey"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+}
+
+//Grant of the request, nil if auth is disabled
+func requestGrant(c *gin.Context) *Grant {
+	grant, found := c.Get(grantKey)
+	if !found {
+		return nil
+	}
+	return grant.(*Grant)
+}
+
+//Check that the request is allowed to run the operation with given keys, 403 is written otherwise
+func authorized(c *gin.Context, operation string, keys ...[]byte) bool {
+	grant := requestGrant(c)
+	if grant == nil {
+		return true
+	}
+	if !grant.Allows(operation) {
+		forbidden(c, fmt.Sprintf("%s operation is not allowed", operation))
+		return false
+	}
+	for _, key := range keys {
+		if !grant.AllowsKey(key) {
+			forbidden(c, fmt.Sprintf("key %q is not allowed", key))
+			return false
+		}
+	}
+	return true
+}
+
+//Check that the request is allowed to change all keys in [start,end), 403 is written otherwise
+func authorizedRange(c *gin.Context, operation string, start []byte, end []byte) bool {
+	if !authorized(c, operation) {
+		return false
+	}
+	grant := requestGrant(c)
+	if grant != nil && !grant.AllowsRange(start, end) {
+		forbidden(c, fmt.Sprintf("range [%q,%q) is not allowed", start, end))
+		return false
+	}
+	return true
+}
+
+//Route that requires the operation regardless of keys
+func requirePermission(operation string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorized(c, operation) {
+			c.Abort()
+		}
+	}
+}
+
+func forbidden(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message})
+}
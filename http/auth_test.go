@@ -0,0 +1,139 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret = "secret"
+)
+
+//Write auth config to temporary file and return server config that uses it
+func authConfig(t *testing.T, auth AuthConfig) *Config {
+	content, err := json.Marshal(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.AuthConfig = path
+	return config
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}, "Content-Type": {"application/json"}}
+}
+
+func signToken(t *testing.T, secret string, grant Grant) string {
+	token, err := SignToken([]byte(secret), grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuth_StaticTokens(t *testing.T) {
+	config := authConfig(t, AuthConfig{Tokens: []StaticToken{
+		{Token: "reader", Grant: Grant{Name: "reader", Operations: []string{PermissionRead}, Prefixes: []string{"users/"}}},
+		{Token: "writer", Grant: Grant{Name: "writer", Operations: []string{PermissionWrite}, Prefixes: []string{"users/"}}},
+		{Token: "admin", Grant: Grant{Name: "admin", Operations: []string{PermissionAdmin}}},
+	}})
+	_, router := newTestRouter(t, nil, config)
+	requests := []struct {
+		name, method, path, body string
+		header                   http.Header
+		status                   int
+	}{
+		{"no token", http.MethodGet, "/fetch/users%2Fanita", "", nil, http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/fetch/users%2Fanita", "", bearer("unknown"), http.StatusUnauthorized},
+		{"basic auth", http.MethodGet, "/fetch/users%2Fanita", "", http.Header{"Authorization": {"Basic cmVhZGVy"}}, http.StatusUnauthorized},
+		{"probe without token", http.MethodGet, "/ready", "", nil, http.StatusOK},
+		{"write", http.MethodPost, "/users%2Fanita", `{"value":"Developer"}`, bearer("writer"), http.StatusAccepted},
+		{"read", http.MethodGet, "/fetch/users%2Fanita", "", bearer("reader"), http.StatusOK},
+		{"wrong prefix", http.MethodGet, "/fetch/orders%2F1", "", bearer("reader"), http.StatusForbidden},
+		{"prefix in query key", http.MethodGet, "/fetch?key=orders%2F1", "", bearer("reader"), http.StatusForbidden},
+		{"wrong operation", http.MethodPost, "/users%2Fanita", `{"value":"Manager"}`, bearer("reader"), http.StatusForbidden},
+		{"delete without write", http.MethodDelete, "/users%2Fanita", "", bearer("reader"), http.StatusForbidden},
+		{"merge with wrong prefix", http.MethodPost, "/orders%2F1/merge", `{"value":"1"}`, bearer("writer"), http.StatusForbidden},
+		{"admin without grant", http.MethodGet, "/admin/config", "", bearer("writer"), http.StatusForbidden},
+		{"metrics without grant", http.MethodGet, "/metrics", "", bearer("reader"), http.StatusForbidden},
+		{"admin", http.MethodGet, "/admin/config", "", bearer("admin"), http.StatusOK},
+		{"admin has no key access", http.MethodGet, "/fetch/users%2Fanita", "", bearer("admin"), http.StatusForbidden},
+		{"scan of prefix", http.MethodGet, "/scan?start=users/&end=users0", "", bearer("reader"), http.StatusOK},
+		{"scan outside of prefix", http.MethodGet, "/scan?start=a&end=users0", "", bearer("reader"), http.StatusForbidden},
+		{"unbounded scan", http.MethodGet, "/scan?start=users/", "", bearer("reader"), http.StatusForbidden},
+		{"delete range outside of prefix", http.MethodDelete, "/_batch/range?start=a&end=z", "", bearer("writer"), http.StatusForbidden},
+		{"mget with wrong prefix", http.MethodPost, "/_batch/mget", `{"keys":["users/anita","orders/1"]}`, bearer("reader"), http.StatusForbidden},
+		{"mput with wrong prefix", http.MethodPost, "/_batch/mput", `{"operations":[{"op":"put","key":"orders/1","value":"1"}]}`, bearer("writer"), http.StatusForbidden},
+		{"transaction without read", http.MethodPost, "/_batch/txn", `{"preconditions":[{"key":"users/anita","version":0}],"operations":[{"op":"delete","key":"users/anita"}]}`, bearer("writer"), http.StatusForbidden},
+		{"transaction with wrong prefix", http.MethodPost, "/_batch/txn", `{"operations":[{"op":"delete","key":"orders/1"}]}`, bearer("writer"), http.StatusForbidden},
+	}
+	for _, request := range requests {
+		response := serveRequest(router, request.method, request.path, strings.NewReader(request.body), request.header)
+		if response.Code != request.status {
+			t.Fatalf("%s: expected status %d but was %d %s", request.name, request.status, response.Code, response.Body)
+		}
+	}
+}
+
+func TestAuth_HMACTokens(t *testing.T) {
+	config := authConfig(t, AuthConfig{HMACSecrets: []string{testSecret, "previous"}})
+	_, router := newTestRouter(t, nil, config)
+	grant := Grant{Name: "service", Operations: []string{PermissionRead, PermissionWrite}, Prefixes: []string{"users/"}, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	valid := signToken(t, testSecret, grant)
+	expiredGrant := grant
+	expiredGrant.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	adminGrant := grant
+	adminGrant.Operations = []string{PermissionAdmin}
+	//payload of the valid token is replaced by the payload with admin grant
+	tampered := strings.Split(signToken(t, testSecret, adminGrant), ".")[0] + "." + strings.Split(valid, ".")[1]
+	requests := []struct {
+		name, token string
+		status      int
+	}{
+		{"valid", valid, http.StatusNotFound},
+		{"rotated secret", signToken(t, "previous", grant), http.StatusNotFound},
+		{"unknown secret", signToken(t, "other", grant), http.StatusUnauthorized},
+		{"expired", signToken(t, testSecret, expiredGrant), http.StatusUnauthorized},
+		{"tampered", tampered, http.StatusUnauthorized},
+		{"without signature", strings.Split(valid, ".")[0], http.StatusUnauthorized},
+		{"not base64", "!!!.!!!", http.StatusUnauthorized},
+	}
+	for _, request := range requests {
+		response := serveRequest(router, http.MethodGet, "/fetch/users%2Fanita", nil, bearer(request.token))
+		if response.Code != request.status {
+			t.Fatalf("%s token: expected status %d but was %d %s", request.name, request.status, response.Code, response.Body)
+		}
+	}
+	response := serveRequest(router, http.MethodGet, "/fetch/orders%2F1", nil, bearer(valid))
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("HMAC token with wrong prefix got status %d", response.Code)
+	}
+	response = serveRequest(router, http.MethodPost, "/admin/flush", nil, bearer(valid))
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("HMAC token without admin grant got status %d", response.Code)
+	}
+}
+
+func TestLoadAuth_Invalid(t *testing.T) {
+	invalid := map[string]AuthConfig{
+		"empty":             {},
+		"empty token":       {Tokens: []StaticToken{{Grant: Grant{Name: "reader", Operations: []string{PermissionRead}}}}},
+		"unknown operation": {Tokens: []StaticToken{{Token: "token", Grant: Grant{Name: "reader", Operations: []string{"delete"}}}}},
+		"empty secret":      {HMACSecrets: []string{""}},
+	}
+	for name, auth := range invalid {
+		if _, err := LoadAuth(authConfig(t, auth).AuthConfig); err == nil {
+			t.Fatalf("Auth config with %s was loaded", name)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+)
+
+//Settings of http server
+type Config struct {
+	Addr              string        //tcp address to listen on, it's ignored if Socket is set
+	Socket            string        //path to unix socket to listen on
+	TLSCert           string        //path to certificate, TLS is enabled if it's set together with TLSKey
+	TLSKey            string        //path to private key of the certificate
+	TLSClientCA       string        //path to CA that signs client certificates, clients without certificate are rejected if it's set
+	ReadHeaderTimeout time.Duration //0 means no timeout
+	ReadTimeout       time.Duration //0 means no timeout
+	WriteTimeout      time.Duration //0 means no timeout
+	IdleTimeout       time.Duration //0 means no timeout
+	MaxValueSize      int64         //max size of value in bytes, bigger values are rejected with 413, 0 means unlimited
+	AuthConfig        string        //path to json file with tokens and hmac secrets, auth is disabled if it's empty
+	//other servers of the tree, they are stopped after http server is drained and before the tree is closed
+	OnShutdown []func(ctx context.Context)
+}
+
+//Default settings
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:              ":8080",
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		MaxValueSize:      16 << 20,
+	}
+}
+
+func (config *Config) validate() error {
+	if config.Addr == "" && config.Socket == "" {
+		return errors.New("either address or unix socket is required")
+	}
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return errors.New("TLS certificate and key have to be set together")
+	}
+	if config.TLSClientCA != "" && config.TLSCert == "" {
+		return errors.New("client CA requires TLS certificate and key")
+	}
+	if config.ReadHeaderTimeout < 0 || config.ReadTimeout < 0 || config.WriteTimeout < 0 || config.IdleTimeout < 0 {
+		return errors.New("timeouts can't be negative")
+	}
+	if config.MaxValueSize < 0 {
+		return errors.New("max value size can't be negative")
+	}
+	return nil
+}
+
+//Address the server listens on
+func (config *Config) address() string {
+	if config.Socket != "" {
+		return "unix:" + config.Socket
+	}
+	return config.Addr
+}
+
+//Listen on unix socket or tcp address, socket file left by the previous run is removed
+func (config *Config) listen() (net.Listener, error) {
+	if config.Socket == "" {
+		return net.Listen("tcp", config.Addr)
+	}
+	if info, err := os.Stat(config.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(config.Socket)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", config.Socket)
+}
+
+//TLS settings with the certificate, clients have to present a certificate signed by client CA if it's set,
+//nil if TLS is disabled
+func (config *Config) TLSConfig() (*tls.Config, error) {
+	if config.TLSCert == "" {
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if config.TLSClientCA == "" {
+		return tlsConfig, nil
+	}
+	pem, err := ioutil.ReadFile(config.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in client CA %s", config.TLSClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+//Tokens of auth config, nil if auth is disabled
+func (config *Config) Auth() (*Authenticator, error) {
+	if config.AuthConfig == "" {
+		return nil, nil
+	}
+	return LoadAuth(config.AuthConfig)
+}
@@ -0,0 +1,213 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	. "wiskey/pkg"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	invalid := map[string]func(config *Config){
+		"no address":           func(config *Config) { config.Addr = "" },
+		"certificate only":     func(config *Config) { config.TLSCert = "cert.pem" },
+		"client CA only":       func(config *Config) { config.TLSClientCA = "ca.pem" },
+		"negative timeout":     func(config *Config) { config.ReadTimeout = -time.Second },
+		"negative value limit": func(config *Config) { config.MaxValueSize = -1 },
+	}
+	for name, change := range invalid {
+		config := DefaultConfig()
+		change(config)
+		if config.validate() == nil {
+			t.Fatalf("Config with %s is valid", name)
+		}
+	}
+	if err := DefaultConfig().validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxValueSize = 8
+	_, router := newTestRouter(t, nil, config)
+	requests := []struct {
+		method, path, body string
+		header             http.Header
+	}{
+		{http.MethodPost, "/anita", `{"value":"Developer"}`, jsonHeader},
+		{http.MethodPost, "/anita", "Developer", http.Header{"Content-Type": {mimeOctetStream}}},
+		{http.MethodPost, "/anita", `{"value":"RGV2ZWxvcGVy","encoding":"base64"}`, jsonHeader},
+		{http.MethodPost, "/anita/merge", `{"value":"Developer"}`, jsonHeader},
+		{http.MethodPost, "/_batch/txn", `{"operations":[{"op":"put","key":"anita","value":"Developer"}]}`, jsonHeader},
+		{http.MethodPost, "/_batch/mput", `{"operations":[{"op":"put","key":"anita","value":"Developer"}]}`, jsonHeader},
+	}
+	for _, request := range requests {
+		response := serveRequest(router, request.method, request.path, strings.NewReader(request.body), request.header)
+		if response.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("%s %s with %s returned status %d", request.method, request.path, request.body, response.Code)
+		}
+	}
+	response := serveRequest(router, http.MethodPost, "/anita", strings.NewReader(`{"value":"Manager"}`), jsonHeader)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Value under the limit was rejected with status %d", response.Code)
+	}
+}
+
+//Start the server on unix socket with given config, it's stopped when the test finishes
+func startTestServer(t *testing.T, config *Config) {
+	options := DefaultOptions()
+	options.Logger = NopLogger()
+	db, err := Open(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- StartContext(ctx, db, config)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("unix", config.Socket); err == nil {
+			conn.Close()
+			return
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("server didn't start")
+		}
+	}
+}
+
+//Write PEM encoded certificate and key signed by the parent, nil parent means self signed CA
+func writeCertificate(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	validity := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+	}
+	caTemplate := validity(1, "wiskey ca")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := writeCertificate(t, dir, "ca", caTemplate, nil, nil)
+	serverTemplate := validity(2, "localhost")
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	writeCertificate(t, dir, "server", serverTemplate, ca, caKey)
+	clientTemplate := validity(3, "client")
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	writeCertificate(t, dir, "client", clientTemplate, ca, caKey)
+
+	config := DefaultConfig()
+	config.Socket = filepath.Join(dir, "wiskey.sock")
+	config.TLSCert = filepath.Join(dir, "server.pem")
+	config.TLSKey = filepath.Join(dir, "server-key.pem")
+	config.TLSClientCA = filepath.Join(dir, "ca.pem")
+	startTestServer(t, config)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(certificates []tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("unix", config.Socket)
+			},
+			TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: certificates},
+		}}
+		return client.Get("https://localhost/ready")
+	}
+	//client without certificate is rejected during the handshake
+	if response, err := get(nil); err == nil {
+		response.Body.Close()
+		t.Fatalf("Client without certificate got status %d", response.StatusCode)
+	}
+	certificate, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := get([]tls.Certificate{certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Client with certificate got status %d", response.StatusCode)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.Socket = filepath.Join(t.TempDir(), "wiskey.sock")
+	config.ReadHeaderTimeout = 100 * time.Millisecond
+	startTestServer(t, config)
+	conn, err := net.Dial("unix", config.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//client that never finishes headers is disconnected after the timeout
+	if _, err := conn.Write([]byte("GET /ready HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	_, err = bufio.NewReader(conn).ReadByte()
+	if err == nil || time.Since(start) > 4*time.Second {
+		t.Fatalf("Connection wasn't closed after read header timeout, error %v", err)
+	}
+}
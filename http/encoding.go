@@ -0,0 +1,181 @@
+package http
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	mimeOctetStream = "application/octet-stream"
+	base64Encoding  = "base64"
+	//json with base64 value is bigger than the value, the rest of the body is small
+	maxJsonOverhead = 4096
+)
+
+var (
+	errValueTooLarge = errors.New("value is too large")
+)
+
+//Key from the path or from key query parameter, both have to be percent-encoded
+//if it's not found then 400 is already written
+func requestKey(c *gin.Context) ([]byte, bool) {
+	if key := c.Param("key"); key != "" {
+		return []byte(key), true
+	}
+	if key, present := c.GetQuery("key"); present && key != "" {
+		return []byte(key), true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "key is required in the path or in key query parameter"})
+	return nil, false
+}
+
+//Value and ttl from request body
+//application/octet-stream body is the raw value and ttl is in ttl query parameter,
+//otherwise the body is json with plain or base64 encoded value
+//values bigger than maxValueSize return errValueTooLarge, 0 means unlimited
+func readValue(c *gin.Context, maxValueSize int64) ([]byte, uint, error) {
+	if c.ContentType() == mimeOctetStream {
+		value, err := readBody(c, maxValueSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		var ttl uint64
+		if query, present := c.GetQuery("ttl"); present {
+			ttl, err = strconv.ParseUint(query, 10, 32)
+			if err != nil {
+				return nil, 0, errors.New("ttl has to be a number of seconds")
+			}
+		}
+		return value, uint(ttl), nil
+	}
+	bodyLimit := int64(0)
+	if maxValueSize != 0 {
+		bodyLimit = int64(base64.StdEncoding.EncodedLen(int(maxValueSize))) + maxJsonOverhead
+	}
+	body, err := readBody(c, bodyLimit)
+	if err != nil {
+		return nil, 0, err
+	}
+	var json Value
+	if err := binding.JSON.BindBody(body, &json); err != nil {
+		return nil, 0, err
+	}
+	value, err := decodeValue(json.Value, json.Encoding)
+	if err != nil {
+		return nil, 0, err
+	}
+	if maxValueSize != 0 && int64(len(value)) > maxValueSize {
+		return nil, 0, errValueTooLarge
+	}
+	return value, json.Ttl, nil
+}
+
+//Read request body that isn't bigger than limit, 0 means unlimited
+func readBody(c *gin.Context, limit int64) ([]byte, error) {
+	if limit == 0 {
+		return c.GetRawData()
+	}
+	if c.Request.ContentLength > limit {
+		return nil, errValueTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errValueTooLarge
+	}
+	return body, nil
+}
+
+//Response to invalid value, too large values are rejected with 413
+func badValue(c *gin.Context, err error) {
+	if errors.Is(err, errValueTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+func decodeValue(value string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(value), nil
+	case base64Encoding:
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("value is not valid base64: %w", err)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", encoding)
+	}
+}
+
+func encodeValue(value []byte, encoding string) string {
+	if encoding == base64Encoding {
+		return base64.StdEncoding.EncodeToString(value)
+	}
+	return string(value)
+}
+
+//All values are valid utf-8 so they can be sent in json as they are
+func allValid(values [][]byte) bool {
+	for _, value := range values {
+		if !utf8.Valid(value) {
+			return false
+		}
+	}
+	return true
+}
+
+//Decode base64 keys and values of the transaction in place
+func decodeTransaction(txn *TransactionRequest) error {
+	if txn.Encoding == "" {
+		return nil
+	}
+	decode := func(value *string) error {
+		decoded, err := decodeValue(*value, txn.Encoding)
+		*value = string(decoded)
+		return err
+	}
+	for i := range txn.Preconditions {
+		if err := decode(&txn.Preconditions[i].Key); err != nil {
+			return err
+		}
+	}
+	for i := range txn.Operations {
+		if err := decode(&txn.Operations[i].Key); err != nil {
+			return err
+		}
+		if err := decode(&txn.Operations[i].Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Write value in the format from Accept header, json is used by default
+//json value is base64 encoded if it's not valid utf-8 or if encoding=base64 query parameter is given
+func writeValue(c *gin.Context, value []byte) {
+	switch c.NegotiateFormat(gin.MIMEJSON, mimeOctetStream) {
+	case gin.MIMEJSON:
+		if c.Query("encoding") == base64Encoding || !utf8.Valid(value) {
+			c.JSON(http.StatusOK, gin.H{"value": base64.StdEncoding.EncodeToString(value), "encoding": base64Encoding})
+		} else {
+			c.JSON(http.StatusOK, gin.H{"value": string(value)})
+		}
+	case mimeOctetStream:
+		c.Data(http.StatusOK, mimeOctetStream, value)
+	default:
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": "only application/json and application/octet-stream are supported"})
+	}
+}
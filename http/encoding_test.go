@@ -0,0 +1,92 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	. "wiskey/pkg"
+)
+
+var (
+	jsonHeader        = http.Header{"Content-Type": {"application/json"}}
+	octetStreamHeader = http.Header{"Content-Type": {mimeOctetStream}, "Accept": {mimeOctetStream}}
+)
+
+//Value from json response of GET
+func jsonValue(t *testing.T, body *bytes.Buffer) (string, string) {
+	t.Helper()
+	var response struct {
+		Value    string `json:"value"`
+		Encoding string `json:"encoding"`
+	}
+	if err := json.Unmarshal(body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Value, response.Encoding
+}
+
+func TestRequestKey(t *testing.T) {
+	options := DefaultOptions()
+	options.MergeOperator = Int64AddOperator{}
+	db, router := newTestRouter(t, options, nil)
+	//percent-encoded slash and space are a part of the key
+	response := serveRequest(router, http.MethodPost, "/a%2Fb%20c", strings.NewReader(`{"value":"Developer"}`), jsonHeader)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Put failed with status %d %s", response.Code, response.Body)
+	}
+	if value, _, _ := db.Get([]byte("a/b c")); string(value) != "Developer" {
+		t.Fatalf("Key wasn't decoded, value %s", value)
+	}
+	for _, path := range []string{"/fetch/a%2Fb%20c", "/fetch?key=a%2Fb%20c"} {
+		response = serveRequest(router, http.MethodGet, path, nil, nil)
+		if value, _ := jsonValue(t, response.Body); response.Code != http.StatusOK || value != "Developer" {
+			t.Fatalf("GET %s returned status %d and value %s", path, response.Code, value)
+		}
+	}
+	//merge route decodes the key the same way
+	response = serveRequest(router, http.MethodPost, "/a%2Fb/merge", strings.NewReader(`{"value":"5"}`), jsonHeader)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Merge failed with status %d %s", response.Code, response.Body)
+	}
+	if value, _, _ := db.Get([]byte("a/b")); string(value) != "5" {
+		t.Fatalf("Merge operand was saved with wrong key, value %s", value)
+	}
+	response = serveRequest(router, http.MethodGet, "/fetch", nil, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("Missing key returned status %d", response.Code)
+	}
+}
+
+func TestBinaryValue(t *testing.T) {
+	db, router := newTestRouter(t, nil, nil)
+	binary := []byte{0xff, 0x00, 'a'}
+	response := serveRequest(router, http.MethodPost, "/%FF%00", bytes.NewReader(binary), octetStreamHeader)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Put failed with status %d %s", response.Code, response.Body)
+	}
+	if value, _, _ := db.Get([]byte{0xff, 0x00}); !bytes.Equal(value, binary) {
+		t.Fatalf("Raw value wasn't saved as it is %v", value)
+	}
+	response = serveRequest(router, http.MethodGet, "/fetch/%FF%00", nil, http.Header{"Accept": {mimeOctetStream}})
+	if !bytes.Equal(response.Body.Bytes(), binary) {
+		t.Fatalf("Raw value wasn't returned as it is %v", response.Body.Bytes())
+	}
+	//json can't contain invalid utf-8 so the value is base64 encoded
+	response = serveRequest(router, http.MethodGet, "/fetch/%FF%00", nil, nil)
+	if value, encoding := jsonValue(t, response.Body); value != "/wBh" || encoding != base64Encoding {
+		t.Fatalf("Expected base64 value but was %s with encoding %s", value, encoding)
+	}
+	response = serveRequest(router, http.MethodPost, "/base64", strings.NewReader(`{"value":"/wBh","encoding":"base64"}`), jsonHeader)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Put failed with status %d %s", response.Code, response.Body)
+	}
+	if value, _, _ := db.Get([]byte("base64")); !bytes.Equal(value, binary) {
+		t.Fatalf("Base64 value wasn't decoded %v", value)
+	}
+	response = serveRequest(router, http.MethodPost, "/base64", strings.NewReader(`{"value":"not base64!","encoding":"base64"}`), jsonHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("Invalid base64 returned status %d", response.Code)
+	}
+}
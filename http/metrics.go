@@ -0,0 +1,85 @@
+package http
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	. "wiskey/pkg"
+)
+
+//Statistics of all opened column families in prometheus text format
+func metrics(lsm *LsmTree) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		names, err := lsm.ColumnFamilies()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		families := make(map[string]Stats)
+		order := []string{""}
+		families[""] = lsm.Stats()
+		for _, name := range names {
+			if family, opened := lsm.ColumnFamily(name); opened {
+				families[name] = family.Stats()
+				order = append(order, name)
+			}
+		}
+		c.Header("Content-Type", "text/plain; version=0.0.4")
+		c.Status(http.StatusOK)
+		writeMetrics(c.Writer, order, families)
+	}
+}
+
+func writeMetrics(w io.Writer, order []string, families map[string]Stats) {
+	histograms := []struct {
+		name, help string
+		value      func(stats Stats) Histogram
+	}{
+		{"wiskey_put_duration_seconds", "Latency of put requests", func(stats Stats) Histogram { return stats.PutLatency }},
+		{"wiskey_get_duration_seconds", "Latency of get requests", func(stats Stats) Histogram { return stats.GetLatency }},
+		{"wiskey_delete_duration_seconds", "Latency of delete requests", func(stats Stats) Histogram { return stats.DeleteLatency }},
+		{"wiskey_flush_duration_seconds", "Duration of memtable flushes", func(stats Stats) Histogram { return stats.FlushDuration }},
+		{"wiskey_merge_duration_seconds", "Duration of sstable merges", func(stats Stats) Histogram { return stats.MergeDuration }},
+	}
+	for _, histogram := range histograms {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", histogram.name, histogram.help, histogram.name)
+		for _, family := range order {
+			value := histogram.value(families[family])
+			for _, bucket := range value.Buckets {
+				fmt.Fprintf(w, "%s_bucket{family=%q,le=%q} %d\n", histogram.name, family, formatFloat(bucket.UpperBound), bucket.Count)
+			}
+			fmt.Fprintf(w, "%s_bucket{family=%q,le=\"+Inf\"} %d\n", histogram.name, family, value.Count)
+			fmt.Fprintf(w, "%s_sum{family=%q} %s\n", histogram.name, family, formatFloat(value.Sum))
+			fmt.Fprintf(w, "%s_count{family=%q} %d\n", histogram.name, family, value.Count)
+		}
+	}
+	gauges := []struct {
+		name, kind, help string
+		value            func(stats Stats) float64
+	}{
+		{"wiskey_flushes_total", "counter", "Number of memtable flushes", func(stats Stats) float64 { return float64(stats.Flushes) }},
+		{"wiskey_merges_total", "counter", "Number of sstable merges", func(stats Stats) float64 { return float64(stats.Merges) }},
+		{"wiskey_memtable_size_bytes", "gauge", "Size of memtable", func(stats Stats) float64 { return float64(stats.MemtableSize) }},
+		{"wiskey_sstables", "gauge", "Number of sstables", func(stats Stats) float64 { return float64(stats.SStables) }},
+		{"wiskey_sstables_size_bytes", "gauge", "Size of all sstables", func(stats Stats) float64 { return float64(stats.SStablesSize) }},
+		{"wiskey_pending_compaction_bytes", "gauge", "Size of sstables that wait for the merge", func(stats Stats) float64 { return float64(stats.WriteStall.PendingCompactionBytes) }},
+		{"wiskey_write_stall", "gauge", "Write stall, 0 - none, 1 - slowdown, 2 - stop", func(stats Stats) float64 { return float64(stats.WriteStall.Condition) }},
+	}
+	for _, gauge := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", gauge.name, gauge.help, gauge.name, gauge.kind)
+		for _, family := range order {
+			fmt.Fprintf(w, "%s{family=%q} %s\n", gauge.name, family, formatFloat(gauge.value(families[family])))
+		}
+	}
+	//vlog is shared by all column families
+	root := families[""]
+	fmt.Fprintf(w, "# HELP wiskey_vlog_size_bytes Size of vlog\n# TYPE wiskey_vlog_size_bytes gauge\nwiskey_vlog_size_bytes %d\n", root.VlogSize)
+	fmt.Fprintf(w, "# HELP wiskey_vlog_garbage_bytes Size of overwritten, deleted and expired values in vlog\n# TYPE wiskey_vlog_garbage_bytes gauge\nwiskey_vlog_garbage_bytes %d\n", root.VlogGarbage)
+	fmt.Fprintf(w, "# HELP wiskey_background_state State of background jobs, 0 - ok, 1 - retrying, 2 - failed\n# TYPE wiskey_background_state gauge\nwiskey_background_state %d\n", root.Background.State)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
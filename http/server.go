@@ -1,65 +1,757 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"math"
 	"net/http"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 	. "wiskey/pkg"
 )
 
+const (
+	shutdownTimeout  = 30 * time.Second //max time to wait for in-flight requests on shutdown
+	defaultScanLimit = 100              //keys in the page of scan without limit
+	maxScanLimit     = 1000             //max keys in the page of scan
+	maxMultiKeys     = 1000             //max keys of mget and mput
+	//routes that work with many keys are under the prefix that can't be a key,
+	//static routes next to /:key would hide the keys that start with them
+	batchPrefix = "/_batch"
+)
+
 type Value struct {
-	Value string `json:"value" binding:"required"`
+	Value    string `json:"value" binding:"required"`
+	Ttl      uint   `json:"ttl"`      //time to live in seconds, 0 means that value never expires
+	Encoding string `json:"encoding"` //base64 for binary values, empty means that value is a plain string
+}
+
+//Transaction request, all preconditions are checked
+//and then operations are applied atomically
+type TransactionRequest struct {
+	Preconditions []Precondition `json:"preconditions"`
+	Operations    []Operation    `json:"operations" binding:"required"`
+	Encoding      string         `json:"encoding"` //base64 if all keys and values are base64 encoded
+}
+
+//Key has to have given version, version 0 means that the key must not exist
+type Precondition struct {
+	Key     string `json:"key" binding:"required"`
+	Version uint64 `json:"version"`
+}
+
+//put or delete operation
+type Operation struct {
+	Op    string `json:"op" binding:"required"`
+	Key   string `json:"key" binding:"required"`
+	Value string `json:"value"`
+	Ttl   uint   `json:"ttl"`
+}
+
+//Page of keys in [start,end)
+//keys and values are base64 encoded if encoding is base64
+type ScanResponse struct {
+	Items    []KeyValue `json:"items"`
+	More     bool       `json:"more"` //there are more keys, the next page is requested with after=<last key>
+	Encoding string     `json:"encoding,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+//Keys that are read with a single request
+type MultiGetRequest struct {
+	Keys     []string `json:"keys" binding:"required"`
+	Encoding string   `json:"encoding"` //base64 if keys are base64 encoded
+}
+
+//Values in the order of requested keys
+//keys and values are base64 encoded if encoding is base64
+type MultiGetResponse struct {
+	Items    []MultiGetItem `json:"items"`
+	Encoding string         `json:"encoding,omitempty"`
+}
+
+type MultiGetItem struct {
+	Key     string `json:"key"`
+	Found   bool   `json:"found"`
+	Value   string `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+//Puts and deletes that are saved with a single request
+//unlike transaction they don't have preconditions and the ones before a failed operation stay saved
+type MultiPutRequest struct {
+	Operations []Operation `json:"operations" binding:"required"`
+	Encoding   string      `json:"encoding"` //base64 if all keys and values are base64 encoded
+}
+
+//Start http server, on SIGINT or SIGTERM it stops accepting connections,
+//drains in-flight requests and closes the tree
+//nil config means default config
+func Start(db *DB, config *Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return StartContext(ctx, db, config)
+}
+
+//Start http server that is stopped when the context is done
+func StartContext(ctx context.Context, db *DB, config *Config) error {
+	lsm := db.LsmTree
+	if config == nil {
+		config = DefaultConfig()
+	}
+	err := config.validate()
+	if err != nil {
+		return closeOnError(lsm, err)
+	}
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return closeOnError(lsm, err)
+	}
+	auth, err := config.Auth()
+	if err != nil {
+		return closeOnError(lsm, err)
+	}
+	router := newRouter(db, config, auth)
+
+	listener, err := config.listen()
+	if err != nil {
+		return closeOnError(lsm, err)
+	}
+	server := &http.Server{
+		Handler:           router,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+	lsm.Logger().Info("http server started", "addr", config.address(), "tls", config.TLSCert != "", "client_certificates", config.TLSClientCA != "")
+	return serve(ctx, server, lsm, config.OnShutdown, func() error {
+		if tlsConfig != nil {
+			//certificate is already loaded to TLSConfig
+			return server.ServeTLS(listener, "", "")
+		}
+		return server.Serve(listener)
+	})
 }
 
-func Start(lsm *LsmTree) {
+//Routes of all endpoints with auth, read only mode and column families
+func newRouter(db *DB, config *Config, auth *Authenticator) *gin.Engine {
+	lsm := db.LsmTree
+	//gin writes debug messages to stdout, requests are logged by the logger of the tree
+	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.GET("/gc", func(c *gin.Context) {
-		err := lsm.CompressVlog()
-		if err != nil{
-			c.JSON(http.StatusInternalServerError,gin.H{"value":"Something went wrong during Gc"})
-		} else{
-			c.Status(http.StatusOK)
+	//keys can contain any bytes if they are percent-encoded, including slashes
+	router.UseRawPath = true
+	router.UnescapePathValues = true
+	router.Use(logRequests(lsm.Logger()), gin.Recovery())
+	//probes of load balancers and orchestrators don't have tokens
+	router.Use(authenticate(auth, "/health", "/ready"))
+	//mget is a read with the keys in the body
+	router.Use(rejectWritesIfReadOnly(lsm, batchPrefix+"/mget", "/ns/:cf"+batchPrefix+"/mget"))
+	router.GET("/metrics", requirePermission(PermissionAdmin), metrics(lsm))
+	router.GET("/health", health(lsm))
+	router.GET("/ready", ready(lsm))
+	adminRoutes(router.Group("/admin", requirePermission(PermissionAdmin)), db)
+	//default column family
+	keyRoutes(router, func(c *gin.Context) (*LsmTree, bool) {
+		return lsm, true
+	}, config.MaxValueSize)
+	//column family from the path
+	keyRoutes(router.Group("/ns/:cf"), func(c *gin.Context) (*LsmTree, bool) {
+		family, found := lsm.ColumnFamily(c.Param("cf"))
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "column family " + c.Param("cf") + " is not opened"})
+		}
+		return family, found
+	}, config.MaxValueSize)
+	return router
+}
+
+//Server couldn't start, the tree still has to be flushed
+func closeOnError(lsm *LsmTree, err error) error {
+	closeErr := lsm.Close()
+	if closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+//Serve requests until the context is done, then wait for in-flight requests, stop other servers and close the tree
+func serve(ctx context.Context, server *http.Server, lsm *LsmTree, onShutdown []func(ctx context.Context), listen func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- listen()
+	}()
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	select {
+	case err := <-errs:
+		for _, shutdown := range onShutdown {
+			shutdown(drainCtx)
+		}
+		return closeOnError(lsm, err)
+	case <-ctx.Done():
+	}
+	lsm.Logger().Info("http server is shutting down, waiting for in-flight requests")
+	err := server.Shutdown(drainCtx)
+	for _, shutdown := range onShutdown {
+		shutdown(drainCtx)
+	}
+	closeErr := lsm.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+//Log every request with its status and duration
+func logRequests(logger Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		fields := []interface{}{"method", c.Request.Method, "path", c.Request.URL.Path, "status", c.Writer.Status(), "duration", time.Since(start), "client", c.ClientIP()}
+		if grant := requestGrant(c); grant != nil {
+			fields = append(fields, "token", grant.Name)
+		}
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			logger.Warn("request failed", fields...)
+		} else {
+			logger.Debug("request", fields...)
+		}
+	}
+}
+
+//Read only tree serves only GET requests and the given read routes
+func rejectWritesIfReadOnly(lsm *LsmTree, reads ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !lsm.ReadOnly() || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			return
+		}
+		for _, path := range reads {
+			if c.FullPath() == path {
+				return
+			}
+		}
+		readOnly(c)
+		c.Abort()
+	}
+}
+
+//State of background jobs, 503 after fatal background error
+func health(lsm *LsmTree) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		background := lsm.Stats().Background
+		status := http.StatusOK
+		if background.State == BackgroundFailed {
+			status = http.StatusServiceUnavailable
+		}
+		response := gin.H{"status": background.State.String(), "since": background.Since}
+		if background.State != BackgroundOk {
+			response["operation"] = background.Operation
+			response["error"] = background.Error
+			response["retries"] = background.Retries
+		}
+		c.JSON(status, response)
+	}
+}
+
+//Store is ready to take traffic until it's closed, a background job fails
+//or writes are stopped by the write stall
+func ready(lsm *LsmTree) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !lsm.Ready() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "reason": "closed"})
+			return
+		}
+		stats := lsm.Stats()
+		if stats.Background.State == BackgroundFailed {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "reason": "background " + stats.Background.Operation + " failed", "error": stats.Background.Error})
+			return
+		}
+		if stats.WriteStall.Condition == StallStop {
+			reason := "write stall: " + stats.WriteStall.Reason
+			if stats.WriteStall.Reason == StallVlogGarbage {
+				reason += ", vlog gc has to be run"
+			}
+			setRetryAfter(c, stats.WriteStall)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "reason": reason})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
+}
+
+func readOnly(c *gin.Context) {
+	c.Header("Allow", "GET, HEAD")
+	c.JSON(http.StatusMethodNotAllowed, gin.H{"error": ErrReadOnly.Error()})
+}
+
+//Finds column family that request works with
+//if it's not found then the response has to be already written
+type familyResolver func(c *gin.Context) (*LsmTree, bool)
+
+//Routes to work with keys of column family
+//values bigger than maxValueSize are rejected with 413, 0 means unlimited
+func keyRoutes(router gin.IRoutes, family familyResolver, maxValueSize int64) {
+	//delete range of keys
+	router.DELETE(batchPrefix+"/range", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		start := c.Query("start")
+		end := c.Query("end")
+		if start == "" || end == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start and end query parameters are required"})
+			return
+		}
+		if !authorizedRange(c, PermissionWrite, []byte(start), []byte(end)) {
+			return
+		}
+		err := lsm.DeleteRange([]byte(start), []byte(end))
+		if stalled(c, lsm, err) {
+			return
+		}
+		if errors.Is(err, ErrInvalidRange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			c.Status(http.StatusAccepted)
 		}
 	})
-	//delete key
-	router.DELETE("/:key", func(c *gin.Context) {
-		key := c.Param("key")
-		err := lsm.Delete([]byte(key))
+	//page of keys in [start,end), empty end means there is no upper bound
+	router.GET("/scan", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		start := []byte(c.Query("start"))
+		var end []byte
+		if query := c.Query("end"); query != "" {
+			end = []byte(query)
+		}
+		after, continued := c.GetQuery("after")
+		if continued {
+			start = []byte(after)
+		}
+		limit := defaultScanLimit
+		if query, present := c.GetQuery("limit"); present {
+			var err error
+			limit, err = strconv.Atoi(query)
+			if err != nil || limit < 1 || limit > maxScanLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit has to be between 1 and %d", maxScanLimit)})
+				return
+			}
+		}
+		if !authorizedRange(c, PermissionRead, start, end) {
+			return
+		}
+		response := ScanResponse{Items: []KeyValue{}}
+		var keys, values [][]byte
+		iterator := lsm.NewIterator(start, end)
+		for iterator.Next() {
+			if continued && string(iterator.Key()) == after {
+				continue
+			}
+			if len(keys) == limit {
+				response.More = true
+				break
+			}
+			keys = append(keys, iterator.Key())
+			values = append(values, iterator.Value())
+		}
+		if err := iterator.Err(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if c.Query("encoding") == base64Encoding || !allValid(keys) || !allValid(values) {
+			response.Encoding = base64Encoding
+		}
+		for i := range keys {
+			response.Items = append(response.Items, KeyValue{Key: encodeValue(keys[i], response.Encoding), Value: encodeValue(values[i], response.Encoding)})
+		}
+		c.JSON(http.StatusOK, response)
+	})
+	//values of many keys
+	router.POST(batchPrefix+"/mget", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		var json MultiGetRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(json.Keys) > maxMultiKeys {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d keys can be read at once", maxMultiKeys)})
+			return
+		}
+		keys := make([][]byte, len(json.Keys))
+		for i, key := range json.Keys {
+			decoded, err := decodeValue(key, json.Encoding)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "key": key})
+				return
+			}
+			keys[i] = decoded
+		}
+		if !authorized(c, PermissionRead, keys...) {
+			return
+		}
+		results, err := lsm.MultiGet(keys)
 		if err != nil {
-			c.JSON(http.StatusOK, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response := MultiGetResponse{Items: make([]MultiGetItem, len(keys))}
+		values := make([][]byte, len(results))
+		for i, result := range results {
+			values[i] = result.Value
+		}
+		if json.Encoding == base64Encoding || c.Query("encoding") == base64Encoding || !allValid(keys) || !allValid(values) {
+			response.Encoding = base64Encoding
+		}
+		for i, result := range results {
+			response.Items[i] = MultiGetItem{Key: encodeValue(keys[i], response.Encoding), Found: result.Found}
+			if result.Found {
+				response.Items[i].Value = encodeValue(result.Value, response.Encoding)
+				response.Items[i].Version = result.Version
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	})
+	//save many keys with a single lock of the tree
+	router.POST(batchPrefix+"/mput", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		var json MultiPutRequest
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(json.Operations) > maxMultiKeys {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d keys can be written at once", maxMultiKeys)})
+			return
+		}
+		txn := TransactionRequest{Operations: json.Operations, Encoding: json.Encoding}
+		if err := decodeTransaction(&txn); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		keys := make([][]byte, len(json.Operations))
+		for i, operation := range json.Operations {
+			keys[i] = []byte(operation.Key)
+		}
+		if !authorized(c, PermissionWrite, keys...) {
+			return
+		}
+		entries := make([]*TableEntry, len(json.Operations))
+		for i, operation := range json.Operations {
+			switch operation.Op {
+			case "put":
+				if maxValueSize != 0 && int64(len(operation.Value)) > maxValueSize {
+					c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": errValueTooLarge.Error(), "key": operation.Key})
+					return
+				}
+				entry := NewEntry(keys[i], []byte(operation.Value))
+				if operation.Ttl != 0 {
+					entry = NewEntryWithTTL(keys[i], []byte(operation.Value), time.Duration(operation.Ttl)*time.Second)
+				}
+				entries[i] = &entry
+			case "delete":
+				entries[i] = DeletedEntry(keys[i])
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown operation %s", operation.Op), "key": operation.Key})
+				return
+			}
+		}
+		err := lsm.MultiPut(entries)
+		if stalled(c, lsm, err) {
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		} else {
 			c.Status(http.StatusAccepted)
 		}
 	})
+	//delete key
+	deleteKey := func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		key, found := requestKey(c)
+		if !found {
+			return
+		}
+		if !authorized(c, PermissionWrite, key) {
+			return
+		}
+		var err error
+		if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+			version, parseErr := parseETag(ifMatch)
+			if parseErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": parseErr.Error()})
+				return
+			}
+			err = lsm.DeleteIfVersion(key, version)
+		} else {
+			err = lsm.Delete(key)
+		}
+		if stalled(c, lsm, err) {
+			return
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			c.Status(http.StatusAccepted)
+		}
+	}
+	router.DELETE("/:key", deleteKey)
+	router.DELETE("/", deleteKey)
 	//get key
-	router.GET("/fetch/:key", func(c *gin.Context) {
-		key := c.Param("key")
-		value, found := lsm.Get([]byte(key))
-		if found {
-			c.JSON(http.StatusOK, gin.H{"value": string(value)})
+	getKey := func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		key, found := requestKey(c)
+		if !found {
+			return
+		}
+		if !authorized(c, PermissionRead, key) {
+			return
+		}
+		value, version, found, err := lsm.GetWithVersion(key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else if found {
+			c.Header("ETag", formatETag(version))
+			writeValue(c, value)
 		} else {
 			c.Status(http.StatusNotFound)
 		}
-	})
-	//post key
-	router.POST("/:key", func(c *gin.Context) {
-		var json Value
-		key := c.Param("key")
+	}
+	router.GET("/fetch/:key", getKey)
+	router.GET("/fetch", getKey)
+	//run transaction
+	router.POST(batchPrefix+"/txn", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		var json TransactionRequest
 		if err := c.ShouldBindJSON(&json); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		entry := NewEntry([]byte(key), []byte(json.Value))
-		err := lsm.Put(&entry)
-		if err != nil {
+		if err := decodeTransaction(&json); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var readKeys, writtenKeys [][]byte
+		for _, precondition := range json.Preconditions {
+			readKeys = append(readKeys, []byte(precondition.Key))
+		}
+		for _, operation := range json.Operations {
+			writtenKeys = append(writtenKeys, []byte(operation.Key))
+		}
+		if (len(readKeys) != 0 && !authorized(c, PermissionRead, readKeys...)) || !authorized(c, PermissionWrite, writtenKeys...) {
+			return
+		}
+		tx := lsm.Begin()
+		for _, precondition := range json.Preconditions {
+			_, version, _, err := tx.GetWithVersion([]byte(precondition.Key))
+			if err == nil && version != precondition.Version {
+				err = ErrVersionMismatch
+			}
+			if err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error(), "key": precondition.Key})
+				return
+			}
+		}
+		for _, operation := range json.Operations {
+			var err error
+			switch operation.Op {
+			case "put":
+				if maxValueSize != 0 && int64(len(operation.Value)) > maxValueSize {
+					tx.Rollback()
+					c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": errValueTooLarge.Error(), "key": operation.Key})
+					return
+				}
+				entry := NewEntry([]byte(operation.Key), []byte(operation.Value))
+				if operation.Ttl != 0 {
+					entry = NewEntryWithTTL([]byte(operation.Key), []byte(operation.Value), time.Duration(operation.Ttl)*time.Second)
+				}
+				err = tx.PutEntry(&entry)
+			case "delete":
+				err = tx.Delete([]byte(operation.Key))
+			default:
+				err = fmt.Errorf("unknown operation %s", operation.Op)
+			}
+			if err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "key": operation.Key})
+				return
+			}
+		}
+		err := tx.Commit()
+		if stalled(c, lsm, err) {
+			return
+		}
+		if errors.Is(err, ErrConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			c.Status(http.StatusAccepted)
+		}
+	})
+	//merge operand with the value of the key
+	router.POST("/:key/merge", func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
 			return
+		}
+		key, found := requestKey(c)
+		if !found {
+			return
+		}
+		if !authorized(c, PermissionWrite, key) {
+			return
+		}
+		value, _, err := readValue(c, maxValueSize)
+		if err != nil {
+			badValue(c, err)
+			return
+		}
+		err = lsm.MergeValue(key, value)
+		if stalled(c, lsm, err) {
+			return
+		}
+		if errors.Is(err, ErrNoMergeOperator) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		} else {
 			c.Status(http.StatusAccepted)
 		}
 	})
+	//post key
+	putKey := func(c *gin.Context) {
+		lsm, found := family(c)
+		if !found {
+			return
+		}
+		key, found := requestKey(c)
+		if !found {
+			return
+		}
+		if !authorized(c, PermissionWrite, key) {
+			return
+		}
+		value, ttl, err := readValue(c, maxValueSize)
+		if err != nil {
+			badValue(c, err)
+			return
+		}
+		entry := NewEntry(key, value)
+		if ttl != 0 {
+			entry = NewEntryWithTTL(key, value, time.Duration(ttl)*time.Second)
+		}
+		//conditional writes, If-Match has to contain the ETag from GET
+		//and If-None-Match: * means that the key must not exist
+		ifMatch := c.GetHeader("If-Match")
+		ifNoneMatch := c.GetHeader("If-None-Match")
+		if ifMatch == "" && ifNoneMatch == "" {
+			err := lsm.Put(&entry)
+			if stalled(c, lsm, err) {
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			} else {
+				c.Status(http.StatusAccepted)
+			}
+			return
+		}
+		expectedVersion := NoVersion
+		if ifMatch != "" {
+			version, err := parseETag(ifMatch)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			expectedVersion = version
+		} else if ifNoneMatch != "*" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only If-None-Match: * is supported"})
+			return
+		}
+		version, err := lsm.CompareAndPut(&entry, expectedVersion)
+		if stalled(c, lsm, err) {
+			return
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		} else {
+			c.Header("ETag", formatETag(version))
+			c.Status(http.StatusAccepted)
+		}
+	}
+	router.POST("/:key", putKey)
+	router.POST("/", putKey)
+}
+
+//Writes are stopped until compaction catches up so client has to retry after the next merge
+//merge doesn't reclaim vlog garbage so there is nothing to wait for until vlog gc is run
+func stalled(c *gin.Context, lsm *LsmTree, err error) bool {
+	if !errors.Is(err, ErrWriteStall) {
+		return false
+	}
+	setRetryAfter(c, lsm.Stats().WriteStall)
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	return true
+}
 
-	err := router.Run(":8080")
+//Retry-After is set only if the next merge can remove the stall
+func setRetryAfter(c *gin.Context, stall StallStats) {
+	if stall.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(stall.RetryAfter.Seconds()))))
+	}
+}
+
+//ETag is a quoted version of the key
+func formatETag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+func parseETag(etag string) (uint64, error) {
+	unquoted, err := strconv.Unquote(etag)
+	if err != nil {
+		unquoted = etag
+	}
+	version, err := strconv.ParseUint(unquoted, 10, 64)
 	if err != nil {
-		panic(err)
+		return NoVersion, fmt.Errorf("invalid ETag %s", etag)
 	}
+	return version, nil
 }
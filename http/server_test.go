@@ -0,0 +1,337 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	. "wiskey/pkg"
+)
+
+//Open the store in temporary directory and build routes of the server without listening
+func newTestRouter(t *testing.T, options *Options, config *Config) (*DB, *gin.Engine) {
+	if options == nil {
+		options = DefaultOptions()
+	}
+	options.Logger = NopLogger()
+	db, err := Open(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := config.Auth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, newRouter(db, config, auth)
+}
+
+//Send the request to the router, body can be nil
+func serveRequest(router *gin.Engine, method string, path string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, body)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestReady(t *testing.T) {
+	options := DefaultOptions()
+	options.WriteStall.StopSStables = 2
+	db, router := newTestRouter(t, options, nil)
+	if response := serveRequest(router, http.MethodGet, "/ready", nil, nil); response.Code != http.StatusOK {
+		t.Fatalf("Opened store is not ready, status %d", response.Code)
+	}
+	for _, key := range []string{"anita", "bob"} {
+		entry := NewEntry([]byte(key), []byte("Developer"))
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response := serveRequest(router, http.MethodGet, "/ready", nil, nil)
+	if response.Code != http.StatusServiceUnavailable || !strings.Contains(response.Body.String(), "write stall") {
+		t.Fatalf("Store with stopped writes is ready, status %d %s", response.Code, response.Body)
+	}
+	if response.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After is missing")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	response = serveRequest(router, http.MethodGet, "/ready", nil, nil)
+	if response.Code != http.StatusServiceUnavailable || !strings.Contains(response.Body.String(), "closed") {
+		t.Fatalf("Closed store is ready, status %d %s", response.Code, response.Body)
+	}
+}
+
+func TestPut_VlogGarbageStall(t *testing.T) {
+	options := DefaultOptions()
+	options.WriteStall.StopVlogGarbage = 1
+	db, router := newTestRouter(t, options, nil)
+	entry := NewEntry([]byte("anita"), []byte("Developer"))
+	//overwritten value is garbage that only vlog gc reclaims
+	for i := 0; i < 2; i++ {
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response := serveRequest(router, http.MethodPost, "/anita", strings.NewReader(`{"value":"Developer"}`), jsonHeader)
+	if response.Code != http.StatusServiceUnavailable || !strings.Contains(response.Body.String(), "vlog gc") {
+		t.Fatalf("Write wasn't stopped until vlog gc, status %d %s", response.Code, response.Body)
+	}
+	if retryAfter := response.Header().Get("Retry-After"); retryAfter != "" {
+		t.Fatalf("Merge can't remove the stall but Retry-After is %s", retryAfter)
+	}
+	response = serveRequest(router, http.MethodGet, "/ready", nil, nil)
+	if response.Code != http.StatusServiceUnavailable || response.Header().Get("Retry-After") != "" {
+		t.Fatalf("Ready has to report the stall without Retry-After, status %d %s", response.Code, response.Body)
+	}
+}
+
+func TestScan_Pages(t *testing.T) {
+	db, router := newTestRouter(t, nil, nil)
+	for i := 0; i < 25; i++ {
+		entry := NewEntry([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%02d", i)))
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		//half of keys is in sstable, the other half in memtable
+		if i == 12 {
+			if err := db.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var keys []string
+	path := "/scan?start=key&end=kez&limit=10"
+	for page := 0; ; page++ {
+		if page > 3 {
+			t.Fatal("Scan doesn't end")
+		}
+		response := serveRequest(router, http.MethodGet, path, nil, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Scan failed, status %d %s", response.Code, response.Body)
+		}
+		var scan ScanResponse
+		if err := json.Unmarshal(response.Body.Bytes(), &scan); err != nil {
+			t.Fatal(err)
+		}
+		if len(scan.Items) > 10 {
+			t.Fatalf("Page has %d items, limit is 10", len(scan.Items))
+		}
+		for _, item := range scan.Items {
+			if item.Value != "value"+item.Key[3:] {
+				t.Fatalf("Key %s has value %s", item.Key, item.Value)
+			}
+			keys = append(keys, item.Key)
+		}
+		if !scan.More {
+			break
+		}
+		path = "/scan?start=key&end=kez&limit=10&after=" + scan.Items[len(scan.Items)-1].Key
+	}
+	if len(keys) != 25 {
+		t.Fatalf("Scan returned %d keys, expected 25", len(keys))
+	}
+	for i, key := range keys {
+		if key != fmt.Sprintf("key%02d", i) {
+			t.Fatalf("Key %d is %s", i, key)
+		}
+	}
+	response := serveRequest(router, http.MethodGet, "/scan?limit=0", nil, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("Scan with limit 0 returned status %d", response.Code)
+	}
+}
+
+func TestDelete_Failed(t *testing.T) {
+	db, router := newTestRouter(t, nil, nil)
+	entry := NewEntry([]byte("anita"), []byte("Developer"))
+	if err := db.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if response := serveRequest(router, http.MethodDelete, "/anita", nil, nil); response.Code != http.StatusAccepted {
+		t.Fatalf("Delete failed, status %d %s", response.Code, response.Body)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	response := serveRequest(router, http.MethodDelete, "/bob", nil, nil)
+	if response.Code != http.StatusInternalServerError || !strings.Contains(response.Body.String(), "closed") {
+		t.Fatalf("Delete from closed store returned status %d %s", response.Code, response.Body)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.Logger = NopLogger()
+	options.ColumnFamilies = []ColumnFamilyOptions{{Name: "users"}}
+	db, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry([]byte("anita"), []byte("Developer"))
+	if err := db.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	users, _ := db.ColumnFamily("users")
+	entry = NewEntry([]byte("bob"), []byte("Tester"))
+	if err := users.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	options.ReadOnly = true
+	db, err = Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	config := DefaultConfig()
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(db, config, nil)
+	reads := []struct {
+		method string
+		path   string
+		body   string
+		value  string
+	}{
+		{http.MethodGet, "/fetch/anita", "", "Developer"},
+		{http.MethodGet, "/scan?start=a", "", "Developer"},
+		{http.MethodPost, "/_batch/mget", `{"keys":["anita"]}`, "Developer"},
+		{http.MethodPost, "/ns/users/_batch/mget", `{"keys":["bob"]}`, "Tester"},
+	}
+	for _, read := range reads {
+		response := serveRequest(router, read.method, read.path, strings.NewReader(read.body), jsonHeader)
+		if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), read.value) {
+			t.Fatalf("%s %s on read only store returned status %d %s", read.method, read.path, response.Code, response.Body)
+		}
+	}
+	writes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/anita", `{"value":"Manager"}`},
+		{http.MethodDelete, "/anita", ""},
+		{http.MethodDelete, "/_batch/range?start=a&end=b", ""},
+		{http.MethodPost, "/_batch/mput", `{"operations":[{"op":"delete","key":"anita"}]}`},
+		{http.MethodPost, "/ns/users/bob", `{"value":"Manager"}`},
+		//keys named mget are still written with their own routes
+		{http.MethodPost, "/mget", `{"value":"Manager"}`},
+		{http.MethodPost, "/ns/users/mget/merge", `{"value":"Manager"}`},
+	}
+	for _, write := range writes {
+		response := serveRequest(router, write.method, write.path, strings.NewReader(write.body), jsonHeader)
+		if response.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s %s on read only store returned status %d %s", write.method, write.path, response.Code, response.Body)
+		}
+	}
+}
+
+func TestKeysWithPrefixOfRoutes(t *testing.T) {
+	options := DefaultOptions()
+	options.ColumnFamilies = []ColumnFamilyOptions{{Name: "users"}}
+	_, router := newTestRouter(t, options, nil)
+	for _, prefix := range []string{"", "/ns/users"} {
+		for _, key := range []string{"range", "range1", "txn", "txn1", "mget", "mget1", "mput", "mput1", "mykey", "max", "mo", "merge", "_batch", "_batch1"} {
+			path := prefix + "/" + key
+			if response := serveRequest(router, http.MethodPost, path, strings.NewReader(`{"value":"Developer"}`), jsonHeader); response.Code != http.StatusAccepted {
+				t.Fatalf("Put of %s returned status %d %s", path, response.Code, response.Body)
+			}
+			if response := serveRequest(router, http.MethodGet, prefix+"/fetch/"+key, nil, nil); response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "Developer") {
+				t.Fatalf("Get of %s returned status %d %s", path, response.Code, response.Body)
+			}
+			if response := serveRequest(router, http.MethodDelete, path, nil, nil); response.Code != http.StatusAccepted {
+				t.Fatalf("Delete of %s returned status %d %s", path, response.Code, response.Body)
+			}
+			if response := serveRequest(router, http.MethodGet, prefix+"/fetch/"+key, nil, nil); response.Code != http.StatusNotFound {
+				t.Fatalf("Deleted %s returned status %d %s", path, response.Code, response.Body)
+			}
+		}
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	db, router := newTestRouter(t, nil, nil)
+	for _, key := range []string{"anita", "bob", "carl"} {
+		entry := NewEntry([]byte(key), []byte("Developer"))
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if response := serveRequest(router, http.MethodDelete, "/_batch/range?start=a&end=c", nil, nil); response.Code != http.StatusAccepted {
+		t.Fatalf("Delete range failed, status %d %s", response.Code, response.Body)
+	}
+	for key, status := range map[string]int{"anita": http.StatusNotFound, "bob": http.StatusNotFound, "carl": http.StatusOK} {
+		if response := serveRequest(router, http.MethodGet, "/fetch/"+key, nil, nil); response.Code != status {
+			t.Fatalf("Get of %s after delete range returned status %d", key, response.Code)
+		}
+	}
+	if response := serveRequest(router, http.MethodDelete, "/_batch/range?start=c&end=a", nil, nil); response.Code != http.StatusBadRequest {
+		t.Fatalf("Delete of invalid range returned status %d %s", response.Code, response.Body)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	response := serveRequest(router, http.MethodDelete, "/_batch/range?start=a&end=c", nil, nil)
+	if response.Code != http.StatusInternalServerError || !strings.Contains(response.Body.String(), "closed") {
+		t.Fatalf("Delete range of closed store returned status %d %s", response.Code, response.Body)
+	}
+}
+
+func TestAdmin_SStablesAndGc(t *testing.T) {
+	db, router := newTestRouter(t, nil, nil)
+	keys := []string{"anita", "\xff\xfebob"}
+	for _, key := range keys {
+		entry := NewEntry([]byte(key), []byte("Developer"))
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	response := serveRequest(router, http.MethodGet, "/admin/sstables", nil, nil)
+	var sstables []SStable
+	if err := json.Unmarshal(response.Body.Bytes(), &sstables); err != nil {
+		t.Fatalf("Sstables are not valid json %s, error %v", response.Body, err)
+	}
+	if len(sstables) == 0 || sstables[0].Encoding != base64Encoding {
+		t.Fatalf("Binary keys of sstables are not encoded %+v", sstables)
+	}
+	first, _ := decodeValue(sstables[0].FirstKey, sstables[0].Encoding)
+	last, _ := decodeValue(sstables[0].LastKey, sstables[0].Encoding)
+	if string(first) != keys[0] || string(last) != keys[1] {
+		t.Fatalf("Wrong key range %q %q", first, last)
+	}
+	if response := serveRequest(router, http.MethodPost, "/admin/gc?entries=10", nil, nil); response.Code != http.StatusOK {
+		t.Fatalf("Gc failed, status %d %s", response.Code, response.Body)
+	}
+	for _, key := range keys {
+		if value, found, err := db.Get([]byte(key)); err != nil || !found || string(value) != "Developer" {
+			t.Fatalf("Key %q has value %s after gc, error %v", key, value, err)
+		}
+	}
+}
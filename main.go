@@ -1,18 +1,71 @@
 package main
 
 import (
+	"context"
 	"wiskey/cmd"
+	"wiskey/grpc"
 	"wiskey/http"
+	"wiskey/memcached"
 	. "wiskey/pkg"
+	"wiskey/redis"
 )
 
+//Server that runs next to http server and is stopped together with it
+type server interface {
+	Serve() error
+	Shutdown(ctx context.Context)
+}
+
 func main() {
 	parse, err := cmd.Parse()
 	if err != nil {
 		panic(err)
 	}
-	vlog := NewVlog(parse.Vlog, parse.Checkpoint)
-	memtable := NewMemTable(parse.MemtableSize)
-	tree := NewLsmTree(vlog, parse.SStablePath, memtable, 120)
-	http.Start(tree)
+	options, err := parse.DBOptions()
+	if err != nil {
+		panic(err)
+	}
+	var db *DB
+	if parse.Dir != "" {
+		db, err = Open(parse.Dir, options)
+	} else {
+		db, err = OpenPaths(parse.SStablePath, parse.Vlog, parse.Checkpoint, options)
+	}
+	if err != nil {
+		panic(err)
+	}
+	config := parse.ServerConfig()
+	if parse.GrpcAddr != "" {
+		server, err := grpc.Listen(db, parse.GrpcAddr, config)
+		startServer(db, config, "grpc", server, err)
+	}
+	if parse.RedisAddr != "" {
+		server, err := redis.Listen(db, parse.RedisAddr, config)
+		startServer(db, config, "redis", server, err)
+	}
+	if parse.MemcachedAddr != "" {
+		server, err := memcached.Listen(db, parse.MemcachedFamily, parse.MemcachedAddr, config)
+		startServer(db, config, "memcached", server, err)
+	}
+	err = http.Start(db, config)
+	if err != nil {
+		panic(err)
+	}
+}
+
+//Serve in the background until http server is stopped
+func startServer(db *DB, config *http.Config, name string, server server, err error) {
+	if err != nil {
+		for _, shutdown := range config.OnShutdown {
+			shutdown(context.Background())
+		}
+		db.Close()
+		panic(err)
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			db.Logger().Error(name+" server failed", "error", err)
+		}
+	}()
+	config.OnShutdown = append(config.OnShutdown, server.Shutdown)
 }
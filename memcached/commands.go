@@ -0,0 +1,477 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+	"wiskey/http"
+	. "wiskey/pkg"
+)
+
+const (
+	flagsSize       = 4                 //flags of the client are saved as 32 bit number in flags family
+	maxRelativeTime = 60 * 60 * 24 * 30 //exptime up to 30 days is relative, bigger one is unix time
+)
+
+var (
+	errBadDataChunk = errors.New("data block isn't terminated by CRLF")
+	errAuthTooLarge = errors.New("auth data block is too large")
+)
+
+//Connection of a client
+type session struct {
+	server *Server
+	lsm    *LsmTree
+	reader *bufio.Reader
+	writer *bufio.Writer
+	grant  *http.Grant //nil until the client is authenticated or if auth is disabled
+}
+
+//Item with its flags, items that don't expire have zero ttl
+type item struct {
+	key   []byte
+	data  []byte
+	flags uint32
+	ttl   time.Duration
+}
+
+//Run the command and write its reply, returns true if the client asked to close the connection
+//error means that the stream can't be parsed anymore
+func (s *session) execute(args [][]byte) (bool, error) {
+	if len(args) == 0 {
+		s.reply("ERROR")
+		return false, nil
+	}
+	if s.server.auth != nil && s.grant == nil {
+		switch string(args[0]) {
+		case "set":
+			return false, s.authenticate(args)
+		case "quit":
+			return true, nil
+		}
+		s.clientError("unauthenticated")
+		return false, nil
+	}
+	switch string(args[0]) {
+	case "get":
+		s.get(args, false)
+	case "gets":
+		s.get(args, true)
+	case "set", "add", "replace", "cas":
+		return false, s.store(args)
+	case "delete":
+		s.delete(args)
+	case "incr", "decr":
+		s.incr(args)
+	case "version":
+		s.reply("VERSION wiskey")
+	case "quit":
+		return true, nil
+	default:
+		s.reply("ERROR")
+	}
+	return false, nil
+}
+
+func (s *session) reply(line string) {
+	s.writer.WriteString(line)
+	s.writer.WriteString("\r\n")
+}
+
+func (s *session) clientError(message string) {
+	s.reply("CLIENT_ERROR " + message)
+}
+
+//The first set of the connection carries "<username> <token>" as data like in memcached with auth file
+//username is ignored because tokens aren't bound to users
+func (s *session) authenticate(args [][]byte) error {
+	if len(args) < 5 {
+		s.reply("ERROR")
+		return nil
+	}
+	length, err := strconv.Atoi(string(args[4]))
+	if err != nil || length < 0 {
+		s.clientError("bad command line format")
+		return nil
+	}
+	if length > maxLineSize {
+		s.clientError("authentication failure")
+		return errAuthTooLarge
+	}
+	data, err := s.readData(length)
+	if err != nil {
+		return err
+	}
+	fields := bytes.Fields(data)
+	if len(fields) != 2 {
+		s.clientError("authentication failure")
+		return nil
+	}
+	grant, err := s.server.auth.Authenticate(string(fields[1]))
+	if err != nil {
+		s.clientError("authentication failure")
+		return nil
+	}
+	s.grant = grant
+	s.reply("STORED")
+	return nil
+}
+
+//Check that the grant allows the operation with given keys, client error is written otherwise
+func (s *session) authorized(operation string, keys ...[]byte) bool {
+	if s.grant == nil {
+		return true
+	}
+	if !s.grant.Allows(operation) {
+		s.clientError(operation + " operation is not allowed")
+		return false
+	}
+	for _, key := range keys {
+		if !s.grant.AllowsKey(key) {
+			s.clientError("key " + string(key) + " is not allowed")
+			return false
+		}
+	}
+	return true
+}
+
+//Read data block with its CRLF, big blocks are read in chunks
+//so a client that sends only the length can't make the server allocate it
+func (s *session) readData(length int) ([]byte, error) {
+	var data []byte
+	if length+2 <= dataChunk {
+		data = make([]byte, length+2)
+		if _, err := io.ReadFull(s.reader, data); err != nil {
+			return nil, err
+		}
+	} else {
+		var buffer bytes.Buffer
+		if _, err := io.CopyN(&buffer, s.reader, int64(length+2)); err != nil {
+			return nil, err
+		}
+		data = buffer.Bytes()
+	}
+	if data[length] != '\r' || data[length+1] != '\n' {
+		s.clientError("bad data chunk")
+		return nil, errBadDataChunk
+	}
+	return data[:length], nil
+}
+
+//get <key>*, gets also returns versions of the keys as cas unique
+func (s *session) get(args [][]byte, withVersion bool) {
+	if len(args) < 2 {
+		s.reply("ERROR")
+		return
+	}
+	if !s.authorized(http.PermissionRead, args[1:]...) {
+		return
+	}
+	//values are read before the reply is written so a failed read doesn't leave the reply incomplete
+	results, err := s.lsm.MultiGet(args[1:])
+	if err != nil {
+		s.reply("SERVER_ERROR " + err.Error())
+		return
+	}
+	var found [][]byte
+	for i, key := range args[1:] {
+		if results[i].Found {
+			found = append(found, key)
+		}
+	}
+	flags, err := s.server.flags.MultiGet(found)
+	if err != nil {
+		s.reply("SERVER_ERROR " + err.Error())
+		return
+	}
+	for i, key := range args[1:] {
+		if !results[i].Found {
+			continue
+		}
+		data, version := results[i].Value, results[i].Version
+		itemFlags := decodeFlags(flags[0].Value)
+		flags = flags[1:]
+		s.writer.WriteString("VALUE ")
+		s.writer.Write(key)
+		s.writer.WriteString(" " + strconv.FormatUint(uint64(itemFlags), 10) + " " + strconv.Itoa(len(data)))
+		if withVersion {
+			s.writer.WriteString(" " + strconv.FormatUint(version, 10))
+		}
+		s.writer.WriteString("\r\n")
+		s.writer.Write(data)
+		s.writer.WriteString("\r\n")
+	}
+	s.reply("END")
+}
+
+//<command> <key> <flags> <exptime> <bytes> [noreply]
+//cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (s *session) store(args [][]byte) error {
+	command := string(args[0])
+	fields := 5
+	if command == "cas" {
+		fields = 6
+	}
+	if len(args) != fields && len(args) != fields+1 {
+		s.reply("ERROR")
+		return nil
+	}
+	length, err := strconv.Atoi(string(args[4]))
+	if err != nil || length < 0 {
+		s.clientError("bad command line format")
+		return nil
+	}
+	if s.server.maxValueSize != 0 && int64(length) > s.server.maxValueSize {
+		if _, err := s.reader.Discard(length + 2); err != nil {
+			return err
+		}
+		s.reply("SERVER_ERROR object too large for cache")
+		return nil
+	}
+	data, err := s.readData(length)
+	if err != nil {
+		return err
+	}
+	key := args[1]
+	flags, flagsErr := strconv.ParseUint(string(args[2]), 10, 32)
+	exptime, exptimeErr := strconv.ParseInt(string(args[3]), 10, 64)
+	var unique uint64
+	var uniqueErr error
+	if command == "cas" {
+		unique, uniqueErr = strconv.ParseUint(string(args[5]), 10, 64)
+	}
+	noreply := len(args) == fields+1 && string(args[fields]) == "noreply"
+	if len(key) > maxKeySize || flagsErr != nil || exptimeErr != nil || uniqueErr != nil || (len(args) == fields+1 && !noreply) {
+		s.clientError("bad command line format")
+		return nil
+	}
+	if !s.authorized(http.PermissionWrite, key) {
+		return nil
+	}
+	//item with exptime in the past is saved already expired so it hides the old value
+	ttl, _ := expiration(exptime)
+	result, err := s.save(command, &item{key: key, data: data, flags: uint32(flags), ttl: ttl}, unique)
+	if err != nil {
+		result = "SERVER_ERROR " + err.Error()
+	}
+	if !noreply {
+		s.reply(result)
+	}
+	return nil
+}
+
+//Save the item and its flags in one transaction if the condition of the command holds, returns the reply
+//the transaction is retried if the key was changed by another client, cas fails instead
+func (s *session) save(command string, item *item, unique uint64) (string, error) {
+	for {
+		result, err := s.trySave(command, item, unique)
+		if errors.Is(err, ErrConflict) {
+			if command == "cas" {
+				return "EXISTS", nil
+			}
+			continue
+		}
+		return result, err
+	}
+}
+
+func (s *session) trySave(command string, item *item, unique uint64) (string, error) {
+	tx := s.lsm.Begin()
+	if command != "set" {
+		_, version, found, err := tx.GetWithVersion(item.key)
+		if err != nil {
+			tx.Rollback()
+			return "", err
+		}
+		result := ""
+		switch {
+		case command == "add" && found, command == "replace" && !found:
+			result = "NOT_STORED"
+		case command == "cas" && !found:
+			result = "NOT_FOUND"
+		case command == "cas" && version != unique:
+			result = "EXISTS"
+		}
+		if result != "" {
+			tx.Rollback()
+			return result, nil
+		}
+	}
+	entry := NewEntry(item.key, item.data)
+	if item.ttl != 0 {
+		entry = NewEntryWithTTL(item.key, item.data, item.ttl)
+	}
+	if err := tx.PutEntry(&entry); err != nil {
+		return "", err
+	}
+	flagsTx := tx.Family(s.server.flags)
+	if item.flags != 0 {
+		//flags expire together with the item
+		flags := NewEntry(item.key, encodeFlags(item.flags))
+		if item.ttl != 0 {
+			flags = NewEntryWithTTL(item.key, encodeFlags(item.flags), item.ttl)
+		}
+		if err := flagsTx.PutEntry(&flags); err != nil {
+			return "", err
+		}
+	} else if err := deleteFlags(flagsTx, item.key); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return "STORED", nil
+}
+
+//Delete flags of the key if it has them so items with 0 flags don't write tombstones
+func deleteFlags(tx *Transaction, key []byte) error {
+	_, found, err := tx.Get(key)
+	if err != nil || !found {
+		return err
+	}
+	return tx.Delete(key)
+}
+
+//delete <key> [0] [noreply]
+func (s *session) delete(args [][]byte) {
+	noreply := string(args[len(args)-1]) == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	if len(args) == 3 && string(args[2]) == "0" {
+		args = args[:2]
+	}
+	if len(args) != 2 {
+		s.clientError("bad command line format.  Usage: delete <key> [noreply]")
+		return
+	}
+	if !s.authorized(http.PermissionWrite, args[1]) {
+		return
+	}
+	result, err := s.tryDelete(args[1])
+	for errors.Is(err, ErrConflict) {
+		result, err = s.tryDelete(args[1])
+	}
+	if err != nil {
+		result = "SERVER_ERROR " + err.Error()
+	}
+	if !noreply {
+		s.reply(result)
+	}
+}
+
+//Delete the item and its flags in one transaction
+func (s *session) tryDelete(key []byte) (string, error) {
+	tx := s.lsm.Begin()
+	_, found, err := tx.Get(key)
+	if err != nil || !found {
+		tx.Rollback()
+		return "NOT_FOUND", err
+	}
+	if err := tx.Delete(key); err != nil {
+		return "", err
+	}
+	if err := deleteFlags(tx.Family(s.server.flags), key); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return "DELETED", nil
+}
+
+//incr|decr <key> <delta> [noreply], incr wraps around 64 bits and decr stops at 0
+//the new value keeps exptime and flags of the old one
+func (s *session) incr(args [][]byte) {
+	noreply := len(args) == 4 && string(args[3]) == "noreply"
+	if len(args) != 3 && !noreply {
+		s.reply("ERROR")
+		return
+	}
+	delta, err := strconv.ParseUint(string(args[2]), 10, 64)
+	if err != nil {
+		s.clientError("invalid numeric delta argument")
+		return
+	}
+	if !s.authorized(http.PermissionWrite, args[1]) {
+		return
+	}
+	result := ""
+	for {
+		data, version, expiresAt, found, err := s.lsm.GetWithExpiration(args[1])
+		if err != nil {
+			result = "SERVER_ERROR " + err.Error()
+			break
+		}
+		if !found {
+			result = "NOT_FOUND"
+			break
+		}
+		n, err := strconv.ParseUint(string(data), 10, 64)
+		if err != nil {
+			result = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+			break
+		}
+		if string(args[0]) == "incr" {
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		result = strconv.FormatUint(n, 10)
+		entry := NewEntry(args[1], []byte(result))
+		if !expiresAt.IsZero() {
+			entry = NewEntryWithTTL(args[1], []byte(result), time.Until(expiresAt))
+		}
+		_, err = s.lsm.CompareAndPut(&entry, version)
+		if errors.Is(err, ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			result = "SERVER_ERROR " + err.Error()
+		}
+		break
+	}
+	if !noreply {
+		s.reply(result)
+	}
+}
+
+//ttl of exptime, negative ttl means that the item is already expired
+//returns false if the item never expires
+func expiration(exptime int64) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return -time.Nanosecond, true
+	case exptime <= maxRelativeTime:
+		return time.Duration(exptime) * time.Second, true
+	}
+	ttl := time.Until(time.Unix(exptime, 0))
+	if ttl <= 0 {
+		ttl = -time.Nanosecond
+	}
+	return ttl, true
+}
+
+func encodeFlags(flags uint32) []byte {
+	value := make([]byte, flagsSize)
+	binary.BigEndian.PutUint32(value, flags)
+	return value
+}
+
+//Items without flags entry have 0 flags
+func decodeFlags(value []byte) uint32 {
+	if len(value) != flagsSize {
+		return 0
+	}
+	return binary.BigEndian.Uint32(value)
+}
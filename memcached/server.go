@@ -0,0 +1,129 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"wiskey/http"
+	. "wiskey/pkg"
+	"wiskey/tcp"
+)
+
+const (
+	maxLineSize  = 8192              //max size of the command line, it's also the max size of the auth data block
+	maxKeySize   = 250               //max size of the key, the same as memcached has
+	dataChunk    = 64 * 1024         //bigger data blocks are read in chunks so memory is taken only for received data
+	flagsSuffix  = "-flags"          //suffix of column family with flags of items
+	defaultFlags = "memcached-flags" //column family with flags of items of the default family
+)
+
+var (
+	errLineTooLong = errors.New("line is too long")
+)
+
+//Server of memcached text protocol
+//Serve and Shutdown are the ones of tcp server
+type Server struct {
+	*tcp.Server
+	lsm          *LsmTree
+	flags        *LsmTree            //column family with flags of items, items with 0 flags don't have them
+	auth         *http.Authenticator //nil if auth is disabled
+	maxValueSize int64               //0 means unlimited
+}
+
+//Column family that keeps flags of items of the family
+//flags are kept apart so values are the same for all servers
+func FlagsFamily(family string) string {
+	if family == "" {
+		return defaultFlags
+	}
+	return family + flagsSuffix
+}
+
+//Listen on tcp address, empty family means the default column family
+//the family and its flags family have to be opened
+//tokens, TLS and max value size are the same as in the config of http server, nil config means defaults
+func Listen(db *DB, family string, addr string, config *http.Config) (*Server, error) {
+	if config == nil {
+		config = http.DefaultConfig()
+	}
+	lsm := db.LsmTree
+	if family != "" {
+		var found bool
+		lsm, found = db.ColumnFamily(family)
+		if !found {
+			return nil, fmt.Errorf("column family %s is not opened", family)
+		}
+	}
+	flags, found := db.ColumnFamily(FlagsFamily(family))
+	if !found {
+		return nil, fmt.Errorf("column family %s with flags of memcached items is not opened", FlagsFamily(family))
+	}
+	auth, err := config.Auth()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{lsm: lsm, flags: flags, auth: auth, maxValueSize: config.MaxValueSize}
+	listener, err := tcp.Listen(addr, tlsConfig, server.handle)
+	if err != nil {
+		return nil, err
+	}
+	server.Server = listener
+	return server, nil
+}
+
+//Read commands and write replies, pipelined replies are flushed when there are no buffered commands
+func (server *Server) handle(conn *tcp.Conn) {
+	reader := bufio.NewReader(conn)
+	session := &session{server: server, lsm: server.lsm, reader: reader, writer: bufio.NewWriter(conn)}
+	for {
+		line, err := readLine(reader)
+		if errors.Is(err, errLineTooLong) {
+			session.clientError("line is too long")
+			session.writer.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		quit, err := session.execute(bytes.Fields(line))
+		if err != nil {
+			//data block can't be read so the rest of the stream can't be parsed
+			server.lsm.Logger().Debug("memcached protocol error", "remote", conn.RemoteAddr().String(), "error", err)
+			session.writer.Flush()
+			return
+		}
+		if quit || reader.Buffered() == 0 {
+			if err := session.writer.Flush(); err != nil {
+				return
+			}
+		}
+		if quit || (conn.Closing() && reader.Buffered() == 0) {
+			return
+		}
+	}
+}
+
+//Read the line without CRLF
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxLineSize {
+			return nil, errLineTooLong
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+	return bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'}), nil
+}
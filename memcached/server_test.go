@@ -0,0 +1,227 @@
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wiskey/http"
+	. "wiskey/pkg"
+)
+
+//Open the store with the family and its flags family in temporary directory and serve it on a random port
+func startTestServer(t *testing.T, family string, config *http.Config) (*DB, string) {
+	options := DefaultOptions()
+	options.Logger = NopLogger()
+	options.ColumnFamilies = []ColumnFamilyOptions{{Name: FlagsFamily(family)}}
+	if family != "" {
+		options.ColumnFamilies = append(options.ColumnFamilies, ColumnFamilyOptions{Name: family})
+	}
+	db, err := Open(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := Listen(db, family, "127.0.0.1:0", config)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() {
+		server.Shutdown(context.Background())
+		db.Close()
+	})
+	return db, server.Addr().String()
+}
+
+//Connection to the test server
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+//Send the request and check that the reply has expected lines
+func (c *testClient) expect(request string, lines ...string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(request)); err != nil {
+		c.t.Fatal(err)
+	}
+	for _, expected := range lines {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("expected %q, got %v", expected, err)
+		}
+		if strings.TrimSuffix(line, "\r\n") != expected {
+			c.t.Fatalf("expected %q, got %q", expected, line)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	_, addr := startTestServer(t, "", nil)
+	c := dial(t, addr)
+	c.expect("get a\r\n", "END")
+	c.expect("set a 0 0 1\r\n1\r\n", "STORED")
+	c.expect("add a 0 0 1\r\n2\r\n", "NOT_STORED")
+	c.expect("add b 0 0 1\r\n2\r\n", "STORED")
+	c.expect("replace c 0 0 1\r\n3\r\n", "NOT_STORED")
+	c.expect("replace b 0 0 1\r\n3\r\n", "STORED")
+	c.expect("get a b c\r\n", "VALUE a 0 1", "1", "VALUE b 0 1", "3", "END")
+	c.expect("set a 0 0 1 noreply\r\n4\r\nget a\r\n", "VALUE a 0 1", "4", "END")
+	c.expect("set a 0 0 1\r\n123\r\n", "CLIENT_ERROR bad data chunk")
+}
+
+func TestCas(t *testing.T) {
+	db, addr := startTestServer(t, "", nil)
+	c := dial(t, addr)
+	c.expect("cas a 0 0 1 1\r\n1\r\n", "NOT_FOUND")
+	c.expect("set a 0 0 1\r\n1\r\n", "STORED")
+	_, version, _, err := db.GetWithVersion([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unique := strconv.FormatUint(version, 10)
+	c.expect("gets a\r\n", "VALUE a 0 1 "+unique, "1", "END")
+	c.expect("cas a 5 0 1 "+unique+"\r\n2\r\n", "STORED")
+	c.expect("cas a 0 0 1 "+unique+"\r\n3\r\n", "EXISTS")
+	c.expect("get a\r\n", "VALUE a 5 1", "2", "END")
+}
+
+func TestDeleteAndIncr(t *testing.T) {
+	db, addr := startTestServer(t, "", nil)
+	c := dial(t, addr)
+	c.expect("delete a\r\n", "NOT_FOUND")
+	c.expect("incr a 1\r\n", "NOT_FOUND")
+	c.expect("set a 7 100 2\r\n41\r\n", "STORED")
+	c.expect("incr a 1\r\n", "42")
+	c.expect("decr a 50\r\n", "0")
+	c.expect("get a\r\n", "VALUE a 7 1", "0", "END")
+	_, _, expiresAt, _, err := db.GetWithExpiration([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiresAt) <= 90*time.Second || time.Until(expiresAt) > 100*time.Second {
+		t.Fatalf("unexpected expiration %v", expiresAt)
+	}
+	c.expect("set b 0 0 5\r\nhello\r\n", "STORED")
+	c.expect("incr b 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	c.expect("delete a\r\n", "DELETED")
+	c.expect("get a\r\n", "END")
+	flags, _ := db.ColumnFamily(FlagsFamily(""))
+	if _, found, err := flags.Get([]byte("a")); err != nil || found {
+		t.Fatalf("flags of deleted item are kept: %v", err)
+	}
+}
+
+//Values are the same for all servers, flags are kept in their own family
+func TestFlags(t *testing.T) {
+	db, addr := startTestServer(t, "cache", nil)
+	cache, _ := db.ColumnFamily("cache")
+	flags, _ := db.ColumnFamily("cache-flags")
+	c := dial(t, addr)
+	entry := NewEntry([]byte("a"), []byte("hello"))
+	if err := cache.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	c.expect("get a\r\n", "VALUE a 0 5", "hello", "END")
+	c.expect("set b 305419896 0 5\r\nworld\r\n", "STORED")
+	c.expect("get b\r\n", "VALUE b 305419896 5", "world", "END")
+	value, _, err := cache.Get([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "world" {
+		t.Fatalf("unexpected value %q", value)
+	}
+	//items with 0 flags don't have flags entry
+	c.expect("set b 0 0 5\r\nworld\r\n", "STORED")
+	if _, found, err := flags.Get([]byte("b")); err != nil || found {
+		t.Fatalf("flags of item with 0 flags are kept: %v", err)
+	}
+	c.expect("get b\r\n", "VALUE b 0 5", "world", "END")
+	//flags expire together with the item
+	c.expect("set c 1 -1 5\r\nworld\r\n", "STORED")
+	c.expect("get c\r\n", "END")
+	if _, found, err := flags.Get([]byte("c")); err != nil || found {
+		t.Fatalf("flags of expired item are found: %v", err)
+	}
+}
+
+func TestListen_WithoutFlagsFamily(t *testing.T) {
+	options := DefaultOptions()
+	options.Logger = NopLogger()
+	db, err := Open(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := Listen(db, "", "127.0.0.1:0", nil); err == nil {
+		t.Fatal("server is started without flags family")
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	config := http.DefaultConfig()
+	config.MaxValueSize = 8
+	_, addr := startTestServer(t, "", config)
+	c := dial(t, addr)
+	c.expect("set a 0 0 8\r\n12345678\r\n", "STORED")
+	c.expect("set a 0 0 9\r\n123456789\r\n", "SERVER_ERROR object too large for cache")
+	c.expect("get a\r\n", "VALUE a 0 8", "12345678", "END")
+}
+
+func TestAuth(t *testing.T) {
+	content, err := json.Marshal(http.AuthConfig{Tokens: []http.StaticToken{
+		{Token: "reader", Grant: http.Grant{Name: "reader", Operations: []string{http.PermissionRead}, Prefixes: []string{"a"}}},
+		{Token: "writer", Grant: http.Grant{Name: "writer", Operations: []string{http.PermissionRead, http.PermissionWrite}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := http.DefaultConfig()
+	config.AuthConfig = filepath.Join(t.TempDir(), "auth.json")
+	if err := ioutil.WriteFile(config.AuthConfig, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startTestServer(t, "", config)
+
+	writer := dial(t, addr)
+	writer.expect("get a1\r\n", "CLIENT_ERROR unauthenticated")
+	writer.expect("set auth 0 0 12\r\nuser unknown\r\n", "CLIENT_ERROR authentication failure")
+	writer.expect("set auth 0 0 11\r\nuser writer\r\n", "STORED")
+	writer.expect("set a1 0 0 1\r\n1\r\n", "STORED")
+	writer.expect("set b1 0 0 1\r\n2\r\n", "STORED")
+
+	reader := dial(t, addr)
+	reader.expect("set auth 0 0 11\r\nuser reader\r\n", "STORED")
+	reader.expect("get a1\r\n", "VALUE a1 0 1", "1", "END")
+	reader.expect("get a1 b1\r\n", "CLIENT_ERROR key b1 is not allowed")
+	reader.expect("set a1 0 0 1\r\n2\r\n", "CLIENT_ERROR write operation is not allowed")
+	reader.expect("delete a1\r\n", "CLIENT_ERROR write operation is not allowed")
+	reader.expect("incr a1 1\r\n", "CLIENT_ERROR write operation is not allowed")
+
+	//big auth data closes the connection before it's read
+	big := dial(t, addr)
+	big.expect("set auth 0 0 100000\r\n", "CLIENT_ERROR authentication failure")
+	if _, err := big.reader.ReadByte(); err == nil {
+		t.Fatal("connection isn't closed")
+	}
+}
@@ -0,0 +1,113 @@
+package wiskey
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+var (
+	//delay before the first retry of failed background job, it's doubled after every retry
+	backgroundRetryDelay = time.Second
+	//max delay between retries of failed background job
+	maxBackgroundRetryDelay = time.Minute
+)
+
+const (
+	//failed background job is retried this amount of times before the tree becomes read only
+	maxBackgroundRetries = 5
+)
+
+//State of background jobs, it's shared by all column families
+type BackgroundState int
+
+const (
+	BackgroundOk BackgroundState = iota
+	BackgroundRetrying
+	BackgroundFailed
+)
+
+func (state BackgroundState) String() string {
+	switch state {
+	case BackgroundRetrying:
+		return "retrying"
+	case BackgroundFailed:
+		return "failed"
+	default:
+		return "ok"
+	}
+}
+
+//State of background jobs and the last error
+//failed tree is read only until it's reopened
+type BackgroundStats struct {
+	State     BackgroundState
+	Operation string    //job that failed, empty if state is ok
+	Error     string    //the last error, empty if state is ok
+	Retries   int       //amount of retries of failed job
+	Since     time.Time //time when the state was changed
+}
+
+//Check if error of background job can disappear by itself,
+//for example if disk space is freed or file descriptors are closed
+func isRetryable(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EAGAIN, syscall.EINTR, syscall.EMFILE, syscall.ENFILE, syscall.EBUSY} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+//Record error of background job, caller has to hold the write lock
+//returns delay before the retry, false means that the error is fatal and the tree became read only
+func (lsm *LsmTree) backgroundError(operation string, err error) (time.Duration, bool) {
+	background := &lsm.state.background
+	if background.State == BackgroundFailed {
+		return 0, false
+	}
+	if background.State == BackgroundOk || background.Operation != operation {
+		background.Retries = 0
+	}
+	retryable := isRetryable(err) && background.Retries < maxBackgroundRetries
+	background.Operation = operation
+	background.Error = err.Error()
+	background.Since = time.Now()
+	lsm.notify(func(listener EventListener) {
+		listener.OnBackgroundError(BackgroundErrorInfo{Family: lsm.family, Operation: operation, Err: err, Fatal: !retryable})
+	})
+	if !retryable {
+		background.State = BackgroundFailed
+		lsm.state.logger.Error("background job failed, tree is read only", "family", lsm.family, "operation", operation, "retries", background.Retries, "error", err)
+		return 0, false
+	}
+	delay := backgroundRetryDelay << uint(background.Retries)
+	if delay > maxBackgroundRetryDelay {
+		delay = maxBackgroundRetryDelay
+	}
+	background.State = BackgroundRetrying
+	background.Retries++
+	lsm.state.logger.Warn("background job failed, it will be retried", "family", lsm.family, "operation", operation, "retry", background.Retries, "delay", delay, "error", err)
+	return delay, true
+}
+
+//Reset the state after successful retry of background job, caller has to hold the write lock
+func (lsm *LsmTree) backgroundRecovered(operation string) {
+	background := &lsm.state.background
+	if background.State != BackgroundRetrying || background.Operation != operation {
+		return
+	}
+	lsm.state.logger.Info("background job recovered", "family", lsm.family, "operation", operation, "retries", background.Retries)
+	lsm.state.background = BackgroundStats{Since: time.Now()}
+}
+
+//Error returned by writes after fatal background error
+func (lsm *LsmTree) backgroundFailure() error {
+	background := lsm.state.background
+	if background.State != BackgroundFailed {
+		return nil
+	}
+	return fmt.Errorf("%w, %s failed: %s", ErrReadOnly, background.Operation, background.Error)
+}
@@ -0,0 +1,124 @@
+package wiskey
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	if !isRetryable(&os.PathError{Op: "write", Path: "vlog", Err: syscall.ENOSPC}) {
+		t.Fatal("No space left should be retried")
+	}
+	if isRetryable(&os.PathError{Op: "open", Path: "sstable", Err: syscall.ENOENT}) {
+		t.Fatal("Missing sstable can't be retried")
+	}
+	if isRetryable(errors.New("corrupted sstable")) {
+		t.Fatal("Unknown error can't be retried")
+	}
+}
+
+func TestLsmTree_BackgroundRetries(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	options := DefaultOptions()
+	options.Logger = NopLogger()
+	db, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	noSpace := &os.PathError{Op: "write", Path: "sstable", Err: syscall.ENOSPC}
+	db.rwm.Lock()
+	first, retryable := db.backgroundError("merge", noSpace)
+	second, _ := db.backgroundError("merge", noSpace)
+	db.rwm.Unlock()
+	if !retryable || second != 2*first {
+		t.Fatalf("Expected doubled delay, got %v and %v", first, second)
+	}
+	if stats := db.Stats().Background; stats.State != BackgroundRetrying || stats.Retries != 2 || stats.Operation != "merge" {
+		t.Fatalf("Wrong background state %+v", stats)
+	}
+	db.rwm.Lock()
+	db.backgroundRecovered("merge")
+	db.rwm.Unlock()
+	if stats := db.Stats().Background; stats.State != BackgroundOk || stats.Error != "" {
+		t.Fatalf("State wasn't reset after recovery %+v", stats)
+	}
+	db.rwm.Lock()
+	for i := 0; i < maxBackgroundRetries; i++ {
+		db.backgroundError("merge", noSpace)
+	}
+	_, retryable = db.backgroundError("merge", noSpace)
+	db.rwm.Unlock()
+	if retryable || !db.ReadOnly() {
+		t.Fatal("Tree didn't become read only after all retries")
+	}
+}
+
+func TestLsmTree_FatalMergeError(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	listener := &recordingListener{}
+	options := DefaultOptions()
+	options.Logger = NopLogger()
+	options.MergeInterval = 1
+	options.EventListeners = []EventListener{listener}
+	db, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"ANITA", "JOHN"} {
+		entry := NewEntry([]byte(key), []byte("DEVELOPER"))
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	memtableEntry := NewEntry([]byte("MAX"), []byte("DEVELOPER"))
+	if err := db.Put(&memtableEntry); err != nil {
+		t.Fatal(err)
+	}
+	//merge can't read removed sstable
+	db.rwm.Lock()
+	os.Remove(db.sstables[0])
+	db.rwm.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for !db.ReadOnly() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	stats := db.Stats().Background
+	if stats.State != BackgroundFailed || stats.Operation != "merge" || stats.Error == "" {
+		t.Fatalf("Wrong background state %+v", stats)
+	}
+	if len(listener.errors) != 1 || !listener.errors[0].Fatal {
+		t.Fatalf("Fatal error wasn't reported to listener %+v", listener.errors)
+	}
+	entry := NewEntry([]byte("SARA"), []byte("DEVELOPER"))
+	if err := db.Put(&entry); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Expected read only error, got %v", err)
+	}
+	if _, found := mustGet(t, db.LsmTree, []byte("MAX")); !found {
+		t.Fatal("Reads should work after background error")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//lock is released so the store can be reopened
+	db, err = Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.ReadOnly() {
+		t.Fatal("Reopened tree is still read only")
+	}
+	if _, found := mustGet(t, db.LsmTree, []byte("MAX")); !found {
+		t.Fatal("Memtable wasn't restored from vlog")
+	}
+}
@@ -0,0 +1,81 @@
+package wiskey
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	comparatorFile = "COMPARATOR" //file in sstable directory with the name of comparator that sorted the keys
+)
+
+var (
+	ErrComparatorMismatch = errors.New("sstables were created with another comparator")
+)
+
+//Comparator defines the order of keys in memtable and sstables
+//the name is saved on disk so the store can't be opened with a different order
+type Comparator interface {
+	//Unique name of the comparator
+	Name() string
+	//Returns 0 if a == b, negative if a < b and positive if a > b
+	Compare(a []byte, b []byte) int
+}
+
+//Orders keys byte by byte, it's the default comparator
+type BytewiseComparator struct{}
+
+func (BytewiseComparator) Name() string {
+	return "bytewise"
+}
+
+func (BytewiseComparator) Compare(a []byte, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+//Orders keys byte by byte in descending order
+type ReverseBytewiseComparator struct{}
+
+func (ReverseBytewiseComparator) Name() string {
+	return "reverse-bytewise"
+}
+
+func (ReverseBytewiseComparator) Compare(a []byte, b []byte) int {
+	return bytes.Compare(b, a)
+}
+
+//Find built-in comparator by its name
+func ComparatorByName(name string) (Comparator, error) {
+	switch name {
+	case BytewiseComparator{}.Name():
+		return BytewiseComparator{}, nil
+	case ReverseBytewiseComparator{}.Name():
+		return ReverseBytewiseComparator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown comparator %s", name)
+	}
+}
+
+//Save comparator name in the directory or check that it matches the saved one
+//read only tree doesn't save the name
+func checkComparator(dir string, comparator Comparator, readOnly bool) error {
+	path := dir + "/" + comparatorFile
+	saved, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if readOnly {
+			return nil
+		}
+		return ioutil.WriteFile(path, []byte(comparator.Name()), 0666)
+	}
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(saved)) != comparator.Name() {
+		return fmt.Errorf("%w: saved %s, given %s", ErrComparatorMismatch, saved, comparator.Name())
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package wiskey
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLsmTree_ReverseComparator(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "")
+	vlogFile, _ := ioutil.TempFile("", "")
+	checkpoint, _ := ioutil.TempFile("", "")
+	defer os.RemoveAll(tempDir)
+	defer os.Remove(vlogFile.Name())
+	defer os.Remove(checkpoint.Name())
+	tree := NewLsmTree(NewVlog(vlogFile.Name(), checkpoint.Name()), tempDir, NewMemTableWithComparator(1000, ReverseBytewiseComparator{}), 30)
+	entries := FakeEntries()
+	for i := range entries {
+		if err := tree.Put(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+		//keep part of the keys in memtable
+		if i == 3 {
+			if err := tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, entry := range entries {
+		value, found := mustGet(t, tree, entry.key)
+		if !found || string(value) != string(entry.value) {
+			t.Fatalf("Key %s has wrong value %s", entry.key, value)
+		}
+	}
+	iterator := tree.NewIterator([]byte("Z"), []byte("B"))
+	var keys []string
+	for iterator.Next() {
+		keys = append(keys, string(iterator.Key()))
+	}
+	expected := []string{"WNITA", "TNITA", "NNITA", "GNITA", "BNITA"}
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected keys %v, got %v", expected, keys)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Fatalf("Expected keys %v, got %v", expected, keys)
+		}
+	}
+	//keys without bounds are scanned from the first key in the order of the comparator
+	iterator = tree.NewIterator(nil, nil)
+	keys = nil
+	for iterator.Next() {
+		keys = append(keys, string(iterator.Key()))
+	}
+	if iterator.Err() != nil {
+		t.Fatal(iterator.Err())
+	}
+	if len(keys) != len(entries) {
+		t.Fatalf("Expected %d keys, got %v", len(entries), keys)
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] <= keys[i] {
+			t.Fatalf("Keys %v aren't in reverse order", keys)
+		}
+	}
+	crash(tree)
+	tree.memtable = NewMemTable(1000)
+	tree.comparator = BytewiseComparator{}
+	tree.state = &treeState{stop: make(chan struct{})}
+	if err := tree.open(30); !errors.Is(err, ErrComparatorMismatch) {
+		t.Fatalf("Sstables were opened with another comparator, error %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package wiskey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	vlogFileName       = "vlog"       //vlog file in data directory
+	checkpointFileName = "CHECKPOINT" //checkpoint of the default column family in data directory
+	sstableDirName     = "sstables"   //directory with sstables in data directory
+	manifestFileName   = "MANIFEST"   //description of data directory
+	manifestVersion    = 1            //version of data directory layout
+)
+
+var (
+	ErrInvalidOptions = errors.New("invalid options")
+)
+
+//Settings of the database
+type Options struct {
+	MemtableSize   int                   //max size of memtable in bytes before it's flushed to sstable
+	MergeInterval  uint                  //interval in seconds between sstable merges
+	Comparator     Comparator            //order of keys, can't be changed after sstables were created
+	MergeOperator  MergeOperator         //operator for merge requests, merges are rejected if nil
+	ColumnFamilies []ColumnFamilyOptions //column families to open besides the default one
+	WriteStall     WriteStallOptions     //triggers of write stalls
+	IORateLimit    int64                 //bytes per second written by flush and merge, 0 means unlimited
+	Logger         Logger                //info messages are written to stderr if nil
+	EventListeners []EventListener       //listeners of flushes, merges and vlog gc
+	//read only database doesn't take the lock of the store so it can be opened while another process writes to it
+	//writes return ErrReadOnly
+	ReadOnly bool
+}
+
+//Settings of column family
+type ColumnFamilyOptions struct {
+	Name          string
+	MemtableSize  int  //memtable size of the default family is used if 0
+	MergeInterval uint //merge interval of the default family is used if 0
+}
+
+//Default settings
+func DefaultOptions() *Options {
+	return &Options{
+		MemtableSize:  20,
+		MergeInterval: 120,
+		Comparator:    BytewiseComparator{},
+	}
+}
+
+//Embedded database, it's the default column family of lsm tree
+type DB struct {
+	*LsmTree
+	options *Options
+}
+
+//Content of manifest file
+type manifest struct {
+	Version    int    `json:"version"`
+	Comparator string `json:"comparator"`
+}
+
+//Open database in given directory, the directory is created if it doesn't exist
+//the directory contains vlog, checkpoint, manifest and sstables sub directory
+//nil options means default options
+func Open(dir string, options *Options) (*DB, error) {
+	if options == nil {
+		options = DefaultOptions()
+	}
+	err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+	if !options.ReadOnly {
+		err = os.MkdirAll(dir, os.ModeDir|0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = checkManifest(dir+"/"+manifestFileName, options.Comparator, options.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	return OpenPaths(dir+"/"+sstableDirName, dir+"/"+vlogFileName, dir+"/"+checkpointFileName, options)
+}
+
+//Open database with sstables, vlog and checkpoint in different places
+//it's used by the stores that were created before single data directory
+func OpenPaths(sstableDir string, vlogFile string, checkpoint string, options *Options) (*DB, error) {
+	if options == nil {
+		options = DefaultOptions()
+	}
+	err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+	log, err := openVlog(vlogFile, checkpoint, options.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	lsm := newLsmTree(log, sstableDir, NewMemTableWithComparator(options.MemtableSize, options.Comparator))
+	lsm.mergeOperator = options.MergeOperator
+	lsm.state.readOnly = options.ReadOnly
+	lsm.state.stallOptions = options.WriteStall
+	lsm.state.limiter = newRateLimiter(options.IORateLimit)
+	if options.Logger != nil {
+		lsm.state.logger = options.Logger
+	}
+	lsm.state.listeners = options.EventListeners
+	err = lsm.open(options.MergeInterval)
+	if err != nil {
+		return nil, err
+	}
+	for _, family := range options.ColumnFamilies {
+		memtableSize, mergeInterval := family.MemtableSize, family.MergeInterval
+		if memtableSize == 0 {
+			memtableSize = options.MemtableSize
+		}
+		if mergeInterval == 0 {
+			mergeInterval = options.MergeInterval
+		}
+		_, err := lsm.OpenColumnFamily(family.Name, memtableSize, mergeInterval)
+		if err != nil {
+			lsm.Close()
+			return nil, fmt.Errorf("can't open column family %s: %w", family.Name, err)
+		}
+	}
+	lsm.rwm.Lock()
+	lsm.state.ready = true
+	lsm.rwm.Unlock()
+	return &DB{LsmTree: lsm, options: options}, nil
+}
+
+//Settings the database was opened with
+func (db *DB) Options() Options {
+	return *db.options
+}
+
+func (options *Options) validate() error {
+	if options.MemtableSize <= 0 {
+		return fmt.Errorf("%w: memtable size has to be positive", ErrInvalidOptions)
+	}
+	if options.MergeInterval == 0 {
+		return fmt.Errorf("%w: merge interval has to be positive", ErrInvalidOptions)
+	}
+	if options.Comparator == nil {
+		return fmt.Errorf("%w: comparator is not set", ErrInvalidOptions)
+	}
+	if options.IORateLimit < 0 {
+		return fmt.Errorf("%w: io rate limit is negative", ErrInvalidOptions)
+	}
+	err := options.WriteStall.validate()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, family := range options.ColumnFamilies {
+		if !familyNamePattern.MatchString(family.Name) {
+			return fmt.Errorf("%w: column family %q: %s", ErrInvalidOptions, family.Name, ErrInvalidFamily)
+		}
+		if names[family.Name] {
+			return fmt.Errorf("%w: column family %s is used twice", ErrInvalidOptions, family.Name)
+		}
+		if family.MemtableSize < 0 {
+			return fmt.Errorf("%w: memtable size of column family %s is negative", ErrInvalidOptions, family.Name)
+		}
+		names[family.Name] = true
+	}
+	return nil
+}
+
+//Create manifest if the directory is new or check that the directory can be opened with given comparator
+//read only database can't be opened in the directory without manifest
+func checkManifest(path string, comparator Comparator, readOnly bool) error {
+	content, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !readOnly {
+		content, err := json.Marshal(manifest{Version: manifestVersion, Comparator: comparator.Name()})
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, content, 0666)
+	}
+	if err != nil {
+		return err
+	}
+	var saved manifest
+	err = json.Unmarshal(content, &saved)
+	if err != nil {
+		return fmt.Errorf("corrupted manifest %s: %w", path, err)
+	}
+	if saved.Version != manifestVersion {
+		return fmt.Errorf("unsupported data directory version %d", saved.Version)
+	}
+	if saved.Comparator != comparator.Name() {
+		return fmt.Errorf("%w: saved %s, given %s", ErrComparatorMismatch, saved.Comparator, comparator.Name())
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package wiskey
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	options := DefaultOptions()
+	options.ColumnFamilies = []ColumnFamilyOptions{{Name: "users"}}
+	db, err := Open(dir+"/data", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{vlogFileName, sstableDirName, manifestFileName} {
+		if _, err := os.Stat(dir + "/data/" + file); err != nil {
+			t.Fatalf("%s wasn't created %v", file, err)
+		}
+	}
+	entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	if err := db.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, opened := db.ColumnFamily("users"); !opened {
+		t.Fatal("Column family wasn't opened")
+	}
+	if _, err := Open(dir+"/data", nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Store was opened twice, error %v", err)
+	}
+	readOnly, err := Open(dir+"/data", &Options{MemtableSize: 20, MergeInterval: 1, Comparator: BytewiseComparator{}, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readOnly.Put(&entry); err != ErrReadOnly {
+		t.Fatalf("Read only store accepted write, error %v", err)
+	}
+	if err := readOnly.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(dir+"/data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, found := mustGet(t, reopened.LsmTree, entry.key)
+	if !found || string(value) != "DEVELOPER" {
+		t.Fatalf("Value wasn't restored, found %v value %s", found, value)
+	}
+	defer reopened.Close()
+	options = DefaultOptions()
+	options.Comparator = ReverseBytewiseComparator{}
+	if _, err := Open(dir+"/data", options); !errors.Is(err, ErrComparatorMismatch) {
+		t.Fatalf("Data directory was opened with another comparator, error %v", err)
+	}
+}
+
+func TestOpen_InvalidOptions(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	invalid := []*Options{
+		{MemtableSize: 0, MergeInterval: 1, Comparator: BytewiseComparator{}},
+		{MemtableSize: 1, MergeInterval: 0, Comparator: BytewiseComparator{}},
+		{MemtableSize: 1, MergeInterval: 1},
+		{MemtableSize: 1, MergeInterval: 1, Comparator: BytewiseComparator{}, ColumnFamilies: []ColumnFamilyOptions{{Name: "../users"}}},
+	}
+	for _, options := range invalid {
+		if _, err := Open(dir, options); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("Invalid options %+v were accepted, error %v", options, err)
+		}
+	}
+}
+
+func TestOpen_ReadOnly(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	options := DefaultOptions()
+	options.ReadOnly = true
+	if _, err := Open(dir+"/missing", options); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Read only store was opened in missing directory, error %v", err)
+	}
+	if _, err := os.Stat(dir + "/missing"); !os.IsNotExist(err) {
+		t.Fatal("Read only store created the directory")
+	}
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	if err := db.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := os.Stat(dir + "/" + vlogFileName)
+	//unflushed entries are read from vlog while the writer is still opened
+	readOnly, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	value, found := mustGet(t, readOnly.LsmTree, entry.key)
+	if !found || string(value) != "DEVELOPER" {
+		t.Fatalf("Value wasn't restored, found %v value %s", found, value)
+	}
+	writes := map[string]func() error{
+		"put":     func() error { return readOnly.Put(&entry) },
+		"delete":  func() error { return readOnly.Delete(entry.key) },
+		"flush":   readOnly.Flush,
+		"merge":   readOnly.Merge,
+		"vlog gc": readOnly.CompressVlog,
+		"range":   func() error { return readOnly.DeleteRange([]byte("A"), []byte("B")) },
+		"commit":  func() error { tx := readOnly.Begin(); _ = tx.Put(entry.key, entry.value); return tx.Commit() },
+	}
+	for name, write := range writes {
+		if err := write(); err != ErrReadOnly {
+			t.Fatalf("Read only store accepted %s, error %v", name, err)
+		}
+	}
+	if _, err := readOnly.OpenColumnFamily("users", 20, 1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Read only store created column family, error %v", err)
+	}
+	if err := readOnly.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(dir + "/" + vlogFileName)
+	if after.Size() != stat.Size() {
+		t.Fatal("Read only store changed vlog")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenPaths_SharedVlog(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	db, err := OpenPaths(dir+"/first", dir+"/vlog", dir+"/first-checkpoint", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPaths(dir+"/second", dir+"/vlog", dir+"/second-checkpoint", nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Vlog was opened by two stores, error %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := OpenPaths(dir+"/second", dir+"/vlog", dir+"/second-checkpoint", nil)
+	if err != nil {
+		t.Fatalf("Vlog wasn't unlocked by close, error %v", err)
+	}
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
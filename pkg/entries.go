@@ -7,11 +7,12 @@ import (
 	"time"
 )
 
-
 // SSTABLE Entry
 type sstableEntry struct {
 	key         []byte //key
-	timeStamp   uint64 //when it was created
+	kind        byte   //value or merge operands
+	timeStamp   uint64 //when it was written, it's also a version of the key
+	expiresAt   uint64 //when it expires, 0 if it never expires
 	valueOffset uint32 //offset of the value to read
 	valueLength uint32 //the length of the value
 }
@@ -19,24 +20,26 @@ type sstableEntry struct {
 func DeletedSstableEntry(key []byte) *sstableEntry {
 	return &sstableEntry{
 		key:       key,
-		timeStamp: uint64(time.Now().Unix()),
+		timeStamp: uint64(time.Now().UnixNano()),
 	}
 }
 
 func NewSStableEntry(key []byte, meta *ValueMeta) *sstableEntry {
 	return &sstableEntry{
 		key:         key,
-		timeStamp:   uint64(time.Now().Unix()),
+		kind:        meta.kind,
+		timeStamp:   meta.timestamp,
+		expiresAt:   meta.expiresAt,
 		valueOffset: meta.offset,
 		valueLength: meta.length,
 	}
 }
 
 //write entry to sstable
-//Format [key length + key +  timestamp + meta + offset + length]
-// +------------+-----+-----------+------------+------------+
-// | Key Length | Key | timestamp | vlogoffset | vloglength |
-// +------------+-----+-----------+------------+------------+
+//Format [key length + key + kind + timestamp + expiration + meta + offset + length]
+// +------------+-----+------+-----------+-----------+------------+------------+
+// | Key Length | Key | Kind | timestamp | expiresAt | vlogoffset | vloglength |
+// +------------+-----+------+-----------+-----------+------------+------------+
 func (entry *sstableEntry) writeTo(writer io.Writer) (uint32, error) {
 	buffer := bytes.NewBuffer([]byte{})
 	//key length
@@ -47,10 +50,18 @@ func (entry *sstableEntry) writeTo(writer io.Writer) (uint32, error) {
 	if err := binary.Write(buffer, binary.BigEndian, entry.key); err != nil {
 		return 0, err
 	}
+	//kind
+	if err := buffer.WriteByte(entry.kind); err != nil {
+		return 0, err
+	}
 	//timestamp
 	if err := binary.Write(buffer, binary.BigEndian, entry.timeStamp); err != nil {
 		return 0, err
 	}
+	//expiration
+	if err := binary.Write(buffer, binary.BigEndian, entry.expiresAt); err != nil {
+		return 0, err
+	}
 	//offset
 	if err := binary.Write(buffer, binary.BigEndian, entry.valueOffset); err != nil {
 		return 0, err
@@ -65,12 +76,24 @@ func (entry *sstableEntry) writeTo(writer io.Writer) (uint32, error) {
 
 /// TableEntry
 
+const (
+	valueKind          = byte(0) //regular key value pair
+	rangeTombstoneKind = byte(1) //range tombstone, key is the start of the range and value is timestamp + end of the range
+	mergeOperandKind   = byte(2) //merge operand that is applied to the previous value of the key
+	mergeOperandsKind  = byte(3) //all merge operands of the key, they replace previous operands
+	batchKind          = byte(4) //entries of a transaction, value is a sequence of vlog entries
+)
+
 // entries that are stored in the vlog file
 // key and value are byte arrays so they support anything that
 // can be converted to byte array
 type TableEntry struct {
-	key   []byte
-	value []byte
+	key       []byte
+	value     []byte
+	kind      byte
+	timestamp uint64 //unix time in nanoseconds when entry was written
+	expiresAt uint64 //unix time in nanoseconds when entry expires, 0 if it never expires
+	family    string //column family of the entry, empty for the default one
 }
 
 func DeletedEntry(key []byte) *TableEntry {
@@ -84,10 +107,56 @@ func NewEntry(key []byte, value []byte) TableEntry {
 	return TableEntry{key: key, value: value}
 }
 
+//Entry that expires after given ttl
+func NewEntryWithTTL(key []byte, value []byte, ttl time.Duration) TableEntry {
+	return TableEntry{key: key, value: value, expiresAt: uint64(time.Now().Add(ttl).UnixNano())}
+}
+
+//Entry with a single merge operand
+func MergeOperandEntry(key []byte, operand []byte) *TableEntry {
+	return &TableEntry{key: key, value: encodeOperands([][]byte{operand}), kind: mergeOperandKind}
+}
+
+func RangeTombstoneEntry(tombstone *rangeTombstone) *TableEntry {
+	value := make([]byte, int64Size+len(tombstone.end))
+	binary.BigEndian.PutUint64(value, tombstone.timestamp)
+	copy(value[int64Size:], tombstone.end)
+	return &TableEntry{key: tombstone.start, value: value, kind: rangeTombstoneKind}
+}
+
+//Entries of many keys and column families that are written to vlog as a single entry
+//so they are restored all or nothing after a crash
+//returns offsets of the entries from the start of the batch entry
+func BatchEntry(entries []*TableEntry) (*TableEntry, []uint32, error) {
+	buffer := bytes.NewBuffer([]byte{})
+	offsets := make([]uint32, len(entries))
+	for i, entry := range entries {
+		offsets[i] = vlogHeaderSize + uint32(buffer.Len())
+		if _, err := entry.writeTo(buffer); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &TableEntry{value: buffer.Bytes(), kind: batchKind}, offsets, nil
+}
+
+//Entries of the batch with their offsets from the start of the batch entry
+func decodeBatch(batch *TableEntry) ([]*TableEntry, []uint32) {
+	var entries []*TableEntry
+	var offsets []uint32
+	start := vlogHeaderSize + uint32(len(batch.family)+len(batch.key))
+	for position := 0; position != len(batch.value); {
+		length := entryLength(batch.value[position:])
+		entries = append(entries, decodeTableEntry(batch.value[position:position+length]))
+		offsets = append(offsets, start+uint32(position))
+		position += length
+	}
+	return entries, offsets
+}
+
 //Write entry to vlog
-//+------------+--------------+-----+-------+
-//| Key Length | Value length | Key | Value |
-//+------------+--------------+-----+-------+
+//+------------+--------------+------+-----------+-----------+---------------+--------+-----+-------+
+//| Key Length | Value length | Kind | Timestamp | ExpiresAt | Family Length | Family | Key | Value |
+//+------------+--------------+------+-----------+-----------+---------------+--------+-----+-------+
 func (entry *TableEntry) writeTo(writer io.Writer) (uint32, error) {
 	buffer := bytes.NewBuffer([]byte{})
 	//key length
@@ -98,6 +167,25 @@ func (entry *TableEntry) writeTo(writer io.Writer) (uint32, error) {
 	if err := binary.Write(buffer, binary.BigEndian, uint32(len(entry.value))); err != nil {
 		return 0, err
 	}
+	//kind
+	if err := buffer.WriteByte(entry.kind); err != nil {
+		return 0, err
+	}
+	//timestamp
+	if err := binary.Write(buffer, binary.BigEndian, entry.timestamp); err != nil {
+		return 0, err
+	}
+	//expiration
+	if err := binary.Write(buffer, binary.BigEndian, entry.expiresAt); err != nil {
+		return 0, err
+	}
+	//column family
+	if err := buffer.WriteByte(byte(len(entry.family))); err != nil {
+		return 0, err
+	}
+	if _, err := buffer.WriteString(entry.family); err != nil {
+		return 0, err
+	}
 	//key
 	if err := binary.Write(buffer, binary.BigEndian, entry.key); err != nil {
 		return 0, err
@@ -109,3 +197,116 @@ func (entry *TableEntry) writeTo(writer io.Writer) (uint32, error) {
 	length, err := writer.Write(buffer.Bytes())
 	return uint32(length), err
 }
+
+//Length of the entry in vlog
+func (entry *TableEntry) length() uint32 {
+	return vlogHeaderSize + uint32(len(entry.family)+len(entry.key)+len(entry.value))
+}
+
+//Decode vlog entry from the buffer that starts with the entry header
+func decodeTableEntry(buffer []byte) *TableEntry {
+	keyLength := binary.BigEndian.Uint32(buffer[0:4])
+	timestamp := binary.BigEndian.Uint64(buffer[9:17])
+	expiresAt := binary.BigEndian.Uint64(buffer[17:25])
+	familyEnd := vlogHeaderSize + uint32(buffer[vlogHeaderSize-1])
+	family := string(buffer[vlogHeaderSize:familyEnd])
+	key := buffer[familyEnd : familyEnd+keyLength]
+	value := buffer[familyEnd+keyLength:]
+	return &TableEntry{key: key, value: value, kind: buffer[8], timestamp: timestamp, expiresAt: expiresAt, family: family}
+}
+
+//Length of the vlog entry from its header
+func entryLength(header []byte) int {
+	keyLength := binary.BigEndian.Uint32(header[0:4])
+	valueLength := binary.BigEndian.Uint32(header[4:8])
+	return vlogHeaderSize + int(header[vlogHeaderSize-1]) + int(keyLength) + int(valueLength)
+}
+
+//check if the value is a tombstone
+func isTombstone(value []byte) bool {
+	return len(value) == len(tombstone) && bytes.Compare(value, []byte(tombstone)) == 0
+}
+
+//Encode merge operands to store them as a single vlog value
+//+-------+----------------+---------+-----+
+//| Count | Operand Length | Operand | ... |
+//+-------+----------------+---------+-----+
+func encodeOperands(operands [][]byte) []byte {
+	buffer := bytes.NewBuffer([]byte{})
+	_ = binary.Write(buffer, binary.BigEndian, uint32(len(operands)))
+	for _, operand := range operands {
+		_ = binary.Write(buffer, binary.BigEndian, uint32(len(operand)))
+		buffer.Write(operand)
+	}
+	return buffer.Bytes()
+}
+
+func decodeOperands(value []byte) [][]byte {
+	count := binary.BigEndian.Uint32(value[:uint32Size])
+	operands := make([][]byte, 0, count)
+	position := uint32Size
+	for i := uint32(0); i < count; i++ {
+		length := int(binary.BigEndian.Uint32(value[position : position+uint32Size]))
+		position += uint32Size
+		operands = append(operands, value[position:position+length])
+		position += length
+	}
+	return operands
+}
+
+//check if entry with given expiration time is expired
+func isExpired(expiresAt uint64) bool {
+	return expiresAt != 0 && expiresAt <= uint64(time.Now().UnixNano())
+}
+
+/// Range tombstone
+
+//range tombstone removes all keys in [start,end) that were written before it
+type rangeTombstone struct {
+	start     []byte //first deleted key, inclusive
+	end       []byte //last deleted key, exclusive
+	timestamp uint64 //when the range was deleted
+}
+
+func decodeRangeTombstone(entry *TableEntry) *rangeTombstone {
+	return &rangeTombstone{
+		start:     entry.key,
+		end:       entry.value[int64Size:],
+		timestamp: binary.BigEndian.Uint64(entry.value[:int64Size]),
+	}
+}
+
+//check if key is inside of the range
+func (tombstone *rangeTombstone) contains(key []byte, comparator Comparator) bool {
+	return comparator.Compare(key, tombstone.start) >= 0 && comparator.Compare(key, tombstone.end) < 0
+}
+
+//check if the entry with given key and timestamp was deleted by this tombstone
+func (tombstone *rangeTombstone) covers(key []byte, timestamp uint64, comparator Comparator) bool {
+	return timestamp < tombstone.timestamp && tombstone.contains(key, comparator)
+}
+
+//write range tombstone to the range tombstone block of sstable
+//+--------------+-------+------------+-----+-----------+
+//| Start Length | Start | End Length | End | timestamp |
+//+--------------+-------+------------+-----+-----------+
+func (tombstone *rangeTombstone) writeTo(writer io.Writer) (uint32, error) {
+	buffer := bytes.NewBuffer([]byte{})
+	if err := binary.Write(buffer, binary.BigEndian, uint32(len(tombstone.start))); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, tombstone.start); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, uint32(len(tombstone.end))); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, tombstone.end); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, tombstone.timestamp); err != nil {
+		return 0, err
+	}
+	length, err := writer.Write(buffer.Bytes())
+	return uint32(length), err
+}
@@ -0,0 +1,92 @@
+package wiskey
+
+import (
+	"os"
+	"time"
+)
+
+//Listener of background events, it's registered at open with Options.EventListeners
+//methods are called synchronously by the job that emits the event, flush and merge events
+//are emitted while the tree is locked so listeners have to be fast and must not write to the tree
+type EventListener interface {
+	OnFlushBegin(info FlushInfo)
+	OnFlushEnd(info FlushInfo)
+	OnCompactionBegin(info CompactionInfo)
+	OnCompactionEnd(info CompactionInfo)
+	OnTableCreated(info TableInfo)
+	OnTableDeleted(info TableInfo)
+	OnVlogGC(info VlogGCInfo)
+	OnBackgroundError(info BackgroundErrorInfo)
+}
+
+//Listener that ignores all events, it can be embedded to implement only some methods
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFlushBegin(info FlushInfo)                 {}
+func (NoopEventListener) OnFlushEnd(info FlushInfo)                   {}
+func (NoopEventListener) OnCompactionBegin(info CompactionInfo)       {}
+func (NoopEventListener) OnCompactionEnd(info CompactionInfo)         {}
+func (NoopEventListener) OnTableCreated(info TableInfo)               {}
+func (NoopEventListener) OnTableDeleted(info TableInfo)               {}
+func (NoopEventListener) OnVlogGC(info VlogGCInfo)                    {}
+func (NoopEventListener) OnBackgroundError(info BackgroundErrorInfo) {}
+
+//Sstable file
+type TableInfo struct {
+	Family string
+	Path   string
+	Size   int64
+	Reason string //flush or merge
+}
+
+//Flush of memtable, Table, Duration and Err are set only when flush ends
+type FlushInfo struct {
+	Family   string
+	Entries  int //amount of keys in memtable
+	Table    TableInfo
+	Duration time.Duration
+	Err      error
+}
+
+//Merge of sstables, Outputs, Duration and Err are set only when merge ends
+type CompactionInfo struct {
+	Family   string
+	Inputs   []TableInfo
+	Outputs  []TableInfo
+	Duration time.Duration
+	Err      error
+}
+
+//Garbage collection of vlog
+type VlogGCInfo struct {
+	Entries        int   //amount of entries that were checked
+	Relocated      int   //amount of live entries that were moved to the head of vlog
+	ReclaimedBytes int64 //size of the removed part of vlog minus relocated entries
+	VlogSize       int64 //size of vlog after gc
+	Duration       time.Duration
+	Err            error
+}
+
+//Error of background job
+type BackgroundErrorInfo struct {
+	Family    string
+	Operation string //background job that failed
+	Err       error
+	Fatal     bool //fatal error makes the tree read only, otherwise the job is retried
+}
+
+//Call all listeners
+func (lsm *LsmTree) notify(event func(listener EventListener)) {
+	for _, listener := range lsm.state.listeners {
+		event(listener)
+	}
+}
+
+//Describe sstable file, size is 0 if the file can't be read
+func (lsm *LsmTree) tableInfo(path string, reason string) TableInfo {
+	info := TableInfo{Family: lsm.family, Path: path, Reason: reason}
+	if stat, err := os.Stat(path); err == nil {
+		info.Size = stat.Size()
+	}
+	return info
+}
@@ -0,0 +1,123 @@
+package wiskey
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+//Listener that keeps events in memory
+type recordingListener struct {
+	NoopEventListener
+	mutex       sync.Mutex
+	flushes     []FlushInfo
+	compactions []CompactionInfo
+	created     []TableInfo
+	deleted     []TableInfo
+	gcs         []VlogGCInfo
+	errors      []BackgroundErrorInfo
+	begins      int
+}
+
+func (listener *recordingListener) OnFlushBegin(info FlushInfo) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	listener.begins++
+}
+
+func (listener *recordingListener) OnFlushEnd(info FlushInfo) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	listener.flushes = append(listener.flushes, info)
+}
+
+func (listener *recordingListener) OnCompactionBegin(info CompactionInfo) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	listener.begins++
+}
+
+func (listener *recordingListener) OnCompactionEnd(info CompactionInfo) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	listener.compactions = append(listener.compactions, info)
+}
+
+func (listener *recordingListener) OnTableCreated(info TableInfo) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	listener.created = append(listener.created, info)
+}
+
+func (listener *recordingListener) OnTableDeleted(info TableInfo) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	listener.deleted = append(listener.deleted, info)
+}
+
+func (listener *recordingListener) OnVlogGC(info VlogGCInfo) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	listener.gcs = append(listener.gcs, info)
+}
+
+func (listener *recordingListener) OnBackgroundError(info BackgroundErrorInfo) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	listener.errors = append(listener.errors, info)
+}
+
+func TestLsmTree_EventListener(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	listener := &recordingListener{}
+	options := DefaultOptions()
+	options.EventListeners = []EventListener{listener}
+	db, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range []string{"ANITA", "JOHN"} {
+		entry := NewEntry([]byte(key), []byte("DEVELOPER"))
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(listener.flushes) != 2 {
+		t.Fatalf("Expected 2 flushes, got %d", len(listener.flushes))
+	}
+	flush := listener.flushes[0]
+	if flush.Err != nil || flush.Entries != 1 || flush.Table.Path == "" || flush.Table.Size == 0 || flush.Table.Reason != "flush" {
+		t.Fatalf("Wrong flush info %+v", flush)
+	}
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if len(listener.compactions) != 1 {
+		t.Fatalf("Expected 1 compaction, got %d", len(listener.compactions))
+	}
+	compaction := listener.compactions[0]
+	if len(compaction.Inputs) != 2 || len(compaction.Outputs) != 1 || compaction.Err != nil {
+		t.Fatalf("Wrong compaction info %+v", compaction)
+	}
+	if compaction.Inputs[0].Path != listener.flushes[0].Table.Path || compaction.Inputs[0].Size == 0 {
+		t.Fatalf("Compaction input %+v is not the flushed table", compaction.Inputs[0])
+	}
+	if len(listener.created) != 3 || len(listener.deleted) != 2 {
+		t.Fatalf("Expected 3 created and 2 deleted tables, got %d and %d", len(listener.created), len(listener.deleted))
+	}
+	if listener.begins != 3 {
+		t.Fatalf("Expected 3 begin events, got %d", listener.begins)
+	}
+	if err := db.CompressVlog(); err != nil {
+		t.Fatal(err)
+	}
+	if len(listener.gcs) != 1 || listener.gcs[0].Entries != 2 || listener.gcs[0].Relocated != 2 || listener.gcs[0].Err != nil {
+		t.Fatalf("Wrong vlog gc info %+v", listener.gcs)
+	}
+}
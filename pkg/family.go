@@ -0,0 +1,85 @@
+package wiskey
+
+import (
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"sort"
+)
+
+const (
+	familyCheckpoint = "CHECKPOINT" //file with vlog head of column family in its sstable directory
+)
+
+var (
+	familyNamePattern = regexp.MustCompile("^[a-zA-Z0-9_-]{1,255}$")
+	ErrInvalidFamily  = errors.New("column family name can contain only letters, digits, '_' and '-'")
+)
+
+//Open column family or create it if it doesn't exist
+//column family is an isolated key space with its own memtable and sstables
+//that are stored in a sub directory of the default sstable directory
+//all column families share the same vlog so writes to different families are ordered in the same log
+//memtableSize and gc are the memtable size and merge interval in seconds of this family
+func (lsm *LsmTree) OpenColumnFamily(name string, memtableSize int, gc uint) (*LsmTree, error) {
+	if !familyNamePattern.MatchString(name) {
+		return nil, ErrInvalidFamily
+	}
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	if family, opened := lsm.families[name]; opened {
+		return family, nil
+	}
+	root := lsm.families[""]
+	sstableDir := root.sstableDir + "/" + name
+	family := &LsmTree{
+		rwm:           root.rwm,
+		log:           root.log,
+		sstableDir:    sstableDir,
+		memtable:      NewMemTableWithComparator(memtableSize, root.comparator),
+		deleted:       make(map[string]bool),
+		mergeOperator: root.mergeOperator,
+		family:        name,
+		families:      root.families,
+		checkpoint:    sstableDir + "/" + familyCheckpoint,
+		comparator:    root.comparator,
+		state:         root.state,
+		metrics:       newMetrics(),
+	}
+	err := family.open(gc)
+	if err != nil {
+		return nil, err
+	}
+	lsm.families[name] = family
+	return family, nil
+}
+
+//Find opened column family by its name, empty name is the default family
+func (lsm *LsmTree) ColumnFamily(name string) (*LsmTree, bool) {
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	family, opened := lsm.families[name]
+	return family, opened
+}
+
+//Names of all column families that exist on disk, they don't have to be opened
+func (lsm *LsmTree) ColumnFamilies() ([]string, error) {
+	root, _ := lsm.ColumnFamily("")
+	files, err := ioutil.ReadDir(root.sstableDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.IsDir() && familyNamePattern.MatchString(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+//Name of column family, empty for the default one
+func (lsm *LsmTree) Name() string {
+	return lsm.family
+}
@@ -0,0 +1,122 @@
+package wiskey
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLsmTree_OpenColumnFamily(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	if _, err := tree.OpenColumnFamily("../users", 100, 30); err != ErrInvalidFamily {
+		t.Fatal("Invalid column family name was accepted")
+	}
+	users, err := tree.OpenColumnFamily("users", 100, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("ANITA")
+	first := NewEntry(key, []byte("DEFAULT"))
+	second := NewEntry(key, []byte("USERS"))
+	if err := tree.Put(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Put(&second); err != nil {
+		t.Fatal(err)
+	}
+	//only users family is flushed, default one has to be restored from vlog
+	if err := users.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	check := func(tree *LsmTree, users *LsmTree) {
+		value, _ := mustGet(t, tree, key)
+		if string(value) != "DEFAULT" {
+			t.Fatalf("Default family has wrong value %s", value)
+		}
+		value, _ = mustGet(t, users, key)
+		if string(value) != "USERS" {
+			t.Fatalf("Users family has wrong value %s", value)
+		}
+	}
+	check(tree, users)
+	restored := openReadOnly(tree, 100)
+	//sstables of column family are not visible in default family
+	if len(restored.sstables) != 0 {
+		t.Fatal("Default family has sstables of another family")
+	}
+	restoredUsers, err := restored.OpenColumnFamily("users", 100, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(restored, restoredUsers)
+	names, err := restored.ColumnFamilies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "users" {
+		t.Fatalf("Wrong column families %v", names)
+	}
+}
+
+func TestTransaction_ColumnFamilies(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	users, err := tree.OpenColumnFamily("users", 100, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := tree.Begin()
+	_ = tx.Put([]byte("ANITA"), []byte("DEFAULT"))
+	_ = tx.Family(users).Put([]byte("ANITA"), []byte("USERS"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	value, _ := mustGet(t, tree, []byte("ANITA"))
+	if string(value) != "DEFAULT" {
+		t.Fatalf("Default family has wrong value %s", value)
+	}
+	value, _ = mustGet(t, users, []byte("ANITA"))
+	if string(value) != "USERS" {
+		t.Fatalf("Users family has wrong value %s", value)
+	}
+}
+
+func TestLsmTree_GcKeepsUnopenedFamily(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	users, err := tree.OpenColumnFamily("users", 100, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("ANITA")
+	entry := NewEntry(key, []byte("USERS"))
+	if err := users.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//users family exists on disk but it's not opened during gc
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(100), 30)
+	if err := tree.CompressVlogEntries(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(100), 30)
+	defer tree.Close()
+	users, err = tree.OpenColumnFamily("users", 100, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := mustGet(t, users, key); string(value) != "USERS" {
+		t.Fatalf("Value of unopened family was lost by gc, value %s", value)
+	}
+}
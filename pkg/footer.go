@@ -8,12 +8,13 @@ import (
 )
 
 const (
-	footerSize = 4 //how many bytes are in the footer(indexOffset)
+	footerSize = 8 //how many bytes are in the footer(indexOffset + rangeTombstoneOffset)
 )
 
-//footer in the sstable file, it shows where the index starts in the file
+//footer in the sstable file, it shows where the index and range tombstones start in the file
 type Footer struct {
-	indexOffset uint32 // the Offset where indexes starts
+	indexOffset          uint32 // the Offset where indexes starts
+	rangeTombstoneOffset uint32 // the Offset where range tombstones start
 }
 
 func DefaultFooter() *Footer {
@@ -26,8 +27,9 @@ func NewFooter(buffer []byte) *Footer {
 	if len(buffer) != footerSize {
 		panic("Invalid header length")
 	}
-	offset := binary.BigEndian.Uint32(buffer[:footerSize])
-	return &Footer{indexOffset: offset}
+	offset := binary.BigEndian.Uint32(buffer[:uint32Size])
+	rangeTombstoneOffset := binary.BigEndian.Uint32(buffer[uint32Size:footerSize])
+	return &Footer{indexOffset: offset, rangeTombstoneOffset: rangeTombstoneOffset}
 }
 
 //save the header in the given writeCloser
@@ -49,7 +51,6 @@ func (h *Footer) asByteArray() []byte {
 	return buffer.Bytes()
 }
 
-
 //Read the footer
 func readFooter(stats os.FileInfo, reader *os.File) *Footer {
 	buf := make([]byte, footerSize)
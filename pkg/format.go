@@ -0,0 +1,89 @@
+package wiskey
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatFile = "FORMAT" //file in sstable directory with the version of vlog and sstable format
+	//version 1 is the format without range tombstones, ttl, merge operands and column families,
+	//its vlog entries and sstable footers can't be read by this version so such stores are upgraded on open
+	formatVersion = 2
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("store was written in unsupported format")
+)
+
+//Save format version in a new store or check that existing store has the supported format
+//stores without the version were written before it was saved and have format 1, they are upgraded
+//read only tree doesn't save the version and can't upgrade the store
+func (lsm *LsmTree) checkFormat() error {
+	dir, log := lsm.sstableDir, lsm.log
+	path := dir + "/" + formatFile
+	saved, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		legacy, err := isLegacyStore(dir, log)
+		if err != nil {
+			return err
+		}
+		if legacy {
+			if lsm.state.readOnly {
+				return fmt.Errorf("%w: version 1 is upgraded when the store is opened without read only mode", ErrUnsupportedFormat)
+			}
+			start := time.Now()
+			keys, err := upgradeStore(dir, log)
+			if err != nil {
+				return fmt.Errorf("can't upgrade store from version 1: %w", err)
+			}
+			lsm.state.logger.Info("store upgraded", "from", 1, "to", formatVersion, "keys", keys, "duration", time.Since(start))
+			return nil
+		}
+		empty, err := isEmptyStore(dir, log)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("%w: store has data but no format version", ErrUnsupportedFormat)
+		}
+		if lsm.state.readOnly {
+			return nil
+		}
+		return ioutil.WriteFile(path, []byte(strconv.Itoa(formatVersion)), 0666)
+	}
+	if err != nil {
+		return err
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(saved)))
+	if err != nil {
+		return fmt.Errorf("corrupted format file %s: %w", path, err)
+	}
+	if version != formatVersion {
+		return fmt.Errorf("%w: version %d, supported %d", ErrUnsupportedFormat, version, formatVersion)
+	}
+	return nil
+}
+
+//Store is empty if vlog and sstable directory have no data
+func isEmptyStore(dir string, log *vlog) (bool, error) {
+	if log.size != 0 {
+		return false, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, file := range files {
+		if matched, _ := regexp.MatchString(sstableExtension, file.Name()); matched && !file.IsDir() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,160 @@
+package wiskey
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpen_Format(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	if err := db.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := dir + "/" + sstableDirName + "/" + formatFile
+	//store without the version was written by the first version
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, nil); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("Store without format version was opened, error %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("3"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, nil); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("Store with newer format version was opened, error %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("2"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, found := mustGet(t, db.LsmTree, entry.key); !found || string(value) != "DEVELOPER" {
+		t.Fatalf("Value wasn't read after reopen, found %v", found)
+	}
+}
+
+//Entry of format 1 vlog
+func legacyVlogEntry(key string, value string) []byte {
+	buffer := make([]byte, legacyHeaderSize, legacyHeaderSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(buffer, uint32(len(key)))
+	binary.BigEndian.PutUint32(buffer[uint32Size:], uint32(len(value)))
+	return append(append(buffer, key...), value...)
+}
+
+//Sstable of format 1 with entries that point to vlog entries, keys have to be sorted
+func legacySStable(keys []string, timestamp uint64, offsets []int, vlog [][]byte) []byte {
+	var buffer bytes.Buffer
+	for i, key := range keys {
+		binary.Write(&buffer, binary.BigEndian, uint32(len(key)))
+		buffer.WriteString(key)
+		binary.Write(&buffer, binary.BigEndian, timestamp)
+		binary.Write(&buffer, binary.BigEndian, uint32(offsets[i]))
+		binary.Write(&buffer, binary.BigEndian, uint32(len(vlog[i])))
+	}
+	indexOffset := uint32(buffer.Len())
+	binary.Write(&buffer, binary.BigEndian, indexOffset)
+	binary.Write(&buffer, binary.BigEndian, uint32(0))
+	binary.Write(&buffer, binary.BigEndian, indexOffset)
+	return buffer.Bytes()
+}
+
+func TestOpenPaths_UpgradeFormat1(t *testing.T) {
+	for _, interrupted := range []bool{false, true} {
+		dir, _ := ioutil.TempDir("", "")
+		defer os.RemoveAll(dir)
+		sstableDir, vlogFile, checkpoint := dir+"/sstables", dir+"/vlog", dir+"/checkpoint"
+		if err := os.Mkdir(sstableDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		entries := [][]byte{
+			legacyVlogEntry("anita", "Developer"),
+			legacyVlogEntry("bob", "Tester"),
+			legacyVlogEntry("anita", "Manager"),
+			legacyVlogEntry("carl", "Designer"),
+			//entries after the checkpoint were in memtable
+			legacyVlogEntry("carl", tombstone),
+			legacyVlogEntry("dave", "Writer"),
+			legacyVlogEntry("bob", "Lead"),
+		}
+		var vlog []byte
+		var offsets []int
+		for _, entry := range entries {
+			offsets = append(offsets, len(vlog))
+			vlog = append(vlog, entry...)
+		}
+		files := map[string][]byte{
+			vlogFile:   vlog,
+			checkpoint: {0, 0, 0, byte(offsets[4])},
+			//the newer sstable goes first in the directory
+			sstableDir + "/aaaaaaaaaa.sstable": legacySStable([]string{"anita", "carl"}, 200, offsets[2:4], entries[2:4]),
+			sstableDir + "/bbbbbbbbbb.sstable": legacySStable([]string{"anita", "bob"}, 100, offsets[0:2], entries[0:2]),
+		}
+		for path, content := range files {
+			if err := ioutil.WriteFile(path, content, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := OpenPaths(sstableDir, vlogFile, checkpoint, &Options{MemtableSize: 20, MergeInterval: 120, Comparator: BytewiseComparator{}, ReadOnly: true}); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Fatalf("Read only tree opened store of format 1, error %v", err)
+		}
+		if interrupted {
+			//upgrade stopped after the vlog and one sstable were renamed
+			if err := os.Rename(vlogFile, vlogFile+legacySuffix); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(sstableDir+"/aaaaaaaaaa.sstable", sstableDir+"/aaaaaaaaaa.sstable"+legacySuffix); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db, err := OpenPaths(sstableDir, vlogFile, checkpoint, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{"anita": "Manager", "bob": "Lead", "carl": "", "dave": "Writer"}
+		check := func() {
+			for key, value := range expected {
+				saved, found := mustGet(t, db.LsmTree, []byte(key))
+				if found != (value != "") || string(saved) != value {
+					t.Fatalf("Key %s has value %q, found %v, expected %q, interrupted %v", key, saved, found, value, interrupted)
+				}
+			}
+		}
+		check()
+		for _, path := range []string{vlogFile + legacySuffix, checkpoint + legacySuffix, sstableDir + "/aaaaaaaaaa.sstable" + legacySuffix} {
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("File %s of format 1 wasn't removed, error %v", path, err)
+			}
+		}
+		entry := NewEntry([]byte("erin"), []byte("Analyst"))
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		expected["erin"] = "Analyst"
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		db, err = OpenPaths(sstableDir, vlogFile, checkpoint, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check()
+		db.Close()
+	}
+}
@@ -6,6 +6,10 @@ import (
 	"io"
 )
 
+const (
+	indexSize = uint32Size * 2 //block length + offset
+)
+
 //indexes to find an entry in a file
 type tableIndex struct {
 	Offset      uint32 //Offset of the file where index starts
@@ -16,7 +20,7 @@ type tableIndex struct {
 //+-------------+--------+
 //| BlockLength | Offset |
 //+-------------+--------+
-func (index *tableIndex) WriteTo(w io.Writer) error {
+func (index *tableIndex) writeTo(w io.Writer) error {
 	buf := bytes.NewBuffer([]byte{})
 
 	if err := binary.Write(buf, binary.BigEndian, index.BlockLength); err != nil {
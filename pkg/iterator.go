@@ -0,0 +1,126 @@
+package wiskey
+
+import (
+	"os"
+	"sort"
+)
+
+const (
+	iteratorBatch = 1024 //keys that iterator loads from memtable and every sstable at once
+)
+
+//Iterator over the live keys of lsm tree in sorted order
+//deleted keys (including range deleted) are skipped
+//keys are loaded in batches so the memory doesn't depend on the size of the range
+type Iterator struct {
+	lsm   *LsmTree
+	keys  [][]byte //loaded keys that weren't checked yet
+	from  []byte   //the next batch starts from this key
+	after bool     //from was already loaded so the next batch starts after it
+	end   []byte
+	done  bool //all keys of the range were loaded
+	key   []byte
+	value []byte
+	err   error //the error that stopped the iteration
+}
+
+//Create iterator over keys in [start,end), empty start means there is no lower bound and nil end means there is no upper bound
+func (lsm *LsmTree) NewIterator(start []byte, end []byte) *Iterator {
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	iterator := &Iterator{lsm: lsm, from: start, end: end}
+	iterator.err = iterator.load()
+	return iterator
+}
+
+//Load the next batch of keys, caller has to hold the lock
+//a source that has more keys than the batch can have keys between the keys of other sources,
+//so only keys up to the smallest last key of full batches are taken and the rest is loaded later
+func (iterator *Iterator) load() error {
+	lsm := iterator.lsm
+	batches := [][][]byte{lsm.memtable.keys(iterator.from, iterator.end, iteratorBatch)}
+	for _, tablePath := range lsm.sstables {
+		reader, err := os.Open(tablePath)
+		if err != nil {
+			return err
+		}
+		sstable := ReadTable(reader, lsm.log, lsm.comparator)
+		batches = append(batches, sstable.keys(iterator.from, iterator.end, iteratorBatch))
+		sstable.Close()
+	}
+	var last []byte
+	for _, batch := range batches {
+		if len(batch) == iteratorBatch && (last == nil || lsm.comparator.Compare(batch[len(batch)-1], last) < 0) {
+			last = batch[len(batch)-1]
+		}
+	}
+	unique := make(map[string]bool)
+	for _, batch := range batches {
+		for _, key := range batch {
+			if iterator.after && lsm.comparator.Compare(key, iterator.from) == 0 {
+				continue
+			}
+			if last != nil && lsm.comparator.Compare(key, last) > 0 {
+				break
+			}
+			unique[string(key)] = true
+		}
+	}
+	keys := make([][]byte, 0, len(unique))
+	for key := range unique {
+		keys = append(keys, []byte(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lsm.comparator.Compare(keys[i], keys[j]) < 0
+	})
+	iterator.keys = keys
+	iterator.from = last
+	iterator.after = true
+	iterator.done = last == nil
+	return nil
+}
+
+//Move to the next live key, returns false when there are no keys left or the iteration failed
+func (iterator *Iterator) Next() bool {
+	if iterator.err != nil {
+		return false
+	}
+	iterator.lsm.rwm.RLock()
+	defer iterator.lsm.rwm.RUnlock()
+	for {
+		for len(iterator.keys) != 0 {
+			key := iterator.keys[0]
+			iterator.keys = iterator.keys[1:]
+			value, _, found, err := iterator.lsm.getWithVersion(key)
+			if err != nil {
+				iterator.err = err
+				return false
+			}
+			if found {
+				iterator.key = key
+				iterator.value = value
+				return true
+			}
+		}
+		if iterator.done {
+			return false
+		}
+		if err := iterator.load(); err != nil {
+			iterator.err = err
+			return false
+		}
+	}
+}
+
+func (iterator *Iterator) Key() []byte {
+	return iterator.key
+}
+
+func (iterator *Iterator) Value() []byte {
+	return iterator.value
+}
+
+//Error that stopped the iteration, nil if all keys were read
+func (iterator *Iterator) Err() error {
+	return iterator.err
+}
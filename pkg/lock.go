@@ -0,0 +1,52 @@
+package wiskey
+
+import (
+	"errors"
+	"os"
+)
+
+const (
+	lockFileName   = "LOCK"  //file in sstable directory that is locked by the process that writes to the store
+	vlogLockSuffix = ".lock" //file next to the vlog that is locked too, stores with different sstable directories can have the same vlog
+)
+
+var (
+	ErrLocked = errors.New("store is already opened by another process")
+)
+
+//Exclusive lock of the store, only one process can write to the vlog and sstables
+//the lock is released by the OS if the process dies
+type storeLock struct {
+	files []*os.File
+}
+
+//Take the locks of the sstable directory and the vlog or return ErrLocked if any of them is taken by someone else
+func lockStore(sstableDir string, vlogFile string) (*storeLock, error) {
+	lock := &storeLock{}
+	for _, path := range []string{sstableDir + "/" + lockFileName, vlogFile + vlogLockSuffix} {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+		if err != nil {
+			lock.release()
+			return nil, err
+		}
+		err = flock(file)
+		if err != nil {
+			file.Close()
+			lock.release()
+			return nil, err
+		}
+		lock.files = append(lock.files, file)
+	}
+	return lock, nil
+}
+
+//closing the files releases the lock
+func (lock *storeLock) release() error {
+	var result error
+	for _, file := range lock.files {
+		if err := file.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
@@ -0,0 +1,41 @@
+//go:build windows
+// +build windows
+
+package wiskey
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+	errorLockViolation      = syscall.Errno(33) //the region is locked by another process
+)
+
+var (
+	procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+)
+
+//Exclusive lock of the first byte of the file, it's released when the file is closed
+func flock(file *os.File) error {
+	var overlapped syscall.Overlapped
+	result, _, err := procLockFileEx.Call(
+		file.Fd(),
+		uintptr(lockfileExclusiveLock|lockfileFailImmediately),
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if result != 0 {
+		return nil
+	}
+	if errors.Is(err, errorLockViolation) {
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package wiskey
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Severity of log message
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+//Parse level name, it's case insensitive
+func ParseLogLevel(name string) (LogLevel, error) {
+	for _, level := range []LogLevel{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(level.String(), name) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s", name)
+}
+
+//Leveled logger with structured fields
+//fields are key value pairs, for example logger.Info("flush finished", "file", path, "duration", duration)
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+//Logger that writes one line of key=value pairs per message
+//time=2021-06-01T10:00:00.000Z level=INFO msg="flush finished" family=users duration=1.2ms
+type textLogger struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	level  LogLevel
+}
+
+//Logger that writes messages with given level and above as text lines
+func NewTextLogger(writer io.Writer, level LogLevel) Logger {
+	return &textLogger{writer: writer, level: level}
+}
+
+func (logger *textLogger) Debug(msg string, fields ...interface{}) {
+	logger.log(LevelDebug, msg, fields)
+}
+
+func (logger *textLogger) Info(msg string, fields ...interface{}) {
+	logger.log(LevelInfo, msg, fields)
+}
+
+func (logger *textLogger) Warn(msg string, fields ...interface{}) {
+	logger.log(LevelWarn, msg, fields)
+}
+
+func (logger *textLogger) Error(msg string, fields ...interface{}) {
+	logger.log(LevelError, msg, fields)
+}
+
+func (logger *textLogger) log(level LogLevel, msg string, fields []interface{}) {
+	if level < logger.level {
+		return
+	}
+	var line strings.Builder
+	line.WriteString("time=")
+	line.WriteString(time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	line.WriteString(" level=")
+	line.WriteString(level.String())
+	line.WriteString(" msg=")
+	line.WriteString(quoteIfNeeded(msg))
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		value := "MISSING"
+		if i+1 < len(fields) {
+			value = fmt.Sprint(fields[i+1])
+		}
+		line.WriteString(" ")
+		line.WriteString(quoteIfNeeded(key))
+		line.WriteString("=")
+		line.WriteString(quoteIfNeeded(value))
+	}
+	line.WriteString("\n")
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	_, _ = io.WriteString(logger.writer, line.String())
+}
+
+func quoteIfNeeded(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\n\t") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+//Logger that drops all messages
+type discardLogger struct{}
+
+func (discardLogger) Debug(msg string, fields ...interface{}) {}
+func (discardLogger) Info(msg string, fields ...interface{})  {}
+func (discardLogger) Warn(msg string, fields ...interface{})  {}
+func (discardLogger) Error(msg string, fields ...interface{}) {}
+
+//Logger that drops all messages
+func NopLogger() Logger {
+	return discardLogger{}
+}
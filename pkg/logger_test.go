@@ -0,0 +1,99 @@
+package wiskey
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTextLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewTextLogger(&buffer, LevelInfo)
+	logger.Debug("hidden")
+	logger.Info("flush finished", "file", "a b.sstable", "entries", 3)
+	line := buffer.String()
+	if strings.Contains(line, "hidden") {
+		t.Fatal("Debug message was written with info level")
+	}
+	for _, part := range []string{"level=INFO", `msg="flush finished"`, `file="a b.sstable"`, "entries=3"} {
+		if !strings.Contains(line, part) {
+			t.Fatalf("Line %s doesn't contain %s", line, part)
+		}
+	}
+	if level, err := ParseLogLevel("warn"); err != nil || level != LevelWarn {
+		t.Fatalf("Wrong level %v error %v", level, err)
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Fatal("Unknown level was parsed")
+	}
+}
+
+//Logger that keeps messages in memory
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (logger *recordingLogger) record(msg string, fields []interface{}) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	var line bytes.Buffer
+	NewTextLogger(&line, LevelDebug).Info(msg, fields...)
+	logger.messages = append(logger.messages, line.String())
+}
+
+func (logger *recordingLogger) Debug(msg string, fields ...interface{}) { logger.record(msg, fields) }
+func (logger *recordingLogger) Info(msg string, fields ...interface{})  { logger.record(msg, fields) }
+func (logger *recordingLogger) Warn(msg string, fields ...interface{})  { logger.record(msg, fields) }
+func (logger *recordingLogger) Error(msg string, fields ...interface{}) { logger.record(msg, fields) }
+
+func (logger *recordingLogger) find(msg string) string {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	for _, message := range logger.messages {
+		if strings.Contains(message, `msg="`+msg+`"`) {
+			return message
+		}
+	}
+	return ""
+}
+
+func TestLsmTree_Logger(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	logger := &recordingLogger{}
+	options := DefaultOptions()
+	options.Logger = logger
+	db, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.find("memtable restored from vlog") == "" {
+		t.Fatal("Recovery wasn't logged")
+	}
+	for i := 0; i < 2; i++ {
+		entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+		if err := db.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush := logger.find("flush finished")
+	if !strings.Contains(flush, ".sstable") || !strings.Contains(flush, "duration=") {
+		t.Fatalf("Flush wasn't logged with file and duration %s", flush)
+	}
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.find("merge finished") == "" {
+		t.Fatal("Merge wasn't logged")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,62 +1,283 @@
 package wiskey
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
 type LsmTree struct {
-	rwm        sync.RWMutex
+	rwm        *sync.RWMutex //shared by all column families
 	gcMutex    sync.RWMutex
-	sstableDir string    //directory with sstables
-	log        *vlog     //vlog
-	memtable   *Memtable //in memory table
-	sstables   []string  //list of created sstables,let's change it to set to speed up the search
+	sstableDir string     //directory with sstables
+	log        *vlog      //vlog
+	memtable   *Memtable  //in memory table
+	sstables   []string   //list of created sstables,let's change it to set to speed up the search
+	lastOrder  tableOrder //the largest sequence and generation of sstables
 	deleted    map[string]bool
+	//range tombstones from all sstables, they are kept in memory to not read them on every Get
+	rangeTombstones []*rangeTombstone
+	mergeOperator   MergeOperator
+	mergeOperands   bool                //merge operands were written to this column family
+	family          string              //name of column family, empty for the default one
+	families        map[string]*LsmTree //all opened column families including the default one
+	checkpoint      string              //path to the file with vlog head of this column family
+	comparator      Comparator          //order of keys, the same as in memtable
+	state           *treeState          //shared by all column families
+	//size of sstables that the next merge rewrites
+	pendingCompactionBytes int64
+	nextMerge              time.Time //time of the next scheduled merge
+	metrics                *metrics
+	watchers               map[*Watcher]bool
 }
 
+//State of the tree that is shared by all column families
+type treeState struct {
+	stop     chan struct{}  //closed when background jobs have to stop
+	jobs     sync.WaitGroup //running background jobs
+	closed   bool
+	readOnly bool       //read only tree doesn't take the lock and rejects writes
+	lock     *storeLock //exclusive lock of the store, nil for read only tree
+	//triggers of write stalls
+	stallOptions WriteStallOptions
+	limiter      *rateLimiter //limits flush and merge writes, nil if they are not limited
+	logger       Logger
+	listeners    []EventListener
+	background   BackgroundStats //state of background jobs, fatal error makes the tree read only
+	ready        bool            //all column families are opened and restored
+}
+
+const (
+	NoVersion = uint64(0) //version of the key that doesn't exist
+)
+
+var (
+	ErrVersionMismatch = errors.New("current version of the key doesn't match expected version")
+	ErrConflict        = errors.New("transaction conflicts with another write")
+	ErrTxnClosed       = errors.New("transaction was already committed or rolled back")
+	ErrClosed          = errors.New("lsm tree is closed")
+	ErrReadOnly        = errors.New("lsm tree is opened in read only mode")
+	ErrInvalidRange    = errors.New("start of the range has to be smaller than the end")
+)
+
 func NewLsmTree(log *vlog, sstableDir string, memtable *Memtable, gc uint) *LsmTree {
+	lsm := newLsmTree(log, sstableDir, memtable)
+	err := lsm.open(gc)
+	if err != nil {
+		lsm.state.logger.Error("can't open lsm tree", "dir", sstableDir, "error", err)
+		panic(err)
+	}
+	lsm.state.ready = true
+	return lsm
+}
+
+func newLsmTree(log *vlog, sstableDir string, memtable *Memtable) *LsmTree {
 	lsm := &LsmTree{
+		rwm:        &sync.RWMutex{},
 		log:        log,
 		sstableDir: sstableDir,
 		memtable:   memtable,
 		deleted:    make(map[string]bool),
+		families:   make(map[string]*LsmTree),
+		checkpoint: log.checkpoint,
+		comparator: memtable.comparator,
+		state:      &treeState{stop: make(chan struct{}), logger: NewTextLogger(os.Stderr, LevelInfo), background: BackgroundStats{Since: time.Now()}},
+		metrics:    newMetrics(),
 	}
-	//create sstable path if doesn't exist
-	if _, err := os.Stat(sstableDir); os.IsNotExist(err) {
-		err := os.Mkdir(sstableDir, os.ModeDir|0755)
+	lsm.families[lsm.family] = lsm
+	return lsm
+}
+
+//Load sstables, restore memtable from vlog and start merge job
+func (lsm *LsmTree) open(gc uint) error {
+	//create sstable path if doesn't exist, read only tree never creates files
+	if _, err := os.Stat(lsm.sstableDir); os.IsNotExist(err) {
+		if lsm.state.readOnly {
+			return err
+		}
+		err := os.Mkdir(lsm.sstableDir, os.ModeDir|0755)
 		if err != nil {
-			panic(err)
+			return err
+		}
+	}
+	//column families are in sub directories of the default one and share its vlog so its lock covers them too
+	if lsm.family == "" && !lsm.state.readOnly {
+		lock, err := lockStore(lsm.sstableDir, lsm.log.file)
+		if err != nil {
+			return err
 		}
+		lsm.state.lock = lock
 	}
-	lsm.fillSstables()
-	err := lsm.restore()
+	err := lsm.load()
 	if err != nil {
-		fmt.Print(err.Error())
-		panic(err)
+		if lsm.family == "" && lsm.state.lock != nil {
+			lsm.state.lock.release()
+			lsm.state.lock = nil
+		}
+		return err
 	}
-	//run job to periodically merge sstables
+	lsm.updateCompactionDebt()
+	lsm.state.logger.Info("column family opened", "family", lsm.family, "dir", lsm.sstableDir, "sstables", len(lsm.sstables), "read_only", lsm.state.readOnly)
+	if lsm.state.readOnly {
+		return nil
+	}
+	//run job to periodically merge sstables until the tree is closed
+	//failed merge is retried with backoff, fatal error stops the job and makes the tree read only
+	lsm.state.jobs.Add(1)
 	go func(tree *LsmTree, gc uint) {
-		fmt.Println("Gc thread was initialized")
+		defer tree.state.jobs.Done()
+		delay := time.Duration(gc) * time.Second
 		for true {
-			time.Sleep(time.Duration(gc) * time.Second)
-			fmt.Println("SSTABLE GC started")
-			err := lsm.Merge()
-			if err != nil {
-				fmt.Println("Gc encountered an error " + err.Error() + " Stop gc thread")
+			tree.rwm.Lock()
+			tree.nextMerge = time.Now().Add(delay)
+			tree.rwm.Unlock()
+			select {
+			case <-tree.state.stop:
+				return
+			case <-time.After(delay):
+			}
+			err := tree.Merge()
+			if errors.Is(err, ErrClosed) || errors.Is(err, ErrReadOnly) {
 				return
 			}
+			delay = time.Duration(gc) * time.Second
+			//the next merge waits for the tokens that this one took while it held the lock
+			if debt := tree.state.limiter.take(0); debt > delay {
+				delay = debt
+			}
+			tree.rwm.Lock()
+			if err != nil {
+				retry, retryable := tree.backgroundError("merge", err)
+				if !retryable {
+					tree.rwm.Unlock()
+					return
+				}
+				delay = retry
+			} else {
+				tree.backgroundRecovered("merge")
+			}
+			tree.rwm.Unlock()
 		}
 	}(lsm, gc)
-	return lsm
+	return nil
+}
+
+//Read sstables and restore memtable
+func (lsm *LsmTree) load() error {
+	//column families share the vlog and are written by the same version so the format is saved once
+	if lsm.family == "" {
+		err := lsm.checkFormat()
+		if err != nil {
+			return err
+		}
+	}
+	//sstables sorted by one comparator can't be read with another one
+	err := checkComparator(lsm.sstableDir, lsm.comparator, lsm.state.readOnly)
+	if err != nil {
+		return err
+	}
+	//merge operands can't be read without the operator
+	lsm.mergeOperands, err = checkMergeOperator(lsm.sstableDir, lsm.mergeOperator)
+	if err != nil {
+		return err
+	}
+	err = lsm.fillSstables()
+	if err != nil {
+		return err
+	}
+	return lsm.restore()
+}
+
+//Stop background jobs, flush memtables of all column families, sync vlog and save checkpoints
+//column families share the vlog so closing any of them closes all of them
+//writes after close return ErrClosed, the lock of the store is released
+func (lsm *LsmTree) Close() error {
+	lsm.rwm.Lock()
+	if lsm.state.closed {
+		lsm.rwm.Unlock()
+		return nil
+	}
+	lsm.state.closed = true
+	close(lsm.state.stop)
+	lsm.rwm.Unlock()
+	//merge job takes the lock so wait for it without holding the lock
+	lsm.state.jobs.Wait()
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	for _, family := range lsm.families {
+		for watcher := range family.watchers {
+			watcher.stop(ErrClosed)
+		}
+	}
+	if lsm.state.readOnly {
+		return nil
+	}
+	defer lsm.state.lock.release()
+	if lsm.state.background.State == BackgroundFailed {
+		//nothing is written after fatal error, memtables are restored from vlog on the next open
+		lsm.state.logger.Warn("lsm tree closed without flush after background error", "operation", lsm.state.background.Operation)
+		return nil
+	}
+	for _, family := range lsm.families {
+		if !family.memtable.isEmpty() {
+			err := family.flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := lsm.log.sync()
+	if err != nil {
+		return err
+	}
+	//checkpoint of every family points to the end of synced vlog
+	//so nothing is replayed on the next open
+	for _, family := range lsm.families {
+		err := lsm.log.FlushHead(family.checkpoint)
+		if err != nil {
+			return err
+		}
+	}
+	lsm.state.logger.Info("lsm tree closed", "vlog_size", lsm.log.fileSize())
+	return nil
+}
+
+//Check if all column families are restored and the tree is not closed
+func (lsm *LsmTree) Ready() bool {
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	return lsm.state.ready && !lsm.state.closed
+}
+
+//Logger of the tree, it's shared by all column families
+func (lsm *LsmTree) Logger() Logger {
+	return lsm.state.logger
+}
+
+//Check if the tree was opened in read only mode or became read only after fatal background error
+func (lsm *LsmTree) ReadOnly() bool {
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	return lsm.state.readOnly || lsm.state.background.State == BackgroundFailed
+}
+
+//Check that the tree accepts writes, caller has to hold the lock
+func (lsm *LsmTree) writable() error {
+	if lsm.state.closed {
+		return ErrClosed
+	}
+	if lsm.state.readOnly {
+		return ErrReadOnly
+	}
+	return lsm.backgroundFailure()
 }
 
 type TableWithIndex struct {
@@ -67,8 +288,29 @@ type TableWithIndex struct {
 
 func (lsm *LsmTree) CompressVlog() error {
 	//TODO: hard coded value, let's make it configurable
-	size := 2
-	return lsm.log.RunGc(size, lsm)
+	return lsm.CompressVlogEntries(2)
+}
+
+//Run vlog gc for the given amount of entries from the tail of vlog
+//gc flushes memtables of all column families and rewrites offsets in sstables so it holds the write lock
+func (lsm *LsmTree) CompressVlogEntries(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("%w: amount of vlog gc entries has to be positive", ErrInvalidOptions)
+	}
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	if err := lsm.writable(); err != nil {
+		return err
+	}
+	start := time.Now()
+	garbage := lsm.log.garbage
+	err := lsm.log.RunGc(size, lsm)
+	if err != nil {
+		lsm.state.logger.Error("vlog gc failed", "error", err)
+		return err
+	}
+	lsm.state.logger.Info("vlog gc finished", "reclaimed", garbage-lsm.log.garbage, "vlog_size", lsm.log.fileSize(), "duration", time.Since(start))
+	return nil
 }
 
 //Check if given key was deleted
@@ -76,7 +318,7 @@ func (lsm *LsmTree) Exists(key []byte) []TableWithIndex {
 	var tableWithIndexes []TableWithIndex
 	for _, tablePath := range lsm.sstables {
 		reader, _ := os.Open(tablePath)
-		sstable := ReadTable(reader, lsm.log)
+		sstable := ReadTable(reader, lsm.log, lsm.comparator)
 		found, index := sstable.KeyAtIndex(key)
 		if found {
 			tableWithIndexes = append(tableWithIndexes, TableWithIndex{index: index, tablePath: tablePath})
@@ -86,85 +328,433 @@ func (lsm *LsmTree) Exists(key []byte) []TableWithIndex {
 	return tableWithIndexes
 }
 
+//Sstables that are merged in pairs by Merge, if amount of sstables is odd then the latest one stays as it is
+func (lsm *LsmTree) mergeInputs() []string {
+	return lsm.sstables[:len(lsm.sstables)-len(lsm.sstables)%2]
+}
+
 //Merge sstables, the final result is the sstable files with amount decreased by x2
 func (lsm *LsmTree) Merge() error {
 	lsm.rwm.Lock()
 	defer lsm.rwm.Unlock()
+	if err := lsm.writable(); err != nil {
+		return err
+	}
+	merged := lsm.mergeInputs()
+	if len(merged) == 0 {
+		return nil
+	}
+	return lsm.compact(merged, func() ([]string, error) {
+		return lsm.merge(merged)
+	})
+}
+
+//Merge all sstables that have keys in [start,end) into one sstable, nil start or end means unbounded range
+//sstables between the first and the last matching one are merged too so the order of sstables is kept
+func (lsm *LsmTree) CompactRange(start []byte, end []byte) error {
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	if err := lsm.writable(); err != nil {
+		return err
+	}
+	first, last := -1, -1
+	for index, tablePath := range lsm.sstables {
+		reader, err := os.Open(tablePath)
+		if err != nil {
+			return err
+		}
+		table := ReadTable(reader, lsm.log, lsm.comparator)
+		firstKey, lastKey, found := table.bounds()
+		table.Close()
+		if !found {
+			continue
+		}
+		if (end == nil || lsm.comparator.Compare(firstKey, end) < 0) && (start == nil || lsm.comparator.Compare(lastKey, start) >= 0) {
+			if first == -1 {
+				first = index
+			}
+			last = index
+		}
+	}
+	if first == last {
+		return nil
+	}
+	merged := lsm.sstables[first : last+1]
+	return lsm.compact(merged, func() ([]string, error) {
+		return lsm.mergeRun(first, last+1)
+	})
+}
+
+//Run merge of given sstables with metrics, events and logs, caller has to hold the write lock
+func (lsm *LsmTree) compact(merged []string, merge func() ([]string, error)) error {
+	start := time.Now()
+	defer lsm.metrics.mergeDuration.since(start)
+	info := CompactionInfo{Family: lsm.family}
+	for _, sstable := range merged {
+		lsm.state.logger.Debug("merge input", "family", lsm.family, "file", sstable)
+		info.Inputs = append(info.Inputs, lsm.tableInfo(sstable, "merge"))
+	}
+	lsm.notify(func(listener EventListener) {
+		listener.OnCompactionBegin(info)
+	})
+	outputs, err := merge()
+	for _, output := range outputs {
+		info.Outputs = append(info.Outputs, lsm.tableInfo(output, "merge"))
+	}
+	info.Duration = time.Since(start)
+	info.Err = err
+	lsm.notify(func(listener EventListener) {
+		listener.OnCompactionEnd(info)
+	})
+	if err != nil {
+		return err
+	}
+	lsm.state.logger.Info("merge finished", "family", lsm.family, "inputs", len(merged), "outputs", strings.Join(outputs, ","), "duration", info.Duration)
+	return nil
+}
+
+//Merge pairs of given sstables and replace them with merged files, caller has to hold the write lock
+func (lsm *LsmTree) merge(merged []string) ([]string, error) {
 	var newSstableFiles []string
-	index := 0
-	if len(lsm.sstables)%2 == 0 {
-		for _, sstable := range lsm.sstables {
-			_, err := os.Stat(sstable)
-			fmt.Printf("%v exists %v\n", sstable, !os.IsNotExist(err))
-		}
-		for index < len(lsm.sstables) {
-			firstReader, _ := os.Open(lsm.sstables[index])
-			secondReader, _ := os.Open(lsm.sstables[index+1])
-			//read two sstables
-			firstSStable := ReadTable(firstReader, lsm.log)
-			secondSStable := ReadTable(secondReader, lsm.log)
-			//merge them together into the single file
-			filePath, err, empty := lsm.mergeFiles(firstSStable, secondSStable)
+	leftover := lsm.sstables[len(merged):]
+	//merged files don't need range tombstones because all covered keys are not found by Get
+	//and are dropped during the merge, but they still have to cover the keys of the leftover table
+	var rangeTombstones []*rangeTombstone
+	if len(leftover) != 0 {
+		rangeTombstones = lsm.rangeTombstones
+	}
+	for index := 0; index < len(merged); index += 2 {
+		//all sstables but the latest one are merged so older values of deleted keys are dropped too
+		filePath, err, empty := lsm.mergePair(merged[index], merged[index+1], rangeTombstones, nil)
+		if err != nil {
+			return newSstableFiles, err
+		}
+		if empty {
+			err = os.Remove(filePath)
 			if err != nil {
-				return err
+				return newSstableFiles, err
 			}
-			if !empty {
-				newSstableFiles = append(newSstableFiles, filePath)
-			}
-			firstSStable.Close()
-			secondSStable.Close()
-			index += 2
+		} else {
+			newSstableFiles = append(newSstableFiles, filePath)
+			table := lsm.tableInfo(filePath, "merge")
+			lsm.notify(func(listener EventListener) {
+				listener.OnTableCreated(table)
+			})
+		}
+		//tombstones are saved once
+		rangeTombstones = nil
+	}
+	for _, sstable := range merged {
+		table := lsm.tableInfo(sstable, "merge")
+		err := os.Remove(sstable)
+		if err != nil {
+			return newSstableFiles, err
+		}
+		lsm.notify(func(listener EventListener) {
+			listener.OnTableDeleted(table)
+		})
+	}
+	lsm.sstables = append(newSstableFiles, leftover...)
+	if len(leftover) == 0 {
+		lsm.rangeTombstones = nil
+	}
+	lsm.updateCompactionDebt()
+	return newSstableFiles, nil
+}
+
+//Merge sstables with indexes in [from,to) one by one into the single sstable that takes their place,
+//caller has to hold the write lock
+func (lsm *LsmTree) mergeRun(from int, to int) ([]string, error) {
+	merged := lsm.sstables[from:to]
+	//tombstones are saved in the result if other sstables are left
+	var rangeTombstones []*rangeTombstone
+	if len(merged) != len(lsm.sstables) {
+		rangeTombstones = lsm.rangeTombstones
+	}
+	current := merged[0]
+	var intermediate []string
+	for index, next := range merged[1:] {
+		var tombstones []*rangeTombstone
+		if index == len(merged)-2 {
+			tombstones = rangeTombstones
+		}
+		filePath, err, empty := lsm.mergePair(current, next, tombstones, lsm.sstables[:from])
+		if err != nil {
+			removeFiles(intermediate)
+			return nil, err
 		}
-		for _, sstable := range lsm.sstables {
-			err := os.Remove(sstable)
+		if empty && index == len(merged)-2 {
+			removeFiles(intermediate)
+			err = os.Remove(filePath)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			current = ""
+		} else {
+			intermediate = append(intermediate, filePath)
+			current = filePath
 		}
-		lsm.sstables = newSstableFiles
 	}
-	return nil
+	//all but the last intermediate result were merged into it
+	var newSstableFiles []string
+	if current != "" {
+		removeFiles(intermediate[:len(intermediate)-1])
+		newSstableFiles = append(newSstableFiles, current)
+		table := lsm.tableInfo(current, "merge")
+		lsm.notify(func(listener EventListener) {
+			listener.OnTableCreated(table)
+		})
+	}
+	for _, sstable := range merged {
+		table := lsm.tableInfo(sstable, "merge")
+		err := os.Remove(sstable)
+		if err != nil {
+			return newSstableFiles, err
+		}
+		lsm.notify(func(listener EventListener) {
+			listener.OnTableDeleted(table)
+		})
+	}
+	sstables := append([]string{}, lsm.sstables[:from]...)
+	sstables = append(sstables, newSstableFiles...)
+	lsm.sstables = append(sstables, lsm.sstables[to:]...)
+	if len(lsm.sstables) == 0 {
+		lsm.rangeTombstones = nil
+	}
+	lsm.updateCompactionDebt()
+	return newSstableFiles, nil
+}
+
+//Merge two sstable files into a new one, the result is empty if all keys were dropped
+//first is older than second and older sstables are older than both of them
+func (lsm *LsmTree) mergePair(first string, second string, rangeTombstones []*rangeTombstone, older []string) (string, error, bool) {
+	firstReader, err := os.Open(first)
+	if err != nil {
+		return "", err, true
+	}
+	secondReader, err := os.Open(second)
+	if err != nil {
+		firstReader.Close()
+		return "", err, true
+	}
+	//read two sstables
+	firstSStable := ReadTable(firstReader, lsm.log, lsm.comparator)
+	secondSStable := ReadTable(secondReader, lsm.log, lsm.comparator)
+	defer firstSStable.Close()
+	defer secondSStable.Close()
+	var olderSStables []*SSTable
+	for _, tablePath := range older {
+		reader, err := os.Open(tablePath)
+		if err != nil {
+			return "", err, true
+		}
+		table := ReadTable(reader, lsm.log, lsm.comparator)
+		defer table.Close()
+		olderSStables = append(olderSStables, table)
+	}
+	//merge them together into the single file
+	return lsm.mergeFiles(firstSStable, secondSStable, rangeTombstones, olderSStables)
+}
+
+//Remove temporary files, errors are ignored
+func removeFiles(files []string) {
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
+
+//Get the value, the read lock keeps sstables from being replaced by the merge while they are read
+func (lsm *LsmTree) Get(key []byte) ([]byte, bool, error) {
+	defer lsm.metrics.getLatency.since(time.Now())
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	value, _, found, err := lsm.getWithVersion(key)
+	return value, found, err
+}
+
+//Get value with its version, the version changes every time the key is written
+func (lsm *LsmTree) GetWithVersion(key []byte) ([]byte, uint64, bool, error) {
+	defer lsm.metrics.getLatency.since(time.Now())
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	return lsm.getWithVersion(key)
+}
+
+//Get value with its version and the time when it expires, zero time means that the value never expires
+func (lsm *LsmTree) GetWithExpiration(key []byte) ([]byte, uint64, time.Time, bool, error) {
+	defer lsm.metrics.getLatency.since(time.Now())
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	value, version, found, err := lsm.getWithVersion(key)
+	if err != nil || !found {
+		return value, version, time.Time{}, found, err
+	}
+	expiresAt, err := lsm.expiresAt(key, version)
+	if err != nil {
+		return nil, NoVersion, time.Time{}, false, err
+	}
+	if expiresAt == 0 {
+		return value, version, time.Time{}, true, nil
+	}
+	return value, version, time.Unix(0, int64(expiresAt)), true, nil
+}
+
+//Expiration of the entry of the key with given version, 0 if it never expires
+//merged values don't expire because operands don't have ttl
+func (lsm *LsmTree) expiresAt(key []byte, version uint64) (uint64, error) {
+	if meta, found := lsm.memtable.Get(key); found {
+		if meta.timestamp == version {
+			return meta.expiresAt, nil
+		}
+		return 0, nil
+	}
+	entries, err := lsm.findInSStables(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.timestamp == version {
+			return entry.expiresAt, nil
+		}
+	}
+	return 0, nil
 }
-func (lsm *LsmTree) Get(key []byte) ([]byte, bool) {
+
+//get without metrics and lock, caller has to hold the lock, it's used by writes and merge
+func (lsm *LsmTree) getWithVersion(key []byte) ([]byte, uint64, bool, error) {
 	_, ok := lsm.deleted[string(key)]
 	if ok {
-		return nil, false
+		return nil, NoVersion, false, nil
 	}
 	meta, found := lsm.memtable.Get(key)
 	//first check in memory table
 	if found {
-		entry, err := lsm.log.Get(*meta)
-		if err != nil {
-			panic(err)
-		}
-		//check if it's a tombstone
-		if len(entry.value) == len(tombstone) && bytes.Compare(entry.value, []byte(tombstone)) == 0 {
-			return nil, false
-		} else {
-			return entry.value, true
+		if meta.kind != valueKind {
+			//merge operands are in memory, find the value they have to be applied to
+			var existing []byte
+			var err error
+			if meta.base != nil {
+				existing, _, _, err = lsm.getFromVlog(meta.base)
+			} else {
+				existing, _, _, err = lsm.getFromSStables(key)
+			}
+			if err != nil {
+				return nil, NoVersion, false, err
+			}
+			merged, err := lsm.applyMerge(key, existing, meta.operands)
+			if err != nil {
+				return nil, NoVersion, false, err
+			}
+			return merged, meta.timestamp, true, nil
 		}
+		return lsm.getFromVlog(meta)
 	} else {
 		//if not in memory then try to find in sstables
-		//multiple sstables can have the same key
-		//choose the one with the latest timestamp
-		foundEntry, found := lsm.findInSStables(key)
-		if !found {
-			return nil, false
-		} else {
-			//if the value in vlog is tombstone it means that value was deleted
-			if len(foundEntry.value) == len(tombstone) && bytes.Compare(foundEntry.value, []byte(tombstone)) == 0 {
-				return nil, false
-			} else {
-				return foundEntry.value, true
-			}
+		return lsm.getFromSStables(key)
+	}
+}
+
+func (lsm *LsmTree) getFromVlog(meta *ValueMeta) ([]byte, uint64, bool, error) {
+	entry, err := lsm.log.Get(*meta)
+	if err != nil {
+		return nil, NoVersion, false, err
+	}
+	//check if it's a tombstone
+	if isTombstone(entry.value) {
+		return nil, NoVersion, false, nil
+	} else if isExpired(entry.expiresAt) {
+		return nil, NoVersion, false, nil
+	} else {
+		return entry.value, entry.timestamp, true, nil
+	}
+}
+
+//multiple sstables can have the same key
+//choose the one with the latest timestamp
+//if it has merge operands then apply them to older values
+func (lsm *LsmTree) getFromSStables(key []byte) ([]byte, uint64, bool, error) {
+	entries, err := lsm.findInSStables(key)
+	if err != nil {
+		return nil, NoVersion, false, err
+	}
+	var operands [][]byte
+	var existing []byte
+	for _, entry := range entries {
+		//if the value in vlog is tombstone it means that value was deleted
+		if lsm.isRangeDeleted(key, entry.timestamp) || isExpired(entry.expiresAt) || isTombstone(entry.value) {
+			break
+		}
+		if entry.kind == mergeOperandsKind {
+			//older operands go first
+			operands = append(decodeOperands(entry.value), operands...)
+			continue
 		}
+		existing = entry.value
+		break
 	}
+	if operands == nil {
+		if existing == nil {
+			return nil, NoVersion, false, nil
+		}
+		return existing, entries[0].timestamp, true, nil
+	}
+	merged, err := lsm.applyMerge(key, existing, operands)
+	if err != nil {
+		return nil, NoVersion, false, err
+	}
+	return merged, entries[0].timestamp, true, nil
+}
+
+func (lsm *LsmTree) applyMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	if lsm.mergeOperator == nil {
+		return nil, ErrNoMergeOperator
+	}
+	merged, err := lsm.mergeOperator.Merge(key, existing, operands)
+	if err != nil {
+		return nil, fmt.Errorf("can't merge operands of key %q: %w", key, err)
+	}
+	return merged, nil
+}
+
+//Set operator that is used to apply merge operands
+func (lsm *LsmTree) SetMergeOperator(operator MergeOperator) {
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	lsm.mergeOperator = operator
+}
+
+//Save merge operand, it's applied to the value of the key on read
+//so the value doesn't have to be read before the update
+func (lsm *LsmTree) MergeValue(key []byte, operand []byte) error {
+	if err := lsm.admitWrite(); err != nil {
+		return err
+	}
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	if lsm.mergeOperator == nil {
+		return ErrNoMergeOperator
+	}
+	//reject operands that can't be applied
+	_, err := lsm.mergeOperator.Merge(key, nil, [][]byte{operand})
+	if err != nil {
+		return err
+	}
+	return lsm.put(MergeOperandEntry(key, operand))
 }
 
 //Save tombstone in vlog
 func (lsm *LsmTree) Delete(key []byte) error {
+	defer lsm.metrics.deleteLatency.since(time.Now())
+	if err := lsm.admitWrite(); err != nil {
+		return err
+	}
 	lsm.rwm.Lock()
 	defer lsm.rwm.Unlock()
+	return lsm.delete(key)
+}
+
+//delete without lock, caller has to hold the write lock
+func (lsm *LsmTree) delete(key []byte) error {
 	_, ok := lsm.deleted[string(key)]
 	//already deleted and it's still in memory
 	if ok {
@@ -174,53 +764,289 @@ func (lsm *LsmTree) Delete(key []byte) error {
 	return lsm.save(DeletedEntry(key))
 }
 
+//Put entry only if the current version of the key matches expected one
+//NoVersion as expected version means that the key must not exist
+//returns the new version of the key
+func (lsm *LsmTree) CompareAndPut(entry *TableEntry, expectedVersion uint64) (uint64, error) {
+	defer lsm.metrics.putLatency.since(time.Now())
+	if err := lsm.admitWrite(); err != nil {
+		return NoVersion, err
+	}
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	_, version, _, err := lsm.getWithVersion(entry.key)
+	if err != nil {
+		return NoVersion, err
+	}
+	if version != expectedVersion {
+		return version, ErrVersionMismatch
+	}
+	err = lsm.put(entry)
+	if err != nil {
+		return version, err
+	}
+	return entry.timestamp, nil
+}
+
+//Replace the value only if nobody changed it since expected version was read
+func (lsm *LsmTree) CompareAndSwap(key []byte, expectedVersion uint64, value []byte) (uint64, error) {
+	entry := NewEntry(key, value)
+	return lsm.CompareAndPut(&entry, expectedVersion)
+}
+
+//Put the value only if the key doesn't exist
+func (lsm *LsmTree) PutIfAbsent(key []byte, value []byte) (uint64, error) {
+	return lsm.CompareAndSwap(key, NoVersion, value)
+}
+
+//Delete the key only if its current version matches expected one
+func (lsm *LsmTree) DeleteIfVersion(key []byte, expectedVersion uint64) error {
+	defer lsm.metrics.deleteLatency.since(time.Now())
+	if err := lsm.admitWrite(); err != nil {
+		return err
+	}
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	_, version, found, err := lsm.getWithVersion(key)
+	if err != nil {
+		return err
+	}
+	if !found || version != expectedVersion {
+		return ErrVersionMismatch
+	}
+	return lsm.delete(key)
+}
+
+//Delete all keys in [start,end) with a single range tombstone
+func (lsm *LsmTree) DeleteRange(start []byte, end []byte) error {
+	if lsm.comparator.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+	if err := lsm.admitWrite(); err != nil {
+		return err
+	}
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	if err := lsm.writable(); err != nil {
+		return err
+	}
+	tombstone := &rangeTombstone{start: start, end: end, timestamp: lsm.log.nextTimestamp()}
+	entry := RangeTombstoneEntry(tombstone)
+	entry.family = lsm.family
+	_, err := lsm.log.Append(entry)
+	if err != nil {
+		return err
+	}
+	lsm.memtable.DeleteRange(tombstone)
+	lsm.publish(Change{Type: ChangeDeleteRange, Key: start, End: end, Version: tombstone.timestamp})
+	if lsm.memtable.isFull() {
+		return lsm.flush()
+	}
+	return nil
+}
+
 //save entry in vlog first then in sstable
 func (lsm *LsmTree) Put(entry *TableEntry) error {
+	defer lsm.metrics.putLatency.since(time.Now())
+	if err := lsm.admitWrite(); err != nil {
+		return err
+	}
 	lsm.rwm.Lock()
 	defer lsm.rwm.Unlock()
+	return lsm.put(entry)
+}
+
+//put without lock, caller has to hold the write lock
+func (lsm *LsmTree) put(entry *TableEntry) error {
 	//before put let's delete this key from deleted map
 	delete(lsm.deleted, string(entry.key))
 	return lsm.save(entry)
 }
 
+//save entry that is treated as missing once ttl passes
+func (lsm *LsmTree) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	entry := NewEntryWithTTL(key, value, ttl)
+	return lsm.Put(&entry)
+}
+
 //Flush in memory red black tree to sstable on disk
 func (lsm *LsmTree) Flush() error {
-	sstablePath := lsm.sstableDir + "/" + RandStringBytes(sstableFileLength) + ".sstable"
-	file, err := os.OpenFile(sstablePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	if err := lsm.writable(); err != nil {
+		return err
+	}
+	return lsm.flush()
+}
+
+//flush without lock, caller has to hold the write lock
+func (lsm *LsmTree) flush() error {
+	start := time.Now()
+	defer lsm.metrics.flushDuration.since(start)
+	info := FlushInfo{Family: lsm.family, Entries: lsm.memtable.tree.Size()}
+	lsm.notify(func(listener EventListener) {
+		listener.OnFlushBegin(info)
+	})
+	table, err := lsm.flushMemtable()
+	info.Table = table
+	info.Duration = time.Since(start)
+	info.Err = err
+	lsm.notify(func(listener EventListener) {
+		listener.OnFlushEnd(info)
+	})
 	if err != nil {
+		//memtable is kept so the flush is retried by the next write
+		lsm.backgroundError("flush", err)
 		return err
 	}
-	writer := NewWriter(file, uint32(20))
+	lsm.backgroundRecovered("flush")
+	lsm.state.logger.Info("flush finished", "family", lsm.family, "file", table.Path, "entries", info.Entries, "duration", info.Duration)
+	return nil
+}
+
+//Write memtable to a new sstable and save checkpoint
+func (lsm *LsmTree) flushMemtable() (TableInfo, error) {
+	err := lsm.flushMerges()
+	if err != nil {
+		return TableInfo{}, err
+	}
+	lsm.lastOrder.sequence++
+	sstablePath := lsm.sstableDir + "/" + tableOrder{sequence: lsm.lastOrder.sequence}.fileName()
+	file, err := os.OpenFile(sstablePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return TableInfo{}, err
+	}
+	writer := NewWriter(lsm.limitWrites(file), uint32(20))
+	rangeTombstones := lsm.memtable.rangeTombstones
 	err = lsm.memtable.Flush(writer)
 	if err != nil {
-		return err
+		return TableInfo{}, err
 	}
 	err = writer.Close()
 	if err != nil {
-		return err
+		return TableInfo{}, err
 	}
-	err = lsm.log.FlushHead()
+	table := lsm.tableInfo(sstablePath, "flush")
+	lsm.notify(func(listener EventListener) {
+		listener.OnTableCreated(table)
+	})
+	err = lsm.log.FlushHead(lsm.checkpoint)
 	if err != nil {
-		return err
+		return table, err
 	}
 	lsm.sstables = append(lsm.sstables, sstablePath)
+	lsm.rangeTombstones = append(lsm.rangeTombstones, rangeTombstones...)
+	lsm.updateCompactionDebt()
+	return table, nil
+}
+
+//Background writes of flush and merge are limited by the rate limiter after the lock is released
+func (lsm *LsmTree) limitWrites(file *os.File) io.WriteCloser {
+	if lsm.state.limiter == nil {
+		return file
+	}
+	return &limitedWriter{writer: file, limiter: lsm.state.limiter}
+}
+
+//Merge operands of the key are kept in memory and have to be saved in vlog as a single value before flush
+//if memtable has the value they are applied to then they are applied right away
+func (lsm *LsmTree) flushMerges() error {
+	keys, metas := lsm.memtable.merges()
+	for i, meta := range metas {
+		entry := &TableEntry{key: keys[i], timestamp: meta.timestamp, family: lsm.family}
+		if meta.base != nil {
+			existing, _, found, err := lsm.getFromVlog(meta.base)
+			if err != nil {
+				return err
+			}
+			if found {
+				entry.expiresAt = meta.base.expiresAt
+			}
+			entry.value, err = lsm.applyMerge(entry.key, existing, meta.operands)
+			if err != nil {
+				return err
+			}
+		} else {
+			entry.kind = mergeOperandsKind
+			entry.value = encodeOperands(meta.operands)
+		}
+		newMeta, err := lsm.log.Append(entry)
+		if err != nil {
+			return err
+		}
+		if entry.kind == mergeOperandsKind {
+			newMeta.operands = meta.operands
+		}
+		err = lsm.memtable.Put(entry.key, newMeta)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+//Collapse merge operands of the latest entry with older entry of the same key
+func (lsm *LsmTree) collapseMerge(latest *sstableEntry, older *sstableEntry) (*sstableEntry, error) {
+	latestValue, err := lsm.log.Get(ValueMeta{offset: latest.valueOffset, length: latest.valueLength})
+	if err != nil {
+		return nil, err
+	}
+	//expired value can be removed from vlog by gc so it's not read
+	olderValue := &TableEntry{}
+	olderAlive := !lsm.isRangeDeleted(older.key, older.timeStamp) && !isExpired(older.expiresAt)
+	if olderAlive {
+		olderValue, err = lsm.log.Get(ValueMeta{offset: older.valueOffset, length: older.valueLength})
+		if err != nil {
+			return nil, err
+		}
+		olderAlive = !isTombstone(olderValue.value)
+	}
+	operands := decodeOperands(latestValue.value)
+	entry := &TableEntry{key: latest.key, timestamp: latest.timeStamp, family: lsm.family}
+	if olderAlive && older.kind == mergeOperandsKind {
+		//value can be in other sstables so keep operands
+		entry.kind = mergeOperandsKind
+		entry.value = encodeOperands(append(decodeOperands(olderValue.value), operands...))
+	} else {
+		var existing []byte
+		if olderAlive {
+			existing = olderValue.value
+			entry.expiresAt = older.expiresAt
+		}
+		entry.value, err = lsm.applyMerge(entry.key, existing, operands)
+		if err != nil {
+			return nil, err
+		}
+	}
+	meta, err := lsm.log.Append(entry)
+	if err != nil {
+		return nil, err
+	}
+	return NewSStableEntry(entry.key, meta), nil
+}
+
 func (lsm *LsmTree) save(entry *TableEntry) error {
+	if err := lsm.writable(); err != nil {
+		return err
+	}
+	if entry.kind == mergeOperandKind {
+		if err := lsm.saveMergeOperator(); err != nil {
+			return err
+		}
+	}
+	entry.timestamp = lsm.log.nextTimestamp()
+	entry.family = lsm.family
 	//append to log
 	meta, err := lsm.log.Append(entry)
 	if err != nil {
 		return err
 	}
-	//save to memtable
-	err = lsm.memtable.Put(entry.key, meta)
-	if err != nil {
+	if err := lsm.apply(entry, meta); err != nil {
 		return err
 	}
 	//if full flush memtable to sstable
 	if lsm.memtable.isFull() {
-		err := lsm.Flush()
+		err := lsm.flush()
 		if err != nil {
 			return err
 		}
@@ -228,175 +1054,270 @@ func (lsm *LsmTree) save(entry *TableEntry) error {
 	return nil
 }
 
-func (lsm *LsmTree) findInSStables(key []byte) (*SearchEntry, bool) {
-	var latestEntry *SearchEntry
-	for _, tablePath := range lsm.sstables {
-		reader, e := os.Open(tablePath)
-		if e != nil {
-			panic(e)
+//Save entry that is already in vlog to memtable
+func (lsm *LsmTree) apply(entry *TableEntry, meta *ValueMeta) error {
+	//overwritten value in memtable is garbage in vlog
+	if old, found := lsm.memtable.Get(entry.key); found && entry.kind != mergeOperandKind {
+		lsm.log.garbage += int64(old.length)
+	}
+	//save to memtable
+	if entry.kind == mergeOperandKind {
+		lsm.memtable.Merge(entry.key, meta, decodeOperands(entry.value))
+	} else {
+		err := lsm.memtable.Put(entry.key, meta)
+		if err != nil {
+			return err
 		}
-		sstable := ReadTable(reader, lsm.log)
-		searchEntry, found := sstable.Get(key)
-		if found {
-			if latestEntry == nil {
-				latestEntry = searchEntry
-			} else {
-				if searchEntry.timestamp > latestEntry.timestamp {
-					latestEntry = searchEntry
-				}
+	}
+	lsm.publish(entryChange(entry))
+	return nil
+}
+
+//Save entries of many column families with a single vlog entry so either all of them
+//or none of them are restored after a crash, caller has to hold the write lock
+func saveBatch(families []*LsmTree, entries []*TableEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	log := families[0].log
+	for i, entry := range entries {
+		if entry.kind == mergeOperandKind {
+			if err := families[i].saveMergeOperator(); err != nil {
+				return err
+			}
+		}
+		entry.timestamp = log.nextTimestamp()
+		entry.family = families[i].family
+	}
+	batch, offsets, err := BatchEntry(entries)
+	if err != nil {
+		return err
+	}
+	batch.timestamp = entries[len(entries)-1].timestamp
+	batchMeta, err := log.Append(batch)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		meta := &ValueMeta{length: entry.length(), offset: batchMeta.offset + offsets[i], timestamp: entry.timestamp, expiresAt: entry.expiresAt, kind: entry.kind}
+		if err := families[i].apply(entry, meta); err != nil {
+			return err
+		}
+	}
+	for _, lsm := range families {
+		if lsm.memtable.isFull() {
+			if err := lsm.flush(); err != nil {
+				return err
 			}
 		}
+	}
+	return nil
+}
+
+//Find all entries of the key in sstables sorted by timestamp, the latest entry goes first
+func (lsm *LsmTree) findInSStables(key []byte) ([]*SearchEntry, error) {
+	var entries []*SearchEntry
+	for _, tablePath := range lsm.sstables {
+		reader, err := os.Open(tablePath)
+		if err != nil {
+			return nil, err
+		}
+		sstable := ReadTable(reader, lsm.log, lsm.comparator)
+		searchEntry, found, err := sstable.Get(key)
 		sstable.Close()
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			entries = append(entries, searchEntry)
+		}
 	}
-	return latestEntry, latestEntry != nil
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].timestamp > entries[j].timestamp
+	})
+	return entries, nil
 }
 
+//Check if the entry from sstable was deleted by one of the range tombstones
+func (lsm *LsmTree) isRangeDeleted(key []byte, timestamp uint64) bool {
+	for _, tombstone := range lsm.rangeTombstones {
+		if tombstone.covers(key, timestamp, lsm.comparator) {
+			return true
+		}
+	}
+	for _, tombstone := range lsm.memtable.rangeTombstones {
+		if tombstone.covers(key, timestamp, lsm.comparator) {
+			return true
+		}
+	}
+	return false
+}
+
+//Replay vlog entries after the checkpoint into memtable
+//nothing is written to disk so read only tree restores the same way
 func (lsm *LsmTree) restore() error {
-	reader, err := os.OpenFile(lsm.log.checkpoint, os.O_RDONLY, 0666)
-	//if file doesn't exist
+	start := time.Now()
+	err := lsm.restoreMemtable()
+	if err != nil {
+		return err
+	}
+	lsm.state.logger.Info("memtable restored from vlog", "family", lsm.family, "entries", lsm.memtable.tree.Size(), "duration", time.Since(start))
+	return nil
+}
+
+func (lsm *LsmTree) restoreMemtable() error {
+	reader, err := os.OpenFile(lsm.checkpoint, os.O_RDONLY, 0666)
+	//if file doesn't exist then nothing was flushed, restore the whole vlog
 	if errors.Is(err, os.ErrNotExist) {
-		return nil
+		return lsm.log.RestoreTo(0, lsm.family, lsm.memtable)
 	} else {
 		defer reader.Close()
 		stat, err := reader.Stat()
 		if err != nil {
 			return err
 		}
-		//if empty => skip
+		//if empty => restore the whole vlog
 		if stat.Size() == int64(0) {
-			return nil
+			return lsm.log.RestoreTo(0, lsm.family, lsm.memtable)
 		} else {
-			headBuffer := make([]byte, uint32Size)
-			_, err := reader.Read(headBuffer)
+			//checkpoint without the timestamp has only the head
+			headBuffer := make([]byte, uint32Size+int64Size)
+			_, err := io.ReadAtLeast(reader, headBuffer, uint32Size)
 			if err != nil {
 				return err
 			}
 			headOffset := binary.BigEndian.Uint32(headBuffer)
-			return lsm.log.RestoreTo(headOffset, lsm.memtable)
+			//entries before the head are in sstables, the checkpoint keeps their latest timestamp
+			lsm.log.observeTimestamp(binary.BigEndian.Uint64(headBuffer[uint32Size:]))
+			return lsm.log.RestoreTo(headOffset, lsm.family, lsm.memtable)
 		}
 	}
 }
 
 //save all sstable paths in memory
-func (lsm *LsmTree) fillSstables() {
-	//if sstable dir exists then try to get all sstable files from it
-	if _, err := os.Stat(lsm.sstableDir); !os.IsNotExist(err) {
-		err := filepath.Walk(
-			lsm.sstableDir,
-			func(path string, f os.FileInfo, _ error) error {
-				if !f.IsDir() {
-					r, err := regexp.MatchString(sstableExtension, f.Name())
-					if err == nil && r {
-						lsm.sstables = append(lsm.sstables, lsm.sstableDir+"/"+f.Name())
-					}
+func (lsm *LsmTree) fillSstables() error {
+	//column families are stored in sub directories so only files of this directory are read
+	files, err := ioutil.ReadDir(lsm.sstableDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			r, err := regexp.MatchString(sstableExtension, f.Name())
+			if err == nil && r {
+				tablePath := lsm.sstableDir + "/" + f.Name()
+				lsm.sstables = append(lsm.sstables, tablePath)
+				reader, err := os.Open(tablePath)
+				if err != nil {
+					return err
 				}
-				return nil
-			},
-		)
-		if err != nil {
-			panic(err)
+				sstable := ReadTable(reader, lsm.log, lsm.comparator)
+				lsm.rangeTombstones = append(lsm.rangeTombstones, sstable.rangeTombstones...)
+				sstable.Close()
+			}
+		}
+	}
+	//sstables are kept from the oldest to the latest one so the merge knows which of them are older
+	sortTables(lsm.sstables)
+	for _, tablePath := range lsm.sstables {
+		order, _ := parseTableOrder(tablePath)
+		if order.sequence > lsm.lastOrder.sequence {
+			lsm.lastOrder.sequence = order.sequence
+		}
+		if order.generation > lsm.lastOrder.generation {
+			lsm.lastOrder.generation = order.generation
 		}
 	}
+	return nil
 }
 
-func (lsm *LsmTree) mergeFiles(first *SSTable, second *SSTable) (string, error, bool) {
-	sstablePath := lsm.sstableDir + "/" + RandStringBytes(sstableFileLength) + ".sstable"
+//Merge two sstables into a new one with given range tombstones
+//the new sstable takes the place of the second one in the order of sstables
+func (lsm *LsmTree) mergeFiles(first *SSTable, second *SSTable, rangeTombstones []*rangeTombstone, older []*SSTable) (string, error, bool) {
+	order, _ := parseTableOrder(second.reader.Name())
+	lsm.lastOrder.generation++
+	order.generation = lsm.lastOrder.generation
+	sstablePath := lsm.sstableDir + "/" + order.fileName()
 	file, err := os.OpenFile(sstablePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
-	empty := true
+	empty := len(rangeTombstones) == 0
 	if err != nil {
 		return "", err, true
 	}
-	writer := NewWriter(file, uint32(20))
+	writer := NewWriter(lsm.limitWrites(file), uint32(20))
+	for _, tombstone := range rangeTombstones {
+		writer.WriteRangeTombstone(tombstone)
+	}
+	//write entry only if it's still alive
+	write := func(entry *sstableEntry) error {
+		_, _, found, err := lsm.getWithVersion(entry.key)
+		if err != nil {
+			return err
+		}
+		if !found || isExpired(entry.expiresAt) {
+			hides, err := lsm.hidesOlder(entry, older)
+			if err != nil {
+				return err
+			}
+			if !hides {
+				lsm.log.garbage += int64(entry.valueLength)
+				return nil
+			}
+		}
+		_, err = writer.WriteEntry(entry)
+		if err != nil {
+			return err
+		}
+		empty = false
+		return nil
+	}
 	var i1, i2 int
 	for i1 < len(first.indexes) && i2 < len(second.indexes) {
-		firstReader := NewReader(first.reader, int64(first.indexes[i1].Offset))
-		secondReader := NewReader(second.reader, int64(second.indexes[i2].Offset))
-		firstKey := string(firstReader.readKey(firstReader.readKeyLength()))
-		secondKey := string(secondReader.readKey(secondReader.readKeyLength()))
-		compare := strings.Compare(firstKey, secondKey)
+		firstEntry := NewReader(first.reader, int64(first.indexes[i1].Offset)).readEntry()
+		secondEntry := NewReader(second.reader, int64(second.indexes[i2].Offset)).readEntry()
+		compare := lsm.comparator.Compare(firstEntry.key, secondEntry.key)
 		if compare > 0 {
-			timestamp := secondReader.readTimestamp()
-			offset := secondReader.readValueOffset()
-			length := secondReader.readValueLength()
-			_, found := lsm.Get([]byte(secondKey))
-			if found {
-				_, err := writer.WriteEntry(&sstableEntry{key: []byte(secondKey), timeStamp: timestamp, valueOffset: offset, valueLength: length})
-				if err != nil {
-					return "", err, true
-				}
-				empty = false
+			if err := write(secondEntry); err != nil {
+				return "", err, true
 			}
 			i2++
 		} else if compare < 0 {
-			timestamp := firstReader.readTimestamp()
-			offset := firstReader.readValueOffset()
-			length := firstReader.readValueLength()
-			_, found := lsm.Get([]byte(firstKey))
-			if found {
-				_, err := writer.WriteEntry(&sstableEntry{key: []byte(firstKey), timeStamp: timestamp, valueOffset: offset, valueLength: length})
-				if err != nil {
-					return "", err, true
-				}
-				empty = false
+			if err := write(firstEntry); err != nil {
+				return "", err, true
 			}
 			i1++
 		} else {
-			firstTm := firstReader.readTimestamp()
-			secondTm := secondReader.readTimestamp()
-			_, found := lsm.Get([]byte(firstKey))
-			if found {
-				if firstTm > secondTm {
-					offset := firstReader.readValueOffset()
-					length := firstReader.readValueLength()
-					_, err := writer.WriteEntry(&sstableEntry{key: []byte(firstKey), timeStamp: firstTm, valueOffset: offset, valueLength: length})
-					if err != nil {
-						return "", err, true
-					}
-				} else {
-					offset := secondReader.readValueOffset()
-					length := secondReader.readValueLength()
-					_, err := writer.WriteEntry(&sstableEntry{key: []byte(firstKey), timeStamp: secondTm, valueOffset: offset, valueLength: length})
-					if err != nil {
-						return "", err, true
-					}
+			latest, older := secondEntry, firstEntry
+			if firstEntry.timeStamp > secondEntry.timeStamp {
+				latest, older = firstEntry, secondEntry
+			}
+			lsm.log.garbage += int64(older.valueLength)
+			if latest.kind == mergeOperandsKind && !isExpired(latest.expiresAt) {
+				collapsed, err := lsm.collapseMerge(latest, older)
+				if err != nil {
+					return "", err, true
 				}
-				empty = false
+				lsm.log.garbage += int64(latest.valueLength)
+				latest = collapsed
+			}
+			if err := write(latest); err != nil {
+				return "", err, true
 			}
 			i1++
 			i2++
 		}
 	}
 	for i1 < len(first.indexes) {
-		reader := NewReader(first.reader, int64(first.indexes[i1].Offset))
-		key := reader.readKey(reader.readKeyLength())
-		timestamp := reader.readTimestamp()
-		offset := reader.readValueOffset()
-		length := reader.readValueLength()
-		_, found := lsm.Get(key)
-		if found {
-			_, err := writer.WriteEntry(&sstableEntry{key: key, timeStamp: timestamp, valueOffset: offset, valueLength: length})
-			if err != nil {
-				return "", err, true
-			}
-			empty = true
+		entry := NewReader(first.reader, int64(first.indexes[i1].Offset)).readEntry()
+		if err := write(entry); err != nil {
+			return "", err, true
 		}
 		i1++
 	}
 	for i2 < len(second.indexes) {
-		reader := NewReader(second.reader, int64(second.indexes[i2].Offset))
-		key := reader.readKey(reader.readKeyLength())
-		timestamp := reader.readTimestamp()
-		offset := reader.readValueOffset()
-		length := reader.readValueLength()
-		_, found := lsm.Get(key)
-		if found {
-			_, err := writer.WriteEntry(&sstableEntry{key: key, timeStamp: timestamp, valueOffset: offset, valueLength: length})
-			if err != nil {
-				return "", err, true
-			}
-			empty = false
+		entry := NewReader(second.reader, int64(second.indexes[i2].Offset)).readEntry()
+		if err := write(entry); err != nil {
+			return "", err, true
 		}
-
 		i2++
 	}
 	err = writer.Close()
@@ -405,3 +1326,25 @@ func (lsm *LsmTree) mergeFiles(first *SSTable, second *SSTable) (string, error,
 	}
 	return sstablePath, nil, empty
 }
+
+//Tombstone or expired entry hides older values of the key so it's kept while older sstables have the key
+func (lsm *LsmTree) hidesOlder(entry *sstableEntry, older []*SSTable) (bool, error) {
+	inOlder := false
+	for _, table := range older {
+		if _, found := table.lookup(entry.key); found {
+			inOlder = true
+			break
+		}
+	}
+	if !inOlder {
+		return false, nil
+	}
+	if isExpired(entry.expiresAt) {
+		return true, nil
+	}
+	value, err := lsm.log.Get(ValueMeta{offset: entry.valueOffset, length: entry.valueLength})
+	if err != nil {
+		return false, err
+	}
+	return isTombstone(value.value), nil
+}
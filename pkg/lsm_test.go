@@ -2,6 +2,7 @@ package wiskey
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -28,6 +29,48 @@ func InitTestLsmWithMeta(size int, gc uint) *LsmTree {
 	return NewLsmTree(vlog, tempDir, NewMemTable(size), gc)
 }
 
+//Get the value and fail the test if it can't be read
+func mustGet(t *testing.T, tree *LsmTree, key []byte) ([]byte, bool) {
+	t.Helper()
+	value, found, err := tree.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value, found
+}
+
+//Paths of sstables, merge job can replace them at the same time
+func sstablesOf(tree *LsmTree) []string {
+	tree.rwm.RLock()
+	defer tree.rwm.RUnlock()
+	return append([]string{}, tree.sstables...)
+}
+
+//Stop the tree and release the lock without flushing memtable as if the process crashed
+func crash(tree *LsmTree) {
+	tree.rwm.Lock()
+	tree.state.closed = true
+	close(tree.state.stop)
+	tree.rwm.Unlock()
+	tree.state.jobs.Wait()
+	if tree.state.lock != nil {
+		tree.state.lock.release()
+	}
+}
+
+//Open the same store in read only mode while the tree is still opened
+func openReadOnly(tree *LsmTree, memtableSize int) *LsmTree {
+	options := DefaultOptions()
+	options.MemtableSize = memtableSize
+	options.ReadOnly = true
+	options.MergeOperator = tree.mergeOperator
+	db, err := OpenPaths(tree.sstableDir, tree.log.file, tree.log.checkpoint, options)
+	if err != nil {
+		panic(err)
+	}
+	return db.LsmTree
+}
+
 func TestLsmTree_GetDeletedValue(t *testing.T) {
 	tree := InitTestLsmWithMeta(100, 30)
 	defer os.RemoveAll(tree.sstableDir)
@@ -54,7 +97,7 @@ func TestLsmTree_GetDeletedValue(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, found := tree.Get(key)
+	_, found := mustGet(t, tree, key)
 	if found {
 		t.Fatal("Deleted key was found")
 	}
@@ -79,7 +122,7 @@ func TestLsmTree_PutAndGetFromSSTable(t *testing.T) {
 	}
 	//fetch entries
 	for _, entry := range entries {
-		result, found := tree.Get(entry.key)
+		result, found := mustGet(t, tree, entry.key)
 		if !found {
 			t.Fatal("Key wasn't found in sstable")
 		}
@@ -88,7 +131,7 @@ func TestLsmTree_PutAndGetFromSSTable(t *testing.T) {
 		}
 	}
 	//try to find non existing key
-	_, found := tree.Get([]byte("NON EXISTING KEY"))
+	_, found := mustGet(t, tree, []byte("NON EXISTING KEY"))
 	if found {
 		t.Error("Found non existing key")
 	}
@@ -109,7 +152,7 @@ func TestLsmTree_GetInMemory(t *testing.T) {
 		}
 	}
 	for _, entry := range entries {
-		value, found := tree.Get(entry.key)
+		value, found := mustGet(t, tree, entry.key)
 		if !found {
 			t.Fatal("Value was not found in lsm tree")
 		}
@@ -135,7 +178,7 @@ func TestLsmTree_Merge(t *testing.T) {
 			t.Fatal(err)
 		}
 		//store exactly 3 sstables
-		if len(tree.sstables) == 3 {
+		if len(sstablesOf(tree)) == 3 {
 			break
 		}
 	}
@@ -148,11 +191,11 @@ func TestLsmTree_Merge(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	amount := len(tree.sstables)
+	amount := len(sstablesOf(tree))
 	t.Logf("Lsm has %d files before merge", amount)
 	//wait for merge
 	time.Sleep(6 * time.Second)
-	sizeAfterGc := len(tree.sstables)
+	sizeAfterGc := len(sstablesOf(tree))
 	if sizeAfterGc != 2 {
 		t.Fatal("Amount of sstables after merge had to be decreased by 2 times")
 	}
@@ -161,7 +204,7 @@ func TestLsmTree_Merge(t *testing.T) {
 			break
 		}
 		savedCnt--
-		_, found := tree.Get(entry.key)
+		_, found := mustGet(t, tree, entry.key)
 		if !found && i != 0 && i != 1 {
 			t.Fatal("Wasn't able to find key after merge")
 		}
@@ -175,7 +218,12 @@ func TestLsmTree_Merge(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	sizeAfter := tree.log.size
+	//offsets don't change after gc so only the file is smaller
+	stat, err = os.Stat(tree.log.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizeAfter := uint32(stat.Size())
 	if sizeBefore <= sizeAfter {
 		t.Fatalf("The size of vlog had to decrease after compression but was %d , become %d", sizeBefore, sizeAfter)
 	}
@@ -202,16 +250,18 @@ func TestLsmTree_Restore(t *testing.T) {
 		}
 	}
 	//now before flush we create a new lsm tree
+	crash(tree)
 	vlog := NewVlog(tree.log.file, tree.log.checkpoint)
 	//this tree has to have last half of entries restored from the vlog
 	newTree := NewLsmTree(vlog, tree.sstableDir, NewMemTable(100), 30)
 	for index := len(entries)/2 + 1; index < len(entries); index++ {
-		_, found := newTree.Get(entries[index].key)
+		_, found := mustGet(t, newTree, entries[index].key)
 		if !found {
 			t.Fatal("Didn't restore the key from vlog")
 		}
 	}
 	//if we try to restore it again it will be restored because we didn't flush a previous one
+	crash(newTree)
 	vlog = NewVlog(tree.log.file, tree.log.checkpoint)
 	newTree = NewLsmTree(vlog, tree.sstableDir, NewMemTable(100), 30)
 	if newTree.memtable.Size() == 0 {
@@ -222,9 +272,539 @@ func TestLsmTree_Restore(t *testing.T) {
 		t.Fatal(err)
 	}
 	//now it was flushed so memtable has to be empty
+	crash(newTree)
 	vlog = NewVlog(tree.log.file, tree.log.checkpoint)
 	newTree = NewLsmTree(vlog, tree.sstableDir, NewMemTable(100), 30)
 	if newTree.memtable.Size() != 0 {
 		t.Fatal("Memtable has to be empty after flush")
 	}
 }
+
+func TestLsmTree_DeleteRange(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	entries := FakeEntries()
+	//first half goes to sstable, second half stays in memory
+	for index, entry := range entries {
+		err := tree.Put(&entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index == len(entries)/2 {
+			err := tree.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	//deletes BNITA,GNITA and NNITA
+	err := tree.DeleteRange([]byte("BNITA"), []byte("TNITA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := map[string]bool{"BNITA": true, "GNITA": true, "NNITA": true}
+	check := func(tree *LsmTree) {
+		for _, entry := range entries {
+			_, found := mustGet(t, tree, entry.key)
+			if found == deleted[string(entry.key)] {
+				t.Fatalf("Key %s is found %v after range deletion", entry.key, found)
+			}
+		}
+	}
+	check(tree)
+	//range tombstone has to be restored from vlog
+	check(openReadOnly(tree, 100))
+	err = tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//range tombstone has to be read from sstable
+	check(openReadOnly(tree, 100))
+	//keys that were put after the range deletion are visible
+	entry := NewEntry([]byte("GNITA"), []byte("NEW"))
+	err = tree.Put(&entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, found := mustGet(t, tree, entry.key)
+	if !found || bytes.Compare(value, entry.value) != 0 {
+		t.Fatal("Key that was put after range deletion was not found")
+	}
+	//merge drops covered keys and range tombstones, it only merges even amount of tables
+	if len(tree.sstables)%2 != 0 {
+		err = tree.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tree.Merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.rangeTombstones) != 0 {
+		t.Fatal("Range tombstones have to be dropped after merge")
+	}
+	delete(deleted, "GNITA")
+	check(tree)
+	if err := tree.DeleteRange([]byte("B"), []byte("A")); err == nil {
+		t.Fatal("Range with start bigger than end has to be rejected")
+	}
+}
+
+func TestLsmTree_Iterator(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	entries := FakeEntries()
+	for index, entry := range entries {
+		err := tree.Put(&entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index == len(entries)/2 {
+			err := tree.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err := tree.Delete([]byte("GNITA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.DeleteRange([]byte("N"), []byte("O"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterator := tree.NewIterator([]byte("B"), []byte("WNITA"))
+	var keys []string
+	for iterator.Next() {
+		keys = append(keys, string(iterator.Key()))
+	}
+	if len(keys) != 2 || keys[0] != "BNITA" || keys[1] != "TNITA" {
+		t.Fatalf("Iterator returned wrong keys %v", keys)
+	}
+}
+
+//Keys of several batches that are spread over sstables and the memtable are returned once and in order
+func TestLsmTree_IteratorBatches(t *testing.T) {
+	tree := InitTestLsmWithMeta(1<<24, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	keys := 3 * iteratorBatch
+	//the first batch of the sstable ends in the middle of the range while the batch of memtable covers it all
+	for i := 0; i < keys; i++ {
+		if i%3 == 0 {
+			continue
+		}
+		entry := NewEntry([]byte(fmt.Sprintf("key%06d", i)), []byte("value"))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < keys; i += 3 {
+		entry := NewEntry([]byte(fmt.Sprintf("key%06d", i)), []byte("value"))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Delete([]byte("key000100")); err != nil {
+		t.Fatal(err)
+	}
+	iterator := tree.NewIterator([]byte("key000010"), []byte("key003000"))
+	expected := 10
+	for iterator.Next() {
+		if expected == 100 {
+			expected++
+		}
+		if string(iterator.Key()) != fmt.Sprintf("key%06d", expected) {
+			t.Fatalf("expected key%06d, got %s", expected, iterator.Key())
+		}
+		expected++
+	}
+	if err := iterator.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if expected != 3000 {
+		t.Fatalf("iteration stopped at key%06d", expected)
+	}
+}
+
+func TestLsmTree_PutWithTTL(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	err := tree.PutWithTTL([]byte("ANITA"), []byte("DEVELOPER"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.PutWithTTL([]byte("BNITA"), []byte("DEVELOPER"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := mustGet(t, tree, []byte("ANITA")); !found {
+		t.Fatal("Key was not found before expiration")
+	}
+	err = tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := mustGet(t, tree, []byte("ANITA")); !found {
+		t.Fatal("Key was not found in sstable before expiration")
+	}
+	time.Sleep(time.Second)
+	if _, found := mustGet(t, tree, []byte("ANITA")); found {
+		t.Fatal("Expired key was found")
+	}
+	if _, found := mustGet(t, tree, []byte("BNITA")); !found {
+		t.Fatal("Not expired key was not found")
+	}
+	iterator := tree.NewIterator([]byte("A"), nil)
+	for iterator.Next() {
+		if string(iterator.Key()) == "ANITA" {
+			t.Fatal("Iterator returned expired key")
+		}
+	}
+	//merge drops expired entries
+	err = tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.Merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Exists([]byte("ANITA"))) != 0 {
+		t.Fatal("Expired key has to be removed by merge")
+	}
+	if len(tree.Exists([]byte("BNITA"))) == 0 {
+		t.Fatal("Not expired key has to be kept by merge")
+	}
+}
+
+func TestLsmTree_GcReclaimsExpired(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.file + vlogTailSuffix)
+	defer os.Remove(tree.log.checkpoint)
+	keys := []string{"ANITA", "BNITA", "GNITA"}
+	for i, key := range keys {
+		ttl := time.Hour
+		if i == 1 {
+			ttl = time.Second
+		}
+		if err := tree.PutWithTTL([]byte(key), []byte("DEVELOPER"), ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	before := tree.log.fileSize()
+	if err := tree.CompressVlogEntries(len(keys)); err != nil {
+		t.Fatal(err)
+	}
+	//sstable still has the expired key but its value is not in vlog anymore
+	if after := tree.log.fileSize(); after >= before {
+		t.Fatalf("Expired value wasn't reclaimed, vlog size %d before gc and %d after", before, after)
+	}
+	check := func(tree *LsmTree) {
+		for i, key := range keys {
+			value, found := mustGet(t, tree, []byte(key))
+			if i == 1 && found {
+				t.Fatal("Expired key was found after gc")
+			}
+			if i != 1 && (!found || string(value) != "DEVELOPER") {
+				t.Fatalf("Key %s next to the expired one has value %s after gc", key, value)
+			}
+		}
+	}
+	check(tree)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(100), 30)
+	defer tree.Close()
+	check(tree)
+}
+
+func TestLsmTree_GetWithExpiration(t *testing.T) {
+	tree := InitTestLsmWithMeta(1<<20, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	err := tree.PutWithTTL([]byte("ANITA"), []byte("DEVELOPER"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry([]byte("BNITA"), []byte("DEVELOPER"))
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	check := func() {
+		t.Helper()
+		_, _, expiresAt, found, err := tree.GetWithExpiration([]byte("ANITA"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || time.Until(expiresAt) <= 59*time.Minute || time.Until(expiresAt) > time.Hour {
+			t.Fatalf("unexpected expiration %v", expiresAt)
+		}
+		_, _, expiresAt, found, err = tree.GetWithExpiration([]byte("BNITA"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || !expiresAt.IsZero() {
+			t.Fatalf("value without ttl expires at %v", expiresAt)
+		}
+	}
+	check()
+	//expiration is read from sstables after flush
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func TestLsmTree_CompareAndSwap(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	key := []byte("ANITA")
+	version, err := tree.PutIfAbsent(key, []byte("DEVELOPER"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.PutIfAbsent(key, []byte("DEVELOPER")); err != ErrVersionMismatch {
+		t.Fatal("Put if absent has to fail when key exists")
+	}
+	err = tree.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//version from sstable is the same as the one returned by the write
+	_, currentVersion, found, err := tree.GetWithVersion(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || currentVersion != version {
+		t.Fatalf("Expected version %d but was %d", version, currentVersion)
+	}
+	newVersion, err := tree.CompareAndSwap(key, version, []byte("MANAGER"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion <= version {
+		t.Fatal("Version has to increase after the write")
+	}
+	if _, err := tree.CompareAndSwap(key, version, []byte("CEO")); err != ErrVersionMismatch {
+		t.Fatal("Swap with stale version has to fail")
+	}
+	value, _ := mustGet(t, tree, key)
+	if string(value) != "MANAGER" {
+		t.Fatal("Value was overridden by failed swap")
+	}
+	if err := tree.DeleteIfVersion(key, version); err != ErrVersionMismatch {
+		t.Fatal("Delete with stale version has to fail")
+	}
+	if err := tree.DeleteIfVersion(key, newVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := mustGet(t, tree, key); found {
+		t.Fatal("Key was not deleted")
+	}
+}
+
+func TestLsmTree_Close(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	users, err := tree.OpenColumnFamily("users", 1000, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.sstables) != 1 || len(users.sstables) != 1 {
+		t.Fatalf("Memtables weren't flushed, sstables %v %v", tree.sstables, users.sstables)
+	}
+	if err := tree.Put(&entry); err != ErrClosed {
+		t.Fatalf("Put after close returned %v", err)
+	}
+	if err := tree.Flush(); err != ErrClosed {
+		t.Fatalf("Flush after close returned %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Second close returned %v", err)
+	}
+	//nothing is replayed from vlog after close
+	restored := NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(1000), 30)
+	defer restored.Close()
+	if !restored.memtable.isEmpty() {
+		t.Fatal("Vlog was replayed after close")
+	}
+	value, found := mustGet(t, restored, entry.key)
+	if !found || string(value) != "DEVELOPER" {
+		t.Fatalf("Value wasn't saved, found %v value %s", found, value)
+	}
+}
+
+func TestLsmTree_CompactRange(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	//every sstable has one key, the deleted key leaves an empty intermediate result
+	for _, key := range []string{"A", "B", "C", "D"} {
+		entry := NewEntry([]byte(key), []byte("DEVELOPER"))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"A", "B"} {
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := tree.sstables[3]
+	if err := tree.CompactRange(nil, []byte("D")); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.sstables) != 2 || tree.sstables[1] != last {
+		t.Fatalf("Only sstables of A, B and C had to be merged %v", tree.sstables)
+	}
+	if err := tree.CompactRange([]byte("C"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.sstables) != 1 {
+		t.Fatalf("Expected 1 sstable after full compaction, got %d", len(tree.sstables))
+	}
+	restored := openReadOnly(tree, 1000)
+	for _, key := range []string{"C", "D"} {
+		if _, found := mustGet(t, restored, []byte(key)); !found {
+			t.Fatalf("Key %s is lost after compaction", key)
+		}
+	}
+	if _, found := mustGet(t, restored, []byte("A")); found {
+		t.Fatal("Deleted key is found after compaction")
+	}
+}
+
+//Three sstables, the second one deletes the key of the first one
+func writeDeletedInNewerTable(t *testing.T, tree *LsmTree) {
+	t.Helper()
+	old := NewEntry([]byte("z"), []byte("old"))
+	first := NewEntry([]byte("a"), []byte("1"))
+	second := NewEntry([]byte("b"), []byte("2"))
+	steps := []func() error{
+		func() error { return tree.Put(&old) },
+		tree.Flush,
+		func() error { return tree.Put(&first) },
+		func() error { return tree.Delete(old.key) },
+		tree.Flush,
+		func() error { return tree.Put(&second) },
+		tree.Flush,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLsmTree_MergeAfterReopen(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	writeDeletedInNewerTable(t, tree)
+	created := sstablesOf(tree)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(1000), 30)
+	defer tree.Close()
+	//sstables are read in the order they were created
+	reopened := sstablesOf(tree)
+	if len(reopened) != len(created) {
+		t.Fatalf("Expected %d sstables but was %d", len(created), len(reopened))
+	}
+	for i := range created {
+		if reopened[i] != created[i] {
+			t.Fatalf("Order of sstables changed after reopen %v, created %v", reopened, created)
+		}
+	}
+	//the first two sstables are merged and the latest one stays
+	if err := tree.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	merged := sstablesOf(tree)
+	if len(merged) != 2 || merged[1] != created[2] {
+		t.Fatalf("Only the first two sstables had to be merged %v", merged)
+	}
+	restored := openReadOnly(tree, 1000)
+	if _, found := mustGet(t, restored, []byte("z")); found {
+		t.Fatal("Deleted key is found after merge")
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, found := mustGet(t, restored, []byte(key)); !found {
+			t.Fatalf("Key %s is lost after merge", key)
+		}
+	}
+}
+
+func TestLsmTree_CompactRangeKeepsTombstone(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	writeDeletedInNewerTable(t, tree)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(1000), 30)
+	defer tree.Close()
+	//the oldest sstable with the deleted value is not merged so the tombstone has to stay
+	if err := tree.CompactRange([]byte("a"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if len(sstablesOf(tree)) != 2 {
+		t.Fatalf("Only the last two sstables had to be merged %v", sstablesOf(tree))
+	}
+	restored := openReadOnly(tree, 1000)
+	if value, found := mustGet(t, restored, []byte("z")); found {
+		t.Fatalf("Deleted key is found after compaction with value %s", value)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, found := mustGet(t, restored, []byte(key)); !found {
+			t.Fatalf("Key %s is lost after compaction", key)
+		}
+	}
+}
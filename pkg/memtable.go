@@ -3,7 +3,6 @@ package wiskey
 import (
 	"errors"
 	rbt "github.com/emirpasic/gods/trees/redblacktree"
-	"strings"
 )
 
 const (
@@ -12,13 +11,24 @@ const (
 
 //in memory redblack tree
 type Memtable struct {
-	tree    *rbt.Tree //red black tree where key is a string and value is ValueMeta that shows where value is stored in vlog
-	size    int       // size of in memory redblack tree in bytes
-	maxSize int       //max size of the tree before flushing it
+	tree            *rbt.Tree         //red black tree where key is a byte array and value is ValueMeta that shows where value is stored in vlog
+	comparator      Comparator        //order of keys in the tree
+	rangeTombstones []*rangeTombstone //deleted ranges that were not flushed yet
+	size            int               // size of in memory redblack tree in bytes
+	maxSize         int               //max size of the tree before flushing it
 }
 
+//Memtable with bytewise order of keys
 func NewMemTable(maxSize int) *Memtable {
-	return &Memtable{tree: rbt.NewWithStringComparator(), maxSize: maxSize}
+	return NewMemTableWithComparator(maxSize, BytewiseComparator{})
+}
+
+//Memtable with given order of keys, lsm tree uses the comparator of its memtable
+func NewMemTableWithComparator(maxSize int, comparator Comparator) *Memtable {
+	tree := rbt.NewWith(func(a, b interface{}) int {
+		return comparator.Compare(a.([]byte), b.([]byte))
+	})
+	return &Memtable{tree: tree, comparator: comparator, maxSize: maxSize}
 }
 
 //Flush in memory table to given sstable writer
@@ -26,29 +36,69 @@ func (memtable *Memtable) Flush(writer *SSTableWriter) error {
 	iterator := memtable.tree.Iterator()
 	iterator.Begin()
 	for iterator.Next() {
-		key := iterator.Key().(string)
+		key := iterator.Key().([]byte)
 		valueMeta := iterator.Value().(*ValueMeta)
-		_, err := writer.WriteEntry(NewSStableEntry([]byte(key), valueMeta))
+		_, err := writer.WriteEntry(NewSStableEntry(key, valueMeta))
 		if err != nil {
 			return err
 		}
 	}
+	for _, tombstone := range memtable.rangeTombstones {
+		writer.WriteRangeTombstone(tombstone)
+	}
 	memtable.tree.Clear()
+	memtable.rangeTombstones = nil
 	memtable.size = 0
 	return nil
 }
 
 func (memtable *Memtable) Put(key []byte, value *ValueMeta) error {
-	if strings.Compare(string(key), tombstone) == 0 {
+	if string(key) == tombstone {
 		return errors.New("can't use this key, it's reserved as tombstone")
 	}
-	memtable.tree.Put(string(key), value)
+	memtable.tree.Put(copyKey(key), value)
 	memtable.increaseSize(key)
 	return nil
 }
 
+//Add merge operands to the key
+//previous value stays in memory as a base that operands are applied to
+func (memtable *Memtable) Merge(key []byte, meta *ValueMeta, operands [][]byte) {
+	previous, found := memtable.Get(key)
+	meta.kind = mergeOperandsKind
+	if !found {
+		meta.operands = operands
+	} else if previous.kind == valueKind {
+		meta.operands = operands
+		meta.base = previous
+	} else {
+		meta.operands = append(previous.operands, operands...)
+		meta.base = previous.base
+	}
+	memtable.tree.Put(copyKey(key), meta)
+	memtable.increaseSize(key)
+	for _, operand := range operands {
+		memtable.size += len(operand)
+	}
+}
+
+//Keys with merge operands
+func (memtable *Memtable) merges() ([][]byte, []*ValueMeta) {
+	var keys [][]byte
+	var metas []*ValueMeta
+	iterator := memtable.tree.Iterator()
+	for iterator.Next() {
+		meta := iterator.Value().(*ValueMeta)
+		if meta.kind != valueKind {
+			keys = append(keys, iterator.Key().([]byte))
+			metas = append(metas, meta)
+		}
+	}
+	return keys, metas
+}
+
 func (memtable *Memtable) Get(key []byte) (*ValueMeta, bool) {
-	value, found := memtable.tree.Get(string(key))
+	value, found := memtable.tree.Get(key)
 	if found {
 		return value.(*ValueMeta), true
 	} else {
@@ -56,10 +106,44 @@ func (memtable *Memtable) Get(key []byte) (*ValueMeta, bool) {
 	}
 }
 
+//Remove all keys in the range from the tree and remember the range
+//so it can be flushed to sstable and hide older keys there
+func (memtable *Memtable) DeleteRange(tombstone *rangeTombstone) {
+	for _, key := range memtable.keys(tombstone.start, tombstone.end, 0) {
+		meta, _ := memtable.Get(key)
+		memtable.size -= entrySize(key, meta)
+		memtable.tree.Remove(key)
+	}
+	memtable.rangeTombstones = append(memtable.rangeTombstones, tombstone)
+	memtable.size += len(tombstone.start) + len(tombstone.end) + int64Size
+}
+
+//Sorted keys in [start,end), empty start means there is no lower bound and nil end means there is no upper bound
+//at most limit keys are returned, 0 means no limit
+func (memtable *Memtable) keys(start []byte, end []byte, limit int) [][]byte {
+	var keys [][]byte
+	iterator := memtable.tree.Iterator()
+	for iterator.Next() && (limit == 0 || len(keys) < limit) {
+		key := iterator.Key().([]byte)
+		if len(start) != 0 && memtable.comparator.Compare(key, start) < 0 {
+			continue
+		}
+		if end != nil && memtable.comparator.Compare(key, end) >= 0 {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 func (memtable *Memtable) Size() int {
 	return memtable.tree.Size()
 }
 
+func (memtable *Memtable) isEmpty() bool {
+	return memtable.tree.Empty() && len(memtable.rangeTombstones) == 0
+}
+
 func (memtable *Memtable) isFull() bool {
 	return memtable.size > memtable.maxSize
 }
@@ -68,3 +152,17 @@ func (memtable *Memtable) increaseSize(key []byte) {
 	memtable.size += len(key)
 	memtable.size += uint32Size * 2 //add offset + length from the vlog
 }
+
+//Size of the key with its metadata and merge operands
+func entrySize(key []byte, meta *ValueMeta) int {
+	size := len(key) + uint32Size*2
+	for _, operand := range meta.operands {
+		size += len(operand)
+	}
+	return size
+}
+
+//tree keeps the key so it must not be changed by the caller
+func copyKey(key []byte) []byte {
+	return append([]byte{}, key...)
+}
@@ -41,3 +41,19 @@ func TestMemtable_PutTomb(t *testing.T) {
 		t.Error("Should not allow to save a tomb")
 	}
 }
+
+func TestMemtable_DeleteRangeSize(t *testing.T) {
+	table := NewMemTable(memTableSize)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := table.Put([]byte(key), &ValueMeta{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table.Merge([]byte("d"), &ValueMeta{}, [][]byte{[]byte("12")})
+	table.DeleteRange(&rangeTombstone{start: []byte("a"), end: []byte("z")})
+	//only the range tombstone is left in memory
+	expected := len("a") + len("z") + int64Size
+	if table.size != expected {
+		t.Errorf("Expected size %d after range deletion, got %d", expected, table.size)
+	}
+}
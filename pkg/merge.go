@@ -0,0 +1,115 @@
+package wiskey
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergeOperatorFile = "MERGE_OPERATOR" //file in sstable directory with the name of the operator that wrote merge operands
+)
+
+var (
+	ErrNoMergeOperator = errors.New("merge operator is not configured")
+)
+
+//Merge operator combines merge operands with the existing value of the key
+//so read-modify-write doesn't require to read the value first
+type MergeOperator interface {
+	//Name of the operator
+	Name() string
+	//Apply operands in the order they were written to existing value
+	//existing is nil if the key doesn't exist or was deleted
+	Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+//Treats values and operands as decimal int64 and adds them up
+//existing value that is not a number is treated as 0
+type Int64AddOperator struct{}
+
+func (Int64AddOperator) Name() string {
+	return "int64add"
+}
+
+func (Int64AddOperator) Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	sum, err := strconv.ParseInt(string(existing), 10, 64)
+	if err != nil {
+		sum = 0
+	}
+	for _, operand := range operands {
+		number, err := strconv.ParseInt(string(operand), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("operand %s is not a number", operand)
+		}
+		sum += number
+	}
+	return []byte(strconv.FormatInt(sum, 10)), nil
+}
+
+//Appends operands to existing value separated by delimiter
+type StringAppendOperator struct {
+	Delimiter []byte
+}
+
+func (StringAppendOperator) Name() string {
+	return "append"
+}
+
+func (operator StringAppendOperator) Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	var parts [][]byte
+	if existing != nil {
+		parts = append(parts, existing)
+	}
+	parts = append(parts, operands...)
+	return bytes.Join(parts, operator.Delimiter), nil
+}
+
+//Find built in merge operator by its name
+func MergeOperatorByName(name string) (MergeOperator, error) {
+	switch name {
+	case Int64AddOperator{}.Name():
+		return Int64AddOperator{}, nil
+	case StringAppendOperator{}.Name():
+		return StringAppendOperator{Delimiter: []byte(",")}, nil
+	default:
+		return nil, fmt.Errorf("unknown merge operator %s", name)
+	}
+}
+
+//Save the name of the operator before the first merge operand of the column family is written
+//so the store with operands is never opened without the operator
+func (lsm *LsmTree) saveMergeOperator() error {
+	if lsm.mergeOperator == nil {
+		return ErrNoMergeOperator
+	}
+	if lsm.mergeOperands {
+		return nil
+	}
+	err := ioutil.WriteFile(lsm.sstableDir+"/"+mergeOperatorFile, []byte(lsm.mergeOperator.Name()), 0666)
+	if err != nil {
+		return err
+	}
+	lsm.mergeOperands = true
+	return nil
+}
+
+//Check that the store with merge operands is opened with a merge operator
+//returns true if merge operands were written to the store
+func checkMergeOperator(dir string, operator MergeOperator) (bool, error) {
+	saved, err := ioutil.ReadFile(dir + "/" + mergeOperatorFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if operator == nil {
+		return true, fmt.Errorf("%w: store has merge operands of %s", ErrNoMergeOperator, strings.TrimSpace(string(saved)))
+	}
+	return true, nil
+}
@@ -0,0 +1,139 @@
+package wiskey
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestInt64AddOperator_Merge(t *testing.T) {
+	merged, err := Int64AddOperator{}.Merge([]byte("key"), []byte("10"), [][]byte{[]byte("5"), []byte("-3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(merged) != "12" {
+		t.Fatalf("Expected 12 but was %s", merged)
+	}
+	_, err = Int64AddOperator{}.Merge([]byte("key"), nil, [][]byte{[]byte("NaN")})
+	if err == nil {
+		t.Fatal("Operand that is not a number has to be rejected")
+	}
+}
+
+func TestStringAppendOperator_Merge(t *testing.T) {
+	operator := StringAppendOperator{Delimiter: []byte(",")}
+	merged, _ := operator.Merge([]byte("key"), nil, [][]byte{[]byte("a"), []byte("b")})
+	if string(merged) != "a,b" {
+		t.Fatalf("Expected a,b but was %s", merged)
+	}
+	merged, _ = operator.Merge([]byte("key"), []byte("a"), [][]byte{[]byte("b")})
+	if string(merged) != "a,b" {
+		t.Fatalf("Expected a,b but was %s", merged)
+	}
+}
+
+func TestLsmTree_MergeValue(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	key := []byte("counter")
+	if tree.MergeValue(key, []byte("1")) != ErrNoMergeOperator {
+		t.Fatal("Merge without operator has to fail")
+	}
+	tree.SetMergeOperator(Int64AddOperator{})
+	expect := func(tree *LsmTree, expected string) {
+		value, found := mustGet(t, tree, key)
+		if !found || string(value) != expected {
+			t.Fatalf("Expected %s but was %s", expected, value)
+		}
+	}
+	mergeAndFlush := func(operand string, flush bool) {
+		if err := tree.MergeValue(key, []byte(operand)); err != nil {
+			t.Fatal(err)
+		}
+		if flush {
+			if err := tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	//operands without value
+	mergeAndFlush("1", false)
+	mergeAndFlush("2", false)
+	expect(tree, "3")
+	//operands are restored from vlog
+	restored := openReadOnly(tree, 1000)
+	expect(restored, "3")
+	//operands are flushed to sstable
+	mergeAndFlush("3", true)
+	expect(tree, "6")
+	//operands in memory are applied to the value in sstable
+	mergeAndFlush("4", false)
+	expect(tree, "10")
+	//operands in sstable are applied to the value in older sstable
+	mergeAndFlush("5", true)
+	expect(tree, "15")
+	//put replaces operands
+	entry := NewEntry(key, []byte("100"))
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	mergeAndFlush("1", true)
+	expect(tree, "101")
+	//operands after delete are applied to nothing
+	if err := tree.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	mergeAndFlush("7", true)
+	expect(tree, "7")
+	if tree.MergeValue(key, []byte("NaN")) == nil {
+		t.Fatal("Invalid operand has to be rejected")
+	}
+	//merge collapses operands
+	if len(tree.sstables)%2 != 0 {
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	expect(tree, "7")
+}
+
+func TestOpen_WithoutMergeOperator(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	options := DefaultOptions()
+	options.MergeOperator = Int64AddOperator{}
+	db, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("counter")
+	if err := db.MergeValue(key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	//operands in memtable can't be read without the operator
+	db.SetMergeOperator(nil)
+	if _, _, err := db.Get(key); !errors.Is(err, ErrNoMergeOperator) {
+		t.Fatalf("Expected ErrNoMergeOperator but was %v", err)
+	}
+	db.SetMergeOperator(Int64AddOperator{})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, nil); !errors.Is(err, ErrNoMergeOperator) {
+		t.Fatalf("Store with merge operands was opened without operator, error %v", err)
+	}
+	db, err = Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, _ := mustGet(t, db.LsmTree, key); string(value) != "1" {
+		t.Fatalf("Expected 1 but was %s", value)
+	}
+}
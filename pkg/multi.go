@@ -0,0 +1,149 @@
+package wiskey
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"time"
+)
+
+//Value of one of the keys of MultiGet
+type GetResult struct {
+	Value   []byte
+	Version uint64
+	Found   bool
+}
+
+//Get values of many keys, results are in the order of keys
+//keys are looked up in sorted order so every sstable and vlog are opened once
+//and values are read from vlog in the order of their offsets
+func (lsm *LsmTree) MultiGet(keys [][]byte) ([]GetResult, error) {
+	defer lsm.metrics.getLatency.since(time.Now())
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	results := make([]GetResult, len(keys))
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return lsm.comparator.Compare(keys[order[i]], keys[order[j]]) < 0
+	})
+	//repeated keys are read once, first => the first position of the same key
+	first := make([]int, len(keys))
+	var unique []int
+	for _, i := range order {
+		if len(unique) != 0 && lsm.comparator.Compare(keys[unique[len(unique)-1]], keys[i]) == 0 {
+			first[i] = unique[len(unique)-1]
+			continue
+		}
+		first[i] = i
+		unique = append(unique, i)
+	}
+	//values that have to be read from vlog and positions of their keys
+	var reads []ValueMeta
+	var readKeys []int
+	var notInMemory []int
+	for _, i := range unique {
+		if _, deleted := lsm.deleted[string(keys[i])]; deleted {
+			continue
+		}
+		meta, found := lsm.memtable.Get(keys[i])
+		if !found {
+			notInMemory = append(notInMemory, i)
+		} else if meta.kind != valueKind {
+			//merge operands need the value they are applied to, such keys are read one by one
+			value, version, found, err := lsm.getWithVersion(keys[i])
+			if err != nil {
+				return nil, err
+			}
+			results[i] = GetResult{Value: value, Version: version, Found: found}
+		} else {
+			reads = append(reads, *meta)
+			readKeys = append(readKeys, i)
+		}
+	}
+	//only the latest entry of the key matters unless it's merge operands
+	latest := make(map[int]*ValueMeta)
+	for _, tablePath := range lsm.sstables {
+		if len(notInMemory) == 0 {
+			break
+		}
+		reader, err := os.Open(tablePath)
+		if err != nil {
+			return nil, err
+		}
+		sstable := ReadTable(reader, lsm.log, lsm.comparator)
+		for _, i := range notInMemory {
+			meta, found := sstable.lookup(keys[i])
+			if found && (latest[i] == nil || meta.timestamp > latest[i].timestamp) {
+				latest[i] = meta
+			}
+		}
+		sstable.Close()
+	}
+	for _, i := range notInMemory {
+		meta := latest[i]
+		if meta == nil || lsm.isRangeDeleted(keys[i], meta.timestamp) || isExpired(meta.expiresAt) {
+			continue
+		}
+		if meta.kind == mergeOperandsKind {
+			value, version, found, err := lsm.getFromSStables(keys[i])
+			if err != nil {
+				return nil, err
+			}
+			results[i] = GetResult{Value: value, Version: version, Found: found}
+			continue
+		}
+		reads = append(reads, *meta)
+		readKeys = append(readKeys, i)
+	}
+	entries, err := lsm.log.getAll(reads)
+	if err != nil {
+		return nil, err
+	}
+	for n, entry := range entries {
+		if !isTombstone(entry.value) && !isExpired(entry.expiresAt) {
+			results[readKeys[n]] = GetResult{Value: entry.value, Version: reads[n].timestamp, Found: true}
+		}
+	}
+	for i := range results {
+		results[i] = results[first[i]]
+	}
+	return results, nil
+}
+
+//Save many entries with a single acquisition of the lock
+//entries can be created by NewEntry, NewEntryWithTTL, DeletedEntry and MergeOperandEntry
+//entries are saved in order and if one of them fails the previous ones stay saved
+func (lsm *LsmTree) MultiPut(entries []*TableEntry) error {
+	defer lsm.metrics.putLatency.since(time.Now())
+	for _, entry := range entries {
+		if entry.kind == rangeTombstoneKind {
+			return errors.New("range tombstones have to be saved by DeleteRange")
+		}
+	}
+	if err := lsm.admitWrite(); err != nil {
+		return err
+	}
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	if err := lsm.writable(); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		var err error
+		switch {
+		case entry.kind == mergeOperandKind && lsm.mergeOperator == nil:
+			err = ErrNoMergeOperator
+		case entry.kind == valueKind && isTombstone(entry.value):
+			err = lsm.delete(entry.key)
+		default:
+			err = lsm.put(entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package wiskey
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLsmTree_MultiGet(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	tree.SetMergeOperator(Int64AddOperator{})
+	//small memtable so keys are spread between sstables and memtable
+	for i := 0; i < 30; i++ {
+		entry := NewEntry([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%d", i)))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Delete([]byte("key03")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.DeleteRange([]byte("key10"), []byte("key12")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PutWithTTL([]byte("key20"), []byte("expired"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.MergeValue([]byte("counter"), []byte("5")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.MergeValue([]byte("counter"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	keys := [][]byte{[]byte("key29"), []byte("key03"), []byte("missing"), []byte("key00"), []byte("key11"),
+		[]byte("key20"), []byte("counter"), []byte("key15"), []byte("key00")}
+	results, err := tree.MultiGet(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(keys) {
+		t.Fatalf("Expected %d results but got %d", len(keys), len(results))
+	}
+	for i, key := range keys {
+		value, version, found, err := tree.GetWithVersion(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := results[i]
+		if result.Found != found || string(result.Value) != string(value) || result.Version != version {
+			t.Errorf("MultiGet of %s returned %s %d %v but Get returned %s %d %v", key, result.Value, result.Version, result.Found, value, version, found)
+		}
+	}
+	if !results[0].Found || results[1].Found || results[2].Found || results[4].Found || results[5].Found {
+		t.Errorf("Unexpected results %v", results)
+	}
+	if string(results[6].Value) != "7" {
+		t.Errorf("Expected merged value 7 but was %s", results[6].Value)
+	}
+}
+
+func TestLsmTree_MultiPut(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	old := NewEntry([]byte("old"), []byte("value"))
+	if err := tree.Put(&old); err != nil {
+		t.Fatal(err)
+	}
+	var entries []*TableEntry
+	for i := 0; i < 20; i++ {
+		entry := NewEntry([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%d", i)))
+		entries = append(entries, &entry)
+	}
+	entries = append(entries, DeletedEntry([]byte("old")))
+	if err := tree.MultiPut(entries); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		value, found := mustGet(t, tree, []byte(fmt.Sprintf("key%02d", i)))
+		if !found || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Key %d wasn't saved", i)
+		}
+	}
+	if _, found := mustGet(t, tree, []byte("old")); found {
+		t.Error("Deleted key was found")
+	}
+	if tree.MultiPut([]*TableEntry{MergeOperandEntry([]byte("counter"), []byte("1"))}) != ErrNoMergeOperator {
+		t.Error("Merge operand without merge operator has to be rejected")
+	}
+}
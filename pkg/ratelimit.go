@@ -0,0 +1,74 @@
+package wiskey
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+//Token bucket that limits bytes per second written by flush and compaction
+//the bucket holds at most one second of tokens, bigger writes wait for the missing tokens
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64 //tokens added per second
+	tokens float64 //can be negative if the last write took more than the bucket has
+	last   time.Time
+}
+
+//Limiter for given bytes per second, nil limiter doesn't limit anything
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+//Take n tokens and sleep until the bucket has them, 0 waits only for the tokens taken by earlier writes
+func (limiter *rateLimiter) wait(n int) {
+	time.Sleep(limiter.take(n))
+}
+
+//Take n tokens without waiting, returns the time until the bucket has them
+func (limiter *rateLimiter) take(n int) time.Duration {
+	if limiter == nil {
+		return 0
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.rate {
+		limiter.tokens = limiter.rate
+	}
+	limiter.last = now
+	limiter.tokens -= float64(n)
+	if limiter.tokens < 0 {
+		return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+	}
+	return 0
+}
+
+//Writer that takes tokens from the limiter before every write without waiting for them
+//flush and merge write while they hold the lock of the tree, so the tokens are paid later by writes
+//before they take the lock and by the merge job between merges, reads are never delayed
+type limitedWriter struct {
+	writer  io.WriteCloser
+	limiter *rateLimiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	w.limiter.take(len(p))
+	return w.writer.Write(p)
+}
+
+func (w *limitedWriter) Close() error {
+	return w.writer.Close()
+}
+
+//sstable writer syncs files that support it
+func (w *limitedWriter) Sync() error {
+	if syncer, ok := w.writer.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
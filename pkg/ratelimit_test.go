@@ -0,0 +1,61 @@
+package wiskey
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(10000)
+	start := time.Now()
+	//the bucket is full at the start
+	limiter.wait(10000)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("Limiter waited for tokens that it had")
+	}
+	limiter.wait(5000)
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("Limiter didn't wait for tokens, elapsed %v", elapsed)
+	}
+	//nil limiter doesn't limit
+	var unlimited *rateLimiter
+	unlimited.wait(1 << 30)
+}
+
+func TestRateLimiter_OutsideLock(t *testing.T) {
+	tree := InitTestLsmWithMeta(100000, 30)
+	defer tree.Close()
+	for i := 0; i < 1000; i++ {
+		entry := NewEntry([]byte(fmt.Sprintf("key%06d", i)), []byte("value"))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree.state.limiter = newRateLimiter(20000)
+	//flush writes without waiting while it holds the lock
+	start := time.Now()
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Fatalf("Flush waited for tokens under the lock, elapsed %v", elapsed)
+	}
+	//reads don't pay for the flush
+	start = time.Now()
+	if _, found := mustGet(t, tree, []byte("key000500")); !found {
+		t.Fatal("Flushed key wasn't found")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Read waited for tokens, elapsed %v", elapsed)
+	}
+	//the next write waits for the tokens before it takes the lock
+	start = time.Now()
+	entry := NewEntry([]byte("key"), []byte("value"))
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("Write didn't wait for tokens of the flush, elapsed %v", elapsed)
+	}
+}
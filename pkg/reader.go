@@ -20,9 +20,11 @@ type SSTableReader struct {
 //All methods have to be called in the following order
 //1. readKeyLength
 //2. readKey
-//3. read timestamp
-//4. read value offset
-//5. read value length
+//3. read kind
+//4. read timestamp
+//5. read expiration time
+//6. read value offset
+//7. read value length
 func NewReader(reader *os.File, offset int64) *SSTableReader {
 	reader.Seek(offset, 0)
 	return &SSTableReader{reader: reader}
@@ -43,12 +45,25 @@ func (tableReader *SSTableReader) readKey(keyLength uint32) []byte {
 	return keyBuffer
 }
 
+func (tableReader *SSTableReader) readKind() byte {
+	tableReader.offset += 1
+	kind := make([]byte, 1)
+	tableReader.reader.Read(kind)
+	return kind[0]
+}
+
 func (tableReader *SSTableReader) readTimestamp() uint64 {
 	tableReader.offset += int64Size
 	timestamp := make([]byte, int64Size)
 	tableReader.reader.Read(timestamp)
 	return binary.BigEndian.Uint64(timestamp)
 }
+func (tableReader *SSTableReader) readExpiresAt() uint64 {
+	tableReader.offset += int64Size
+	expiresAt := make([]byte, int64Size)
+	tableReader.reader.Read(expiresAt)
+	return binary.BigEndian.Uint64(expiresAt)
+}
 func (tableReader *SSTableReader) readValueOffset() uint32 {
 	tableReader.offset += uint32Size
 	valueOffset := make([]byte, uint32Size)
@@ -63,3 +78,16 @@ func (tableReader *SSTableReader) readValueLength() uint32 {
 	tableReader.reader.Read(valueLength)
 	return binary.BigEndian.Uint32(valueLength)
 }
+
+//Read the whole entry
+func (tableReader *SSTableReader) readEntry() *sstableEntry {
+	key := tableReader.readKey(tableReader.readKeyLength())
+	return &sstableEntry{
+		key:         key,
+		kind:        tableReader.readKind(),
+		timeStamp:   tableReader.readTimestamp(),
+		expiresAt:   tableReader.readExpiresAt(),
+		valueOffset: tableReader.readValueOffset(),
+		valueLength: tableReader.readValueLength(),
+	}
+}
@@ -3,30 +3,84 @@ package wiskey
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type indexes []tableIndex
 
 const (
-	sstableExtension  = ".sstable$"
-	sstableFileLength = 10
+	sstableExtension = ".sstable$"
+	sstableName      = "%010d-%06d.sstable" //sequence and generation of the sstable
 )
 
 type SSTable struct {
-	footer  *Footer
-	indexes indexes
-	reader  *os.File
-	log     *vlog
+	footer          *Footer
+	indexes         indexes
+	rangeTombstones []*rangeTombstone
+	reader          *os.File
+	log             *vlog
+	comparator      Comparator //order of keys in the table
+}
+
+//Position of the sstable in the order of creation
+//flushed sstable gets the next sequence, merged sstable takes the sequence of the latest merged one
+//and the next generation so it goes after its inputs
+type tableOrder struct {
+	sequence   uint64
+	generation uint64
+}
+
+func (order tableOrder) fileName() string {
+	return fmt.Sprintf(sstableName, order.sequence, order.generation)
+}
+
+//Order of the sstable from its file name, sstables with random names were created before the order
+//was saved and they go first
+func parseTableOrder(path string) (tableOrder, bool) {
+	parts := strings.Split(strings.TrimSuffix(filepath.Base(path), ".sstable"), "-")
+	if len(parts) != 2 {
+		return tableOrder{}, false
+	}
+	sequence, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return tableOrder{}, false
+	}
+	generation, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return tableOrder{}, false
+	}
+	return tableOrder{sequence: sequence, generation: generation}, true
+}
+
+func (order tableOrder) before(other tableOrder) bool {
+	if order.sequence != other.sequence {
+		return order.sequence < other.sequence
+	}
+	return order.generation < other.generation
+}
+
+//Sort sstable paths from the oldest to the latest one
+func sortTables(paths []string) {
+	sort.SliceStable(paths, func(i, j int) bool {
+		first, _ := parseTableOrder(paths[i])
+		second, _ := parseTableOrder(paths[j])
+		return first.before(second)
+	})
 }
 
 //Constructor
-func ReadTable(reader *os.File, log *vlog) *SSTable {
+func ReadTable(reader *os.File, log *vlog, comparator Comparator) *SSTable {
 	stats, _ := reader.Stat()
 	//read footer
 	footer := readFooter(stats, reader)
 	indexes := readIndexes(stats, reader, *footer)
-	return &SSTable{footer: footer, indexes: indexes, reader: reader, log: log}
+	rangeTombstones := readRangeTombstones(stats, reader, *footer)
+	return &SSTable{footer: footer, indexes: indexes, rangeTombstones: rangeTombstones, reader: reader, log: log, comparator: comparator}
 }
 
 func OverrideVlogOffset(position int, meta *ValueMeta, file *os.File) error {
@@ -43,7 +97,11 @@ func OverrideVlogOffset(position int, meta *ValueMeta, file *os.File) error {
 	if err := binary.Write(buffer, binary.BigEndian, meta.length); err != nil {
 		return err
 	}
-	_, err := file.WriteAt(buffer.Bytes(), int64(index.Offset))
+	//value offset goes after key, kind, timestamp and expiration time of the entry
+	tableReader := NewReader(file, int64(index.Offset))
+	keyLength := tableReader.readKeyLength()
+	valuePosition := int64(index.Offset) + int64(uint32Size) + int64(keyLength) + 1 + 2*int64Size
+	_, err := file.WriteAt(buffer.Bytes(), valuePosition)
 	if err != nil {
 		return err
 	}
@@ -54,7 +112,34 @@ func (table *SSTable) Close() {
 	table.reader.Close()
 }
 
-func (table *SSTable) Get(key []byte) (*SearchEntry, bool) {
+func (table *SSTable) Get(key []byte) (*SearchEntry, bool, error) {
+	tableReader, found := table.locate(key)
+	if !found {
+		return nil, false, nil
+	}
+	entry, err := table.fetchFromVlog(tableReader)
+	if err != nil {
+		return nil, false, err
+	}
+	return entry, true, nil
+}
+
+//Metadata of the key without reading its value from vlog
+func (table *SSTable) lookup(key []byte) (*ValueMeta, bool) {
+	tableReader, found := table.locate(key)
+	if !found {
+		return nil, false
+	}
+	meta := table.readMeta(tableReader)
+	return &meta, true
+}
+
+//Reader that is positioned after the key, false if the key isn't in the table
+func (table *SSTable) locate(key []byte) (*SSTableReader, bool) {
+	//table can have only range tombstones
+	if len(table.indexes) == 0 {
+		return nil, false
+	}
 	//try smallest key
 	firstIndex := table.indexes[0]
 	compare, value := table.find(key, firstIndex)
@@ -76,8 +161,45 @@ func (table *SSTable) Get(key []byte) (*SearchEntry, bool) {
 	if compare > 0 {
 		return nil, false
 	}
-	search, found, _ := table.binarySearch(key)
-	return search, found
+	tableReader, found, _ := table.binarySearch(key)
+	return tableReader, found
+}
+
+//Sorted keys in [start,end), empty start means there is no lower bound and nil end means there is no upper bound
+//at most limit keys are returned, 0 means no limit
+func (table *SSTable) keys(start []byte, end []byte, limit int) [][]byte {
+	var keys [][]byte
+	for _, index := range table.indexes {
+		tableReader := NewReader(table.reader, int64(index.Offset))
+		for tableReader.offset != index.BlockLength {
+			if limit != 0 && len(keys) == limit {
+				return keys
+			}
+			key := tableReader.readEntry().key
+			if end != nil && table.comparator.Compare(key, end) >= 0 {
+				return keys
+			}
+			if len(start) == 0 || table.comparator.Compare(key, start) >= 0 {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+//The smallest and the biggest key, false if the table has only range tombstones
+func (table *SSTable) bounds() ([]byte, []byte, bool) {
+	if len(table.indexes) == 0 {
+		return nil, nil, false
+	}
+	first := NewReader(table.reader, int64(table.indexes[0].Offset)).readEntry().key
+	lastIndex := table.indexes[len(table.indexes)-1]
+	tableReader := NewReader(table.reader, int64(lastIndex.Offset))
+	var last []byte
+	for tableReader.offset != lastIndex.BlockLength {
+		last = tableReader.readEntry().key
+	}
+	return first, last, true
 }
 
 func (table *SSTable) KeyAtIndex(key []byte) (bool, int) {
@@ -86,10 +208,10 @@ func (table *SSTable) KeyAtIndex(key []byte) (bool, int) {
 }
 
 //Tries to find given key in the sstable
-//Returns 1. value byte array or nil if not found
+//Returns 1. reader that is positioned after the key or nil if not found
 //2. bool true if found,false otherwise
 //3. at which index this key was found
-func (table *SSTable) binarySearch(key []byte) (*SearchEntry, bool, int) {
+func (table *SSTable) binarySearch(key []byte) (*SSTableReader, bool, int) {
 	left := 0
 	right := len(table.indexes) - 1
 	for left < right {
@@ -100,9 +222,9 @@ func (table *SSTable) binarySearch(key []byte) (*SearchEntry, bool, int) {
 		fileKeyLength := tableReader.readKeyLength()
 		//read actual key from the file
 		keyBuffer := tableReader.readKey(fileKeyLength)
-		compare := bytes.Compare(key, keyBuffer)
+		compare := table.comparator.Compare(key, keyBuffer)
 		if compare == 0 {
-			return table.fetchFromVlog(tableReader), true, middle
+			return tableReader, true, middle
 		} else if compare > 0 {
 			left = middle + 1
 		} else {
@@ -114,48 +236,57 @@ func (table *SSTable) binarySearch(key []byte) (*SearchEntry, bool, int) {
 	for tableReader.offset != index.BlockLength {
 		keyLength := tableReader.readKeyLength()
 		keyFromFile := tableReader.readKey(keyLength)
-		if bytes.Compare(key, keyFromFile) == 0 {
-			return table.fetchFromVlog(tableReader), true, left
+		if table.comparator.Compare(key, keyFromFile) == 0 {
+			return tableReader, true, left
 		}
+		tableReader.readKind()
 		tableReader.readTimestamp()
+		tableReader.readExpiresAt()
 		tableReader.readValueOffset()
 		tableReader.readValueLength()
 	}
 	return nil, false, -1
 }
 
-func (table *SSTable) fetchFromVlog(tableReader *SSTableReader) *SearchEntry {
+//expired value isn't read because vlog gc can remove it
+func (table *SSTable) fetchFromVlog(tableReader *SSTableReader) (*SearchEntry, error) {
+	meta := table.readMeta(tableReader)
+	if isExpired(meta.expiresAt) {
+		return &SearchEntry{kind: meta.kind, timestamp: meta.timestamp, expiresAt: meta.expiresAt}, nil
+	}
+	get, err := table.log.Get(meta)
+	if err != nil {
+		return nil, err
+	}
+	return &SearchEntry{key: get.key, value: get.value, kind: meta.kind, timestamp: meta.timestamp, expiresAt: meta.expiresAt}, nil
+}
+
+//Read the rest of the entry after its key
+func (table *SSTable) readMeta(tableReader *SSTableReader) ValueMeta {
+	kind := tableReader.readKind()
 	timestamp := tableReader.readTimestamp()
+	expiresAt := tableReader.readExpiresAt()
 	offset := tableReader.readValueOffset()
 	length := tableReader.readValueLength()
-	get, err := table.log.Get(ValueMeta{length: length, offset: offset})
-	if err != nil {
-		panic(err)
-	}
-	return &SearchEntry{key: get.key, value: get.value, timestamp: timestamp}
+	return ValueMeta{length: length, offset: offset, timestamp: timestamp, expiresAt: expiresAt, kind: kind}
 }
 
-func (table *SSTable) find(key []byte, index tableIndex) (int, *SearchEntry) {
-	searchKeyLength := uint32(len(key))
+func (table *SSTable) find(key []byte, index tableIndex) (int, *SSTableReader) {
 	tableReader := NewReader(table.reader, int64(index.Offset))
 	fileKeyLength := tableReader.readKeyLength()
-	//if keys length are not the same then don't make sense to compare an actual key
-	if searchKeyLength != fileKeyLength {
-		return len(key) - int(fileKeyLength), nil
-	}
 	//read actual key from the file
-	keyBuffer := tableReader.readKey(searchKeyLength)
-	compare := bytes.Compare(key, keyBuffer)
+	keyBuffer := tableReader.readKey(fileKeyLength)
+	compare := table.comparator.Compare(key, keyBuffer)
 	//they are equal
 	if compare == 0 {
-		return 0, table.fetchFromVlog(tableReader)
+		return 0, tableReader
 	}
 	return compare, nil
 }
 
 //Read the index from the file to in memory slice
 func readIndexes(stats os.FileInfo, reader *os.File, footer Footer) indexes {
-	buffer := make([]byte, stats.Size()-int64(footer.indexOffset)-footerSize)
+	buffer := make([]byte, footer.rangeTombstoneOffset-footer.indexOffset)
 	reader.ReadAt(buffer, int64(footer.indexOffset))
 	start := 0
 	end := len(buffer)
@@ -169,8 +300,32 @@ func readIndexes(stats os.FileInfo, reader *os.File, footer Footer) indexes {
 	return indexes
 }
 
+//Read range tombstones from the file to in memory slice
+func readRangeTombstones(stats os.FileInfo, reader *os.File, footer Footer) []*rangeTombstone {
+	buffer := make([]byte, stats.Size()-int64(footer.rangeTombstoneOffset)-footerSize)
+	reader.ReadAt(buffer, int64(footer.rangeTombstoneOffset))
+	start := 0
+	var tombstones []*rangeTombstone
+	for start != len(buffer) {
+		startLength := int(binary.BigEndian.Uint32(buffer[start : start+uint32Size]))
+		start += uint32Size
+		rangeStart := buffer[start : start+startLength]
+		start += startLength
+		endLength := int(binary.BigEndian.Uint32(buffer[start : start+uint32Size]))
+		start += uint32Size
+		rangeEnd := buffer[start : start+endLength]
+		start += endLength
+		timestamp := binary.BigEndian.Uint64(buffer[start : start+int64Size])
+		start += int64Size
+		tombstones = append(tombstones, &rangeTombstone{start: rangeStart, end: rangeEnd, timestamp: timestamp})
+	}
+	return tombstones
+}
+
 type SearchEntry struct {
 	key       []byte
 	value     []byte
+	kind      byte
 	timestamp uint64
+	expiresAt uint64
 }
@@ -0,0 +1,154 @@
+package wiskey
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	StallVlogGarbage = "vlog garbage" //reason of the stall that only vlog gc can remove, merge doesn't reclaim vlog
+)
+
+var (
+	ErrWriteStall = errors.New("writes are stopped until compaction catches up")
+)
+
+//Triggers of write stalls based on compaction debt
+//writes are delayed after slowdown trigger and rejected with ErrWriteStall after stop trigger
+//zero disables the trigger
+type WriteStallOptions struct {
+	SlowdownSStables     int           //number of sstables of column family
+	StopSStables         int           //number of sstables of column family
+	SlowdownPendingBytes int64         //size of sstables of column family that wait for the merge
+	StopPendingBytes     int64         //size of sstables of column family that wait for the merge
+	SlowdownVlogGarbage  int64         //size of overwritten, deleted and expired values in vlog
+	StopVlogGarbage      int64         //size of overwritten, deleted and expired values in vlog
+	SlowdownDelay        time.Duration //delay of every write during slowdown
+}
+
+//State of the writes
+type StallCondition int
+
+const (
+	StallNone StallCondition = iota
+	StallSlowdown
+	StallStop
+)
+
+func (condition StallCondition) String() string {
+	switch condition {
+	case StallSlowdown:
+		return "slowdown"
+	case StallStop:
+		return "stop"
+	default:
+		return "none"
+	}
+}
+
+//Stall of the writes and compaction debt that causes it
+type StallStats struct {
+	Condition              StallCondition
+	Reason                 string        //trigger that caused the stall, empty if there is no stall
+	RetryAfter             time.Duration //time until the next merge that can remove the stall, 0 if only vlog gc can remove it
+	SStables               int
+	PendingCompactionBytes int64
+	VlogGarbage            int64 //counted since the store was opened
+}
+
+func (options *WriteStallOptions) validate() error {
+	triggers := []struct {
+		name           string
+		slowdown, stop int64
+	}{
+		{"sstables", int64(options.SlowdownSStables), int64(options.StopSStables)},
+		{"pending compaction bytes", options.SlowdownPendingBytes, options.StopPendingBytes},
+		{StallVlogGarbage, options.SlowdownVlogGarbage, options.StopVlogGarbage},
+	}
+	for _, trigger := range triggers {
+		if trigger.slowdown < 0 || trigger.stop < 0 {
+			return fmt.Errorf("%w: %s trigger is negative", ErrInvalidOptions, trigger.name)
+		}
+		if trigger.slowdown != 0 && trigger.stop != 0 && trigger.slowdown >= trigger.stop {
+			return fmt.Errorf("%w: %s slowdown trigger has to be smaller than stop trigger", ErrInvalidOptions, trigger.name)
+		}
+	}
+	//there is always an sstable after the first flush so one sstable would stop writes forever
+	if options.StopSStables != 0 && options.StopSStables < 2 {
+		return fmt.Errorf("%w: sstables stop trigger has to be at least 2", ErrInvalidOptions)
+	}
+	if options.SlowdownDelay < 0 {
+		return fmt.Errorf("%w: slowdown delay is negative", ErrInvalidOptions)
+	}
+	return nil
+}
+
+//Current stall of column family, caller has to hold the lock
+func (lsm *LsmTree) stallStats() StallStats {
+	options := lsm.state.stallOptions
+	stats := StallStats{
+		SStables:               len(lsm.sstables),
+		PendingCompactionBytes: lsm.pendingCompactionBytes,
+		VlogGarbage:            lsm.log.garbage,
+	}
+	triggers := []struct {
+		name           string
+		value          int64
+		slowdown, stop int64
+	}{
+		{"sstables", int64(stats.SStables), int64(options.SlowdownSStables), int64(options.StopSStables)},
+		{"pending compaction bytes", stats.PendingCompactionBytes, options.SlowdownPendingBytes, options.StopPendingBytes},
+		{StallVlogGarbage, stats.VlogGarbage, options.SlowdownVlogGarbage, options.StopVlogGarbage},
+	}
+	for _, trigger := range triggers {
+		if trigger.stop != 0 && trigger.value >= trigger.stop {
+			stats.Condition = StallStop
+			stats.Reason = trigger.name
+			break
+		}
+		if stats.Condition == StallNone && trigger.slowdown != 0 && trigger.value >= trigger.slowdown {
+			stats.Condition = StallSlowdown
+			stats.Reason = trigger.name
+		}
+	}
+	if stats.Condition != StallNone && stats.Reason != StallVlogGarbage {
+		stats.RetryAfter = time.Until(lsm.nextMerge)
+		if stats.RetryAfter < time.Second {
+			stats.RetryAfter = time.Second
+		}
+	}
+	return stats
+}
+
+//Delay or reject the write if compaction can't keep up, it's called before the write takes the lock
+func (lsm *LsmTree) admitWrite() error {
+	lsm.rwm.RLock()
+	stats := lsm.stallStats()
+	lsm.rwm.RUnlock()
+	switch stats.Condition {
+	case StallStop:
+		if stats.Reason == StallVlogGarbage {
+			return fmt.Errorf("%w: %s, vlog gc has to be run", ErrWriteStall, stats.Reason)
+		}
+		return fmt.Errorf("%w: %s", ErrWriteStall, stats.Reason)
+	case StallSlowdown:
+		time.Sleep(lsm.state.stallOptions.SlowdownDelay)
+	}
+	//wait for the tokens that flush and merge took while they held the lock
+	lsm.state.limiter.wait(0)
+	return nil
+}
+
+//Size of sstables that the next merge rewrites, caller has to hold the write lock
+func (lsm *LsmTree) updateCompactionDebt() {
+	var pending int64
+	for _, tablePath := range lsm.mergeInputs() {
+		info, err := os.Stat(tablePath)
+		if err == nil {
+			pending += info.Size()
+		}
+	}
+	lsm.pendingCompactionBytes = pending
+}
@@ -0,0 +1,155 @@
+package wiskey
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLsmTree_WriteStall(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	options := DefaultOptions()
+	options.MemtableSize = 1000
+	options.WriteStall = WriteStallOptions{SlowdownSStables: 2, StopSStables: 3, SlowdownDelay: 50 * time.Millisecond}
+	db, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	putAndFlush := func(key string) error {
+		entry := NewEntry([]byte(key), []byte("DEVELOPER"))
+		if err := db.Put(&entry); err != nil {
+			return err
+		}
+		return db.Flush()
+	}
+	if err := putAndFlush("ANITA"); err != nil {
+		t.Fatal(err)
+	}
+	if stall := db.Stats().WriteStall; stall.Condition != StallNone {
+		t.Fatalf("Writes are stalled with one sstable %+v", stall)
+	}
+	if err := putAndFlush("BNITA"); err != nil {
+		t.Fatal(err)
+	}
+	stall := db.Stats().WriteStall
+	if stall.Condition != StallSlowdown || stall.Reason != "sstables" || stall.PendingCompactionBytes == 0 {
+		t.Fatalf("Writes are not slowed down %+v", stall)
+	}
+	start := time.Now()
+	if err := putAndFlush("GNITA"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("Write wasn't delayed")
+	}
+	entry := NewEntry([]byte("NNITA"), []byte("DEVELOPER"))
+	if err := db.Put(&entry); !errors.Is(err, ErrWriteStall) {
+		t.Fatalf("Write wasn't stopped, error %v", err)
+	}
+	if stall := db.Stats().WriteStall; stall.Condition != StallStop || stall.RetryAfter < time.Second {
+		t.Fatalf("Writes are not stopped %+v", stall)
+	}
+	//merge leaves two sstables out of three
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(&entry); err != nil {
+		t.Fatalf("Write was rejected after merge, error %v", err)
+	}
+	for _, key := range []string{"ANITA", "BNITA", "GNITA", "NNITA"} {
+		if _, found := mustGet(t, db.LsmTree, []byte(key)); !found {
+			t.Fatalf("Key %s is lost after merge", key)
+		}
+	}
+}
+
+func TestLsmTree_VlogGarbage(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	tree.state.stallOptions = WriteStallOptions{StopVlogGarbage: 1}
+	entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	//overwritten value is garbage
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if garbage := tree.Stats().WriteStall.VlogGarbage; garbage == 0 {
+		t.Fatal("Overwritten value isn't counted as garbage")
+	}
+	if err := tree.Put(&entry); !errors.Is(err, ErrWriteStall) {
+		t.Fatalf("Write wasn't stopped, error %v", err)
+	}
+	//merge doesn't reclaim vlog so there is no time to retry after
+	if stall := tree.Stats().WriteStall; stall.Reason != StallVlogGarbage || stall.RetryAfter != 0 {
+		t.Fatalf("Stall has to wait for vlog gc %+v", stall)
+	}
+}
+
+func TestWriteStallOptions_Validate(t *testing.T) {
+	invalid := []WriteStallOptions{
+		{StopSStables: 1},
+		{SlowdownSStables: 3, StopSStables: 3},
+		{SlowdownPendingBytes: 10, StopPendingBytes: 5},
+		{SlowdownVlogGarbage: -1},
+		{SlowdownDelay: -time.Second},
+	}
+	for _, options := range invalid {
+		if err := options.validate(); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("Invalid options %+v were accepted, error %v", options, err)
+		}
+	}
+	valid := WriteStallOptions{SlowdownSStables: 1, StopSStables: 2, StopVlogGarbage: 1}
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLsmTree_MergeOddTables(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	old := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	if err := tree.Put(&old); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.DeleteRange([]byte("A"), []byte("B")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry([]byte("BNITA"), []byte("DEVELOPER"))
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	//the first sstable stays but the range tombstone from merged sstables still covers it
+	tree.sstables = append(tree.sstables[1:], tree.sstables[0])
+	if err := tree.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.sstables) != 2 {
+		t.Fatalf("Expected 2 sstables after merge, got %d", len(tree.sstables))
+	}
+	restored := openReadOnly(tree, 1000)
+	if _, found := mustGet(t, restored, old.key); found {
+		t.Fatal("Range deleted key is found after merge")
+	}
+	if _, found := mustGet(t, restored, entry.key); !found {
+		t.Fatal("Key is lost after merge")
+	}
+}
@@ -0,0 +1,164 @@
+package wiskey
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	//upper bounds of latency buckets in seconds, from 10 microseconds to 10 seconds
+	latencyBuckets = []float64{0.00001, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+)
+
+//Statistics of column family, vlog statistics are shared by all column families
+type Stats struct {
+	PutLatency    Histogram
+	GetLatency    Histogram
+	DeleteLatency Histogram
+	MemtableSize  int //size of memtable in bytes
+	Flushes       uint64
+	FlushDuration Histogram
+	Merges        uint64
+	MergeDuration Histogram
+	SStables      int
+	SStablesSize  int64 //size of all sstables in bytes
+	VlogSize      int64
+	VlogGarbage   int64 //size of overwritten, deleted and expired values since the vlog was opened
+	WriteStall    StallStats
+	Background    BackgroundStats //shared by all column families
+}
+
+//Snapshot of histogram
+type Histogram struct {
+	Buckets []Bucket
+	Count   uint64
+	Sum     float64 //sum of all observed values in seconds
+}
+
+//Amount of observations that are less or equal than upper bound
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+//Latencies and durations of column family
+type metrics struct {
+	putLatency    *histogram
+	getLatency    *histogram
+	deleteLatency *histogram
+	flushDuration *histogram
+	mergeDuration *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		putLatency:    newHistogram(latencyBuckets),
+		getLatency:    newHistogram(latencyBuckets),
+		deleteLatency: newHistogram(latencyBuckets),
+		flushDuration: newHistogram(latencyBuckets),
+		mergeDuration: newHistogram(latencyBuckets),
+	}
+}
+
+type histogram struct {
+	mutex  sync.Mutex
+	bounds []float64
+	counts []uint64 //count of every bucket, the last one is for values bigger than all bounds
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+//Observe time passed since start
+func (h *histogram) since(start time.Time) {
+	h.observe(time.Since(start).Seconds())
+}
+
+func (h *histogram) observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	index := len(h.bounds)
+	for i, bound := range h.bounds {
+		if value <= bound {
+			index = i
+			break
+		}
+	}
+	h.counts[index]++
+	h.sum += value
+}
+
+//Snapshot with cumulative buckets
+func (h *histogram) snapshot() Histogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	snapshot := Histogram{Sum: h.sum}
+	for i, bound := range h.bounds {
+		snapshot.Count += h.counts[i]
+		snapshot.Buckets = append(snapshot.Buckets, Bucket{UpperBound: bound, Count: snapshot.Count})
+	}
+	snapshot.Count += h.counts[len(h.bounds)]
+	return snapshot
+}
+
+//Current statistics of column family
+func (lsm *LsmTree) Stats() Stats {
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	stats := Stats{
+		PutLatency:    lsm.metrics.putLatency.snapshot(),
+		GetLatency:    lsm.metrics.getLatency.snapshot(),
+		DeleteLatency: lsm.metrics.deleteLatency.snapshot(),
+		MemtableSize:  lsm.memtable.Size(),
+		FlushDuration: lsm.metrics.flushDuration.snapshot(),
+		MergeDuration: lsm.metrics.mergeDuration.snapshot(),
+		SStables:      len(lsm.sstables),
+		VlogSize:      lsm.log.fileSize(),
+		VlogGarbage:   lsm.log.garbage,
+		WriteStall:    lsm.stallStats(),
+		Background:    lsm.state.background,
+	}
+	stats.Flushes = stats.FlushDuration.Count
+	stats.Merges = stats.MergeDuration.Count
+	for _, tablePath := range lsm.sstables {
+		info, err := os.Stat(tablePath)
+		if err == nil {
+			stats.SStablesSize += info.Size()
+		}
+	}
+	return stats
+}
+
+//Sstable file with its key range
+type SStableInfo struct {
+	Path     string
+	Size     int64
+	FirstKey []byte //nil if sstable has only range tombstones
+	LastKey  []byte
+}
+
+//Sstables of column family from the oldest to the newest
+func (lsm *LsmTree) SStables() ([]SStableInfo, error) {
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	var sstables []SStableInfo
+	for _, tablePath := range lsm.sstables {
+		reader, err := os.Open(tablePath)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := reader.Stat()
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		table := ReadTable(reader, lsm.log, lsm.comparator)
+		first, last, _ := table.bounds()
+		table.Close()
+		sstables = append(sstables, SStableInfo{Path: tablePath, Size: stat.Size(), FirstKey: first, LastKey: last})
+	}
+	return sstables, nil
+}
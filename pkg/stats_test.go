@@ -0,0 +1,84 @@
+package wiskey
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLsmTree_Stats(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if stats := tree.Stats(); stats.MemtableSize == 0 || stats.VlogSize == 0 {
+		t.Fatalf("Memtable and vlog sizes are not reported %+v", stats)
+	}
+	for i := 0; i < 2; i++ {
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustGet(t, tree, entry.key)
+	if err := tree.Delete([]byte("BNITA")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	stats := tree.Stats()
+	if stats.PutLatency.Count != 3 || stats.GetLatency.Count != 1 || stats.DeleteLatency.Count != 1 {
+		t.Fatalf("Wrong latency counts put %d get %d delete %d", stats.PutLatency.Count, stats.GetLatency.Count, stats.DeleteLatency.Count)
+	}
+	if stats.Flushes != 2 || stats.Merges != 1 {
+		t.Fatalf("Wrong flushes %d and merges %d", stats.Flushes, stats.Merges)
+	}
+	if stats.SStables != 1 || stats.SStablesSize == 0 || stats.VlogGarbage == 0 {
+		t.Fatalf("Wrong sstables and garbage %+v", stats)
+	}
+	last := stats.PutLatency.Buckets[len(stats.PutLatency.Buckets)-1]
+	if last.Count > stats.PutLatency.Count || stats.PutLatency.Sum <= 0 {
+		t.Fatalf("Wrong histogram %+v", stats.PutLatency)
+	}
+}
+
+func TestLsmTree_SStables(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	if !tree.Ready() {
+		t.Fatal("Opened tree is not ready")
+	}
+	for _, key := range []string{"BNITA", "ANITA", "CNITA"} {
+		entry := NewEntry([]byte(key), []byte("DEVELOPER"))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	sstables, err := tree.SStables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sstables) != 1 || sstables[0].Size == 0 {
+		t.Fatalf("Wrong sstables %+v", sstables)
+	}
+	if string(sstables[0].FirstKey) != "ANITA" || string(sstables[0].LastKey) != "CNITA" {
+		t.Fatalf("Wrong key range %s - %s", sstables[0].FirstKey, sstables[0].LastKey)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Ready() {
+		t.Fatal("Closed tree is ready")
+	}
+}
@@ -0,0 +1,219 @@
+package wiskey
+
+import (
+	"os"
+)
+
+//Optimistic transaction
+//reads are tracked with the version that was seen and writes are buffered in memory
+//reads see the latest committed state and not a snapshot, a read of the key that was written
+//or deleted after the transaction began fails with ErrConflict
+//on commit all read keys are validated again and if any of them was written or deleted
+//since the transaction began it fails with ErrConflict, so committed transaction behaves as if
+//all its reads happened when it began
+type Transaction struct {
+	lsm   *LsmTree //column family that is used by this transaction
+	state *transactionState
+}
+
+//state is shared by transactions of all column families
+type transactionState struct {
+	snapshot uint64                              //timestamp of the latest write when transaction began
+	reads    map[*LsmTree]map[string]uint64      //key => version that was read
+	writes   map[*LsmTree]map[string]*TableEntry //key => buffered entry, nil entry means delete
+	order    []bufferedWrite                     //order in which keys were written
+	closed   bool
+}
+
+type bufferedWrite struct {
+	lsm *LsmTree
+	key string
+}
+
+//Begin a new optimistic transaction
+func (lsm *LsmTree) Begin() *Transaction {
+	lsm.rwm.RLock()
+	defer lsm.rwm.RUnlock()
+	return &Transaction{
+		lsm: lsm,
+		state: &transactionState{
+			snapshot: lsm.log.lastTimestamp,
+			reads:    make(map[*LsmTree]map[string]uint64),
+			writes:   make(map[*LsmTree]map[string]*TableEntry),
+		},
+	}
+}
+
+//The same transaction that reads and writes given column family
+//all column families are committed together
+func (tx *Transaction) Family(family *LsmTree) *Transaction {
+	return &Transaction{lsm: family, state: tx.state}
+}
+
+func (tx *Transaction) Get(key []byte) ([]byte, bool, error) {
+	value, _, found, err := tx.GetWithVersion(key)
+	return value, found, err
+}
+
+//Get the value with its version, buffered writes of this transaction are visible
+//returns ErrConflict if the key was written or deleted after the transaction began
+func (tx *Transaction) GetWithVersion(key []byte) ([]byte, uint64, bool, error) {
+	if tx.state.closed {
+		return nil, NoVersion, false, ErrTxnClosed
+	}
+	if entry, written := tx.state.writes[tx.lsm][string(key)]; written {
+		if entry == nil {
+			return nil, NoVersion, false, nil
+		}
+		return entry.value, NoVersion, true, nil
+	}
+	tx.lsm.rwm.RLock()
+	value, version, found, err := tx.lsm.getWithVersion(key)
+	if err != nil {
+		tx.lsm.rwm.RUnlock()
+		return nil, NoVersion, false, err
+	}
+	written, err := tx.lsm.lastWrite(key)
+	tx.lsm.rwm.RUnlock()
+	if err != nil {
+		return nil, NoVersion, false, err
+	}
+	//the key was written or deleted after the snapshot so the transaction can't be serialized
+	if written > tx.state.snapshot {
+		return nil, NoVersion, false, ErrConflict
+	}
+	reads, ok := tx.state.reads[tx.lsm]
+	if !ok {
+		reads = make(map[string]uint64)
+		tx.state.reads[tx.lsm] = reads
+	}
+	if _, read := reads[string(key)]; !read {
+		reads[string(key)] = version
+	}
+	return value, version, found, nil
+}
+
+//Buffer the entry, it's saved only on commit
+func (tx *Transaction) Put(key []byte, value []byte) error {
+	entry := NewEntry(key, value)
+	return tx.PutEntry(&entry)
+}
+
+func (tx *Transaction) PutEntry(entry *TableEntry) error {
+	if tx.state.closed {
+		return ErrTxnClosed
+	}
+	tx.write(string(entry.key), entry)
+	return nil
+}
+
+//Buffer the deletion, it's saved only on commit
+func (tx *Transaction) Delete(key []byte) error {
+	if tx.state.closed {
+		return ErrTxnClosed
+	}
+	tx.write(string(key), nil)
+	return nil
+}
+
+func (tx *Transaction) write(key string, entry *TableEntry) {
+	writes, ok := tx.state.writes[tx.lsm]
+	if !ok {
+		writes = make(map[string]*TableEntry)
+		tx.state.writes[tx.lsm] = writes
+	}
+	if _, written := writes[key]; !written {
+		tx.state.order = append(tx.state.order, bufferedWrite{lsm: tx.lsm, key: key})
+	}
+	writes[key] = entry
+}
+
+//Validate the read set and save all buffered writes of all column families
+func (tx *Transaction) Commit() error {
+	state := tx.state
+	if state.closed {
+		return ErrTxnClosed
+	}
+	state.closed = true
+	if err := tx.lsm.admitWrite(); err != nil {
+		return err
+	}
+	//all column families share the same lock
+	tx.lsm.rwm.Lock()
+	defer tx.lsm.rwm.Unlock()
+	if err := tx.lsm.writable(); err != nil {
+		return err
+	}
+	for lsm, reads := range state.reads {
+		for key, readVersion := range reads {
+			_, version, _, err := lsm.getWithVersion([]byte(key))
+			if err != nil {
+				return err
+			}
+			if version != readVersion {
+				return ErrConflict
+			}
+			//deleted key has no version so deletes are found by the timestamp of the tombstone
+			written, err := lsm.lastWrite([]byte(key))
+			if err != nil {
+				return err
+			}
+			if written > state.snapshot {
+				return ErrConflict
+			}
+		}
+	}
+	//all writes go to vlog as a single entry so a crash never leaves half of the transaction
+	var families []*LsmTree
+	var entries []*TableEntry
+	for _, write := range state.order {
+		entry := state.writes[write.lsm][write.key]
+		if entry == nil {
+			//already deleted and it's still in memory
+			if write.lsm.deleted[write.key] {
+				continue
+			}
+			write.lsm.deleted[write.key] = true
+			entry = DeletedEntry([]byte(write.key))
+		} else {
+			delete(write.lsm.deleted, write.key)
+		}
+		families = append(families, write.lsm)
+		entries = append(entries, entry)
+	}
+	return saveBatch(families, entries)
+}
+
+//Discard all buffered writes
+func (tx *Transaction) Rollback() {
+	tx.state.closed = true
+}
+
+//Timestamp of the latest write of the key including deletes and range deletes, caller has to hold the lock
+func (lsm *LsmTree) lastWrite(key []byte) (uint64, error) {
+	written := NoVersion
+	if meta, found := lsm.memtable.Get(key); found {
+		written = meta.timestamp
+	} else {
+		for _, tablePath := range lsm.sstables {
+			reader, err := os.Open(tablePath)
+			if err != nil {
+				return NoVersion, err
+			}
+			sstable := ReadTable(reader, lsm.log, lsm.comparator)
+			meta, found := sstable.lookup(key)
+			sstable.Close()
+			if found && meta.timestamp > written {
+				written = meta.timestamp
+			}
+		}
+	}
+	for _, tombstones := range [][]*rangeTombstone{lsm.rangeTombstones, lsm.memtable.rangeTombstones} {
+		for _, tombstone := range tombstones {
+			if tombstone.timestamp > written && tombstone.contains(key, lsm.comparator) {
+				written = tombstone.timestamp
+			}
+		}
+	}
+	return written, nil
+}
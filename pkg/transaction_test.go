@@ -0,0 +1,204 @@
+package wiskey
+
+import (
+	"os"
+	"testing"
+)
+
+func TestTransaction_Commit(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	err := tree.Put(&entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := tree.Begin()
+	value, found, err := tx.Get([]byte("ANITA"))
+	if err != nil || !found || string(value) != "DEVELOPER" {
+		t.Fatal("Transaction didn't read the value")
+	}
+	_ = tx.Put([]byte("BNITA"), value)
+	_ = tx.Delete([]byte("ANITA"))
+	//buffered writes are visible only inside of transaction
+	if _, found, _ := tx.Get([]byte("BNITA")); !found {
+		t.Fatal("Transaction has to see its own writes")
+	}
+	if _, found := mustGet(t, tree, []byte("BNITA")); found {
+		t.Fatal("Not committed write is visible")
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := mustGet(t, tree, []byte("ANITA")); found {
+		t.Fatal("Deleted key was found after commit")
+	}
+	if _, found := mustGet(t, tree, []byte("BNITA")); !found {
+		t.Fatal("Committed key was not found")
+	}
+	if tx.Commit() != ErrTxnClosed {
+		t.Fatal("Transaction can be committed only once")
+	}
+}
+
+func TestTransaction_Conflict(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	entry := NewEntry([]byte("ANITA"), []byte("1"))
+	err := tree.Put(&entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := tree.Begin()
+	second := tree.Begin()
+	_, _, _ = first.Get([]byte("ANITA"))
+	_, _, _ = second.Get([]byte("ANITA"))
+	_ = first.Put([]byte("ANITA"), []byte("2"))
+	_ = second.Put([]byte("ANITA"), []byte("3"))
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Commit(); err != ErrConflict {
+		t.Fatal("Second transaction had to conflict")
+	}
+	value, _ := mustGet(t, tree, []byte("ANITA"))
+	if string(value) != "2" {
+		t.Fatal("Conflicting transaction changed the value")
+	}
+	//reading a key that was changed after begin is a conflict
+	third := tree.Begin()
+	entry = NewEntry([]byte("ANITA"), []byte("4"))
+	err = tree.Put(&entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := third.Get([]byte("ANITA")); err != ErrConflict {
+		t.Fatal("Read of the key changed after snapshot has to conflict")
+	}
+}
+
+func TestTransaction_Reopen(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	first := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+	if err := tree.Put(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//flushed key is read from sstable
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(100), 30)
+	if _, _, err := tree.Begin().Get(first.key); err != nil {
+		t.Fatalf("Key written before reopen can't be read in transaction, error %v", err)
+	}
+	second := NewEntry([]byte("BNITA"), []byte("DEVELOPER2"))
+	if err := tree.Put(&second); err != nil {
+		t.Fatal(err)
+	}
+	//not flushed key is restored from vlog
+	crash(tree)
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(100), 30)
+	defer tree.Close()
+	tx := tree.Begin()
+	for _, key := range [][]byte{first.key, second.key} {
+		if _, found, err := tx.Get(key); err != nil || !found {
+			t.Fatalf("Key %s written before reopen can't be read in transaction, error %v", key, err)
+		}
+	}
+}
+
+func TestTransaction_CommitIsAtomic(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	tx := tree.Begin()
+	_ = tx.Put([]byte("ANITA"), []byte("DEVELOPER"))
+	_ = tx.Put([]byte("BNITA"), []byte("DEVELOPER2"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx = tree.Begin()
+	_ = tx.Delete([]byte("ANITA"))
+	_ = tx.Put([]byte("CNITA"), []byte("DEVELOPER3"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	//the process crashed while the second transaction was written
+	crash(tree)
+	if err := os.Truncate(tree.log.file, int64(tree.log.size-1)); err != nil {
+		t.Fatal(err)
+	}
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(100), 30)
+	defer tree.Close()
+	for _, key := range []string{"ANITA", "BNITA"} {
+		if _, found := mustGet(t, tree, []byte(key)); !found {
+			t.Fatalf("Key %s of the first transaction wasn't restored", key)
+		}
+	}
+	if _, found := mustGet(t, tree, []byte("CNITA")); found {
+		t.Fatal("Half of the second transaction was restored")
+	}
+}
+
+func TestTransaction_DeleteAfterBegin(t *testing.T) {
+	tree := InitTestLsmWithMeta(100, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	for _, key := range []string{"ANITA", "BNITA", "CNITA"} {
+		entry := NewEntry([]byte(key), []byte("DEVELOPER"))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//tombstone of the flushed key is in sstable
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	tx := tree.Begin()
+	if err := tree.Delete([]byte("ANITA")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.DeleteRange([]byte("B"), []byte("C")); err != nil {
+		t.Fatal(err)
+	}
+	//the keys were deleted after the transaction began
+	for _, key := range []string{"ANITA", "BNITA"} {
+		if _, _, err := tx.Get([]byte(key)); err != ErrConflict {
+			t.Fatalf("Read of %s deleted after begin returned %v", key, err)
+		}
+	}
+	if _, _, err := tx.Get([]byte("CNITA")); err != nil {
+		t.Fatal(err)
+	}
+	//missing key was written and deleted after it was read
+	if _, found, err := tx.Get([]byte("DNITA")); err != nil || found {
+		t.Fatalf("Missing key was read, found %v, error %v", found, err)
+	}
+	entry := NewEntry([]byte("DNITA"), []byte("DEVELOPER"))
+	if err := tree.Put(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete([]byte("DNITA")); err != nil {
+		t.Fatal(err)
+	}
+	_ = tx.Put([]byte("ENITA"), []byte("DEVELOPER"))
+	if err := tx.Commit(); err != ErrConflict {
+		t.Fatalf("Transaction with a key deleted after read was committed, error %v", err)
+	}
+	if _, found := mustGet(t, tree, []byte("ENITA")); found {
+		t.Fatal("Write of conflicting transaction was saved")
+	}
+}
@@ -0,0 +1,257 @@
+package wiskey
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	legacySuffix     = ".v1"                   //files of format 1 are renamed with this suffix until the upgrade is done
+	legacyHeaderSize = uint32Size + uint32Size //key length + value length of format 1 vlog entry
+	legacyFooterSize = uint32Size              //index offset of format 1 sstable
+)
+
+var (
+	//sstables of format 1 have random names
+	legacySstableName = regexp.MustCompile(`^[a-zA-Z]{10}\.sstable(\.v1)?$`)
+)
+
+//Latest value of the key in format 1 store
+type legacyValue struct {
+	timestamp uint64
+	offset    uint32 //offset of the vlog entry
+	length    uint32 //length of the vlog entry
+}
+
+//Store was written in format 1 if it has vlog or sstables of that format and no files of the next formats
+//vlog with the suffix is left by the upgrade that was interrupted
+func isLegacyStore(dir string, log *vlog) (bool, error) {
+	if _, err := os.Stat(log.file + legacySuffix); err == nil {
+		return true, nil
+	}
+	if _, err := os.Stat(dir + "/" + comparatorFile); err == nil {
+		return false, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	sstables := 0
+	for _, file := range files {
+		if matched, _ := regexp.MatchString(sstableExtension, file.Name()); !matched || file.IsDir() {
+			continue
+		}
+		if !legacySstableName.MatchString(file.Name()) {
+			return false, nil
+		}
+		sstables++
+	}
+	return log.size != 0 || sstables != 0, nil
+}
+
+//Rewrite the store of format 1 in the current format, returns the number of keys that were kept
+//vlog, checkpoint and sstables are renamed with the suffix first and the new vlog is written from them,
+//the format file is saved only after the new vlog is synced so the interrupted upgrade starts again on the next open
+//all keys are in the new vlog after the upgrade and they are flushed to sstables like any other writes
+func upgradeStore(dir string, log *vlog) (int, error) {
+	legacyVlog := log.file + legacySuffix
+	if _, err := os.Stat(legacyVlog); errors.Is(err, os.ErrNotExist) {
+		err := os.Rename(log.file, legacyVlog)
+		if err != nil {
+			return 0, err
+		}
+	}
+	legacyCheckpoint := log.checkpoint + legacySuffix
+	if _, err := os.Stat(legacyCheckpoint); errors.Is(err, os.ErrNotExist) {
+		err := os.Rename(log.checkpoint, legacyCheckpoint)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var sstables []string
+	for _, file := range files {
+		if file.IsDir() || !legacySstableName.MatchString(file.Name()) {
+			continue
+		}
+		path := dir + "/" + file.Name()
+		if !strings.HasSuffix(path, legacySuffix) {
+			err := os.Rename(path, path+legacySuffix)
+			if err != nil {
+				return 0, err
+			}
+			path += legacySuffix
+		}
+		sstables = append(sstables, path)
+	}
+	sort.Strings(sstables)
+	values := make(map[string]legacyValue)
+	for _, path := range sstables {
+		err := readLegacySStable(path, values)
+		if err != nil {
+			return 0, err
+		}
+	}
+	head, err := readLegacyCheckpoint(legacyCheckpoint)
+	if err != nil {
+		return 0, err
+	}
+	//entries after the checkpoint were in memtable, they are newer than the flushed ones
+	err = readLegacyVlog(legacyVlog, head, values)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := writeUpgradedVlog(log, legacyVlog, values)
+	if err != nil {
+		return 0, err
+	}
+	err = ioutil.WriteFile(dir+"/"+formatFile, []byte(strconv.Itoa(formatVersion)), 0666)
+	if err != nil {
+		return 0, err
+	}
+	removeFiles(append(sstables, legacyVlog, legacyCheckpoint))
+	return keys, nil
+}
+
+//Read entries of format 1 sstable, the entry with the latest timestamp is kept
+//+------------+-----+-----------+------------+------------+-------+--------------+
+//| Key Length | Key | timestamp | vlogoffset | vloglength | Index | Index Offset |
+//+------------+-----+-----------+------------+------------+-------+--------------+
+func readLegacySStable(path string, values map[string]legacyValue) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(content) < legacyFooterSize {
+		return fmt.Errorf("corrupted sstable %s", path)
+	}
+	indexOffset := int(binary.BigEndian.Uint32(content[len(content)-legacyFooterSize:]))
+	if indexOffset > len(content)-legacyFooterSize {
+		return fmt.Errorf("corrupted sstable %s", path)
+	}
+	for position := 0; position != indexOffset; {
+		if indexOffset-position < uint32Size {
+			return fmt.Errorf("corrupted sstable %s", path)
+		}
+		keyLength := int(binary.BigEndian.Uint32(content[position:]))
+		end := position + uint32Size + keyLength + int64Size + uint32Size + uint32Size
+		if end > indexOffset {
+			return fmt.Errorf("corrupted sstable %s", path)
+		}
+		key := string(content[position+uint32Size : position+uint32Size+keyLength])
+		meta := content[position+uint32Size+keyLength:]
+		value := legacyValue{
+			timestamp: binary.BigEndian.Uint64(meta),
+			offset:    binary.BigEndian.Uint32(meta[int64Size:]),
+			length:    binary.BigEndian.Uint32(meta[int64Size+uint32Size:]),
+		}
+		//timestamps have seconds so the later vlog entry wins if they are equal
+		old, found := values[key]
+		if !found || value.timestamp > old.timestamp || (value.timestamp == old.timestamp && value.offset > old.offset) {
+			values[key] = value
+		}
+		position = end
+	}
+	return nil
+}
+
+//Head of format 1 vlog, the whole vlog is read if there is no checkpoint
+func readLegacyCheckpoint(path string) (uint32, error) {
+	content, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || len(content) < uint32Size {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(content), nil
+}
+
+//Read format 1 vlog entries after the head, they replace the entries of sstables
+//+------------+--------------+-----+-------+
+//| Key Length | Value length | Key | Value |
+//+------------+--------------+-----+-------+
+func readLegacyVlog(path string, head uint32, values map[string]legacyValue) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for position := int(head); position < len(content); {
+		//the last entry can be partially written if the process crashed
+		if len(content)-position < legacyHeaderSize {
+			break
+		}
+		keyLength := int(binary.BigEndian.Uint32(content[position:]))
+		valueLength := int(binary.BigEndian.Uint32(content[position+uint32Size:]))
+		length := legacyHeaderSize + keyLength + valueLength
+		if len(content)-position < length {
+			break
+		}
+		key := string(content[position+legacyHeaderSize : position+legacyHeaderSize+keyLength])
+		values[key] = legacyValue{offset: uint32(position), length: uint32(length)}
+		position += length
+	}
+	return nil
+}
+
+//Write live values to the new vlog in the order of keys, deleted keys are dropped
+func writeUpgradedVlog(log *vlog, legacyVlog string, values map[string]legacyValue) (int, error) {
+	reader, err := os.Open(legacyVlog)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	file, err := os.OpenFile(log.file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writer := bufio.NewWriter(file)
+	size := uint32(0)
+	written := 0
+	for _, key := range keys {
+		meta := values[key]
+		buffer := make([]byte, meta.length)
+		if _, err := reader.ReadAt(buffer, int64(meta.offset)); err != nil {
+			return 0, fmt.Errorf("can't read vlog entry of key %q at offset %d: %w", key, meta.offset, err)
+		}
+		if len(buffer) < legacyHeaderSize || int(binary.BigEndian.Uint32(buffer)) != len(key) {
+			return 0, fmt.Errorf("corrupted vlog entry of key %q at offset %d", key, meta.offset)
+		}
+		value := buffer[legacyHeaderSize+len(key):]
+		if isTombstone(value) {
+			continue
+		}
+		entry := &TableEntry{key: []byte(key), value: value, timestamp: log.nextTimestamp()}
+		length, err := entry.writeTo(writer)
+		if err != nil {
+			return 0, err
+		}
+		size += length
+		written++
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	log.size = size
+	return written, nil
+}
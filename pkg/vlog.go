@@ -2,36 +2,93 @@ package wiskey
 
 import (
 	binary "encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"sort"
+	"time"
+)
+
+const (
+	vlogHeaderSize = uint32Size + uint32Size + 1 + int64Size + int64Size + 1 //key length + value length + kind + timestamp + expiration + family length
+	vlogTailSuffix = ".tail"                                                 //file next to vlog with the offset of its first byte
+	vlogGcSuffix   = ".gc"                                                   //file next to vlog with the new tail while gc replaces the vlog
+	vlogTempSuffix = ".tmp"                                                  //files that are renamed when they are written completely
 )
 
 type vlog struct {
-	file       string
-	size       uint32 // current size of the file,it has to be updated every time you append a new value
-	checkpoint string //path to the file with checkpoint
+	file          string
+	size          uint32 // offset after the last entry of the file,it has to be updated every time you append a new value
+	tail          uint32 //offset of the first byte of the file, gc removes the beginning of vlog but offsets stay the same
+	checkpoint    string //path to the file with checkpoint of the default column family
+	lastTimestamp uint64 //timestamp of the latest write
+	garbage       int64  //size of overwritten, deleted and expired values since the vlog was opened
 }
 
 func NewVlog(file string, checkpoint string) *vlog {
-	vlogFile, err := os.OpenFile(file, os.O_CREATE, 0666)
-	vlogFile.Close()
+	log, err := openVlog(file, checkpoint, false)
 	if err != nil {
 		panic(err)
 	}
+	return log
+}
+
+//Create vlog file if it doesn't exist, read only vlog has to exist
+func openVlog(file string, checkpoint string, readOnly bool) (*vlog, error) {
+	if !readOnly {
+		vlogFile, err := os.OpenFile(file, os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+		vlogFile.Close()
+		err = finishTruncation(file)
+		if err != nil {
+			return nil, err
+		}
+	}
 	stat, err := os.Stat(file)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	tail, err := readTail(file + vlogTailSuffix)
+	if err != nil {
+		return nil, err
 	}
 	return &vlog{
 		file:       file,
 		checkpoint: checkpoint,
-		size:       uint32(stat.Size()),
+		tail:       tail,
+		size:       tail + uint32(stat.Size()),
+	}, nil
+}
+
+//Timestamp for the next write, it's also used as a version of the key
+//so it has to be unique even if two writes happen in the same nanosecond
+func (log *vlog) nextTimestamp() uint64 {
+	timestamp := uint64(time.Now().UnixNano())
+	if timestamp <= log.lastTimestamp {
+		timestamp = log.lastTimestamp + 1
+	}
+	log.lastTimestamp = timestamp
+	return timestamp
+}
+
+//Keep the latest timestamp of entries that were written before the vlog was opened
+//so versions of restored keys are not newer than the latest write
+func (log *vlog) observeTimestamp(timestamp uint64) {
+	if timestamp > log.lastTimestamp {
+		log.lastTimestamp = timestamp
 	}
 }
 
-//Save the latest vlog head position in the checkpoint file
-func (log *vlog) FlushHead() error {
-	writer, err := os.OpenFile(log.checkpoint, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+//Save the latest vlog head position and the latest timestamp in the checkpoint file
+//+-------------+----------------+
+//| Head Offset | Last Timestamp |
+//+-------------+----------------+
+func (log *vlog) FlushHead(checkpoint string) error {
+	writer, err := os.OpenFile(checkpoint, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return err
 	}
@@ -40,140 +97,366 @@ func (log *vlog) FlushHead() error {
 	if err != nil {
 		return err
 	}
-	return binary.Write(writer, binary.BigEndian, log.size)
+	err = binary.Write(writer, binary.BigEndian, log.size)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(writer, binary.BigEndian, log.lastTimestamp)
+	if err != nil {
+		return err
+	}
+	return writer.Sync()
+}
+
+//Flush vlog file to disk
+func (log *vlog) sync() error {
+	file, err := os.OpenFile(log.file, os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
 }
 
 // Example of vlog entry to read
-//+------------+--------------+-----+-------+
-//| Key Length | Value length | Key | Value |
-//+------------+--------------+-----+-------+
+//+------------+--------------+------+-----------+-----------+---------------+--------+-----+-------+
+//| Key Length | Value length | Kind | Timestamp | ExpiresAt | Family Length | Family | Key | Value |
+//+------------+--------------+------+-----------+-----------+---------------+--------+-----+-------+
 func (log *vlog) Get(meta ValueMeta) (*TableEntry, error) {
 	reader, err := os.OpenFile(log.file, os.O_RDONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
 	defer reader.Close()
-	reader.Seek(int64(meta.offset), 0)
 	buffer := make([]byte, meta.length)
-	reader.Read(buffer)
-	keyLength := binary.BigEndian.Uint32(buffer[0:4])
-	key := buffer[8 : 8+keyLength]
-	value := buffer[8+keyLength:]
-	return &TableEntry{key: key, value: value}, nil
+	if err := log.readAt(reader, buffer, meta.offset); err != nil {
+		return nil, err
+	}
+	return decodeTableEntry(buffer), nil
 }
 
-func (log *vlog) RunGc(entries int, lsm *LsmTree) error {
-	file, err := os.OpenFile(log.file, os.O_RDONLY, 0666)
-	if err != nil {
-		return err
+//Read entries with a single open of vlog, they are read in the order of offsets to avoid seeking back
+//entries are returned in the order of metas
+func (log *vlog) getAll(metas []ValueMeta) ([]*TableEntry, error) {
+	entries := make([]*TableEntry, len(metas))
+	if len(metas) == 0 {
+		return entries, nil
 	}
-	defer file.Close()
-	stat, err := file.Stat()
+	reader, err := os.OpenFile(log.file, os.O_RDONLY|os.O_CREATE, 0666)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	logFileSize := stat.Size()
-	if logFileSize == 0 {
-		return nil
+	defer reader.Close()
+	order := make([]int, len(metas))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return metas[order[i]].offset < metas[order[j]].offset
+	})
+	for _, i := range order {
+		buffer := make([]byte, metas[i].length)
+		if err := log.readAt(reader, buffer, metas[i].offset); err != nil {
+			return nil, err
+		}
+		entries[i] = decodeTableEntry(buffer)
+	}
+	return entries, nil
+}
+
+//Read the entry at given offset, entries before the tail were removed by gc
+func (log *vlog) readAt(reader *os.File, buffer []byte, offset uint32) error {
+	if offset < log.tail {
+		return fmt.Errorf("vlog entry at offset %d was removed by gc, vlog starts at %d", offset, log.tail)
+	}
+	_, err := reader.ReadAt(buffer, int64(offset-log.tail))
+	return err
+}
+
+//Size of vlog file, offsets before the tail were removed by gc
+func (log *vlog) fileSize() int64 {
+	return int64(log.size - log.tail)
+}
+
+func (log *vlog) RunGc(entries int, lsm *LsmTree) error {
+	start := time.Now()
+	info, err := log.runGc(entries, lsm)
+	info.VlogSize = log.fileSize()
+	info.Duration = time.Since(start)
+	info.Err = err
+	lsm.notify(func(listener EventListener) {
+		listener.OnVlogGC(info)
+	})
+	return err
+}
+
+//Relocate live entries from the beginning of vlog to its head and remove the beginning, caller has to hold the write lock
+//offsets in sstables and checkpoints don't change when the beginning is removed, the offset of the first byte of the file is saved in the tail file
+func (log *vlog) runGc(entries int, lsm *LsmTree) (VlogGCInfo, error) {
+	var info VlogGCInfo
+	//memtables point to entries after checkpoints, after the flush only sstables point to the vlog
+	for _, family := range lsm.families {
+		if family.memtable.Size() == 0 && len(family.memtable.rangeTombstones) == 0 {
+			continue
+		}
+		if err := family.flush(); err != nil {
+			return info, err
+		}
 	}
-	readBytesSize := int64(0) //how many bytes were read from a file
-	counter := 0
-	for readBytesSize < logFileSize && counter < entries {
-		keyLengthBuffer := make([]byte, uint32Size)
-		//read key length
-		_, _ = file.Read(keyLengthBuffer)
-		keyLength := binary.BigEndian.Uint32(keyLengthBuffer)
-		//read value length
-		valueLengthBuffer := make([]byte, uint32Size)
-		_, _ = file.Read(valueLengthBuffer)
-		valueLength := binary.BigEndian.Uint32(valueLengthBuffer)
-		keyBuffer := make([]byte, keyLength)
-		valueBuffer := make([]byte, valueLength)
-		_, _ = file.Read(keyBuffer)
-		_, _ = file.Read(valueBuffer)
-		tableWithIndexes := lsm.Exists(keyBuffer)
-		if len(tableWithIndexes) != 0 {
-			entry := &TableEntry{key: keyBuffer, value: valueBuffer}
-			valueMeta, err := log.Append(entry)
+	file, err := os.Open(log.file)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+	var relocations []relocation
+	position := int64(0) //position in the file of the first entry that stays in vlog
+	for info.Entries < entries {
+		buffer := make([]byte, vlogHeaderSize)
+		if _, err := file.ReadAt(buffer, position); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return info, err
+		}
+		buffer = append(buffer, make([]byte, entryLength(buffer)-vlogHeaderSize)...)
+		if _, err := file.ReadAt(buffer[vlogHeaderSize:], position+vlogHeaderSize); err != nil {
+			return info, err
+		}
+		entry := decodeTableEntry(buffer)
+		//entries of a transaction are relocated one by one
+		entries, offsets := []*TableEntry{entry}, []uint32{0}
+		if entry.kind == batchKind {
+			entries, offsets = decodeBatch(entry)
+		}
+		//sstables of column family that is not opened can't point to the new offset
+		//so its entries and everything after them stay in vlog
+		if family, opened := unopenedFamily(entries, lsm); !opened {
+			lsm.state.logger.Info("vlog gc stopped at entry of column family that is not opened", "family", family)
+			break
+		}
+		for i, entry := range entries {
+			tables, err := log.references(entry, log.tail+uint32(position)+offsets[i], lsm)
 			if err != nil {
-				return err
+				return info, err
 			}
-			for i := range tableWithIndexes {
-				tableWithIndex := tableWithIndexes[i]
-				file, err := os.OpenFile(tableWithIndex.tablePath, os.O_RDWR, 0666)
-				if err != nil {
-					return err
-				}
-				err = OverrideVlogOffset(tableWithIndex.index, valueMeta, file)
-				if err != nil {
-					return err
-				}
-				err = file.Close()
-				if err != nil {
-					return err
-				}
+			if len(tables) != 0 {
+				relocations = append(relocations, relocation{entry: entry, tables: tables})
 			}
 		}
-		readBytesSize += int64(uint32Size + keyLength + uint32Size + valueLength)
-		counter++
+		position += int64(len(buffer))
+		info.Entries++
+	}
+	if position == 0 {
+		return info, nil
+	}
+	//relocated entries are synced before sstables point to them
+	relocated := int64(0)
+	for i := range relocations {
+		meta, err := log.Append(relocations[i].entry)
+		if err != nil {
+			return info, err
+		}
+		relocations[i].meta = meta
+		relocated += int64(meta.length)
+	}
+	if err := log.sync(); err != nil {
+		return info, err
+	}
+	for _, relocation := range relocations {
+		for _, table := range relocation.tables {
+			if err := overrideTableOffset(table, relocation.meta); err != nil {
+				return info, err
+			}
+		}
+	}
+	info.Relocated = len(relocations)
+	//memtables are empty so relocated entries don't have to be restored after restart
+	for _, family := range lsm.families {
+		if err := log.FlushHead(family.checkpoint); err != nil {
+			return info, err
+		}
 	}
-	//TODO: so we skipped deleted entries
-	//now we have to remove the beginning of the file
-	//starting from readBytesSize position
-	err = truncateVlog(readBytesSize, log.file)
+	if err := log.truncate(position); err != nil {
+		return info, err
+	}
+	info.ReclaimedBytes = position - relocated
+	log.garbage -= info.ReclaimedBytes
+	if log.garbage < 0 {
+		log.garbage = 0
+	}
+	return info, nil
+}
+
+//Find the first entry of column family that is not opened
+func unopenedFamily(entries []*TableEntry, lsm *LsmTree) (string, bool) {
+	for _, entry := range entries {
+		if _, opened := lsm.families[entry.family]; !opened {
+			return entry.family, false
+		}
+	}
+	return "", true
+}
+
+//Live entry that is moved to the head of vlog and sstables that point to it
+type relocation struct {
+	entry  *TableEntry
+	tables []TableWithIndex
+	meta   *ValueMeta //new place of the entry
+}
+
+//Find sstables that point to the entry at given offset
+//range tombstones and single merge operands are only needed to restore the memtable, sstables keep their own copy
+//expired entries are not moved to the head so their space is reclaimed, reads check expiration before they read the vlog
+//older sstables can point to the overwritten value of the key, it stays until the merge drops it
+func (log *vlog) references(entry *TableEntry, offset uint32, lsm *LsmTree) ([]TableWithIndex, error) {
+	if (entry.kind != valueKind && entry.kind != mergeOperandsKind) || isExpired(entry.expiresAt) {
+		return nil, nil
+	}
+	family := lsm.families[entry.family]
+	var tables []TableWithIndex
+	for _, tablePath := range family.sstables {
+		reader, err := os.Open(tablePath)
+		if err != nil {
+			return nil, err
+		}
+		sstable := ReadTable(reader, log, family.comparator)
+		if meta, found := sstable.lookup(entry.key); found && meta.offset == offset {
+			_, index := sstable.KeyAtIndex(entry.key)
+			tables = append(tables, TableWithIndex{index: index, tablePath: tablePath})
+		}
+		sstable.Close()
+	}
+	return tables, nil
+}
+
+//Point the entry of sstable to the new place of the value
+func overrideTableOffset(table TableWithIndex, meta *ValueMeta) error {
+	file, err := os.OpenFile(table.tablePath, os.O_RDWR, 0666)
 	if err != nil {
 		return err
 	}
-	info, err := os.Stat(log.file)
+	defer file.Close()
+	err = OverrideVlogOffset(table.index, meta, file)
 	if err != nil {
 		return err
 	}
-	log.size = uint32(info.Size())
-	return nil
+	return file.Sync()
 }
 
-func truncateVlog(offset int64, file string) error {
-	fin, err := os.Open(file)
+//Remove the beginning of vlog up to the position in the file
+//the new tail is saved before the truncated file replaces the vlog so the truncation interrupted by a crash
+//is finished or rolled back on the next open
+func (log *vlog) truncate(position int64) error {
+	reader, err := os.Open(log.file)
 	if err != nil {
 		return err
 	}
-	defer fin.Close()
-
-	tempFileName := RandStringBytes(10)
-	fout, err := os.Create(tempFileName)
+	defer reader.Close()
+	writer, err := os.Create(log.file + vlogTempSuffix)
 	if err != nil {
 		return err
 	}
-	defer fout.Close()
-
-	// Offset is the number of bytes you want to exclude
-	_, err = fin.Seek(offset, io.SeekStart)
+	defer writer.Close()
+	_, err = reader.Seek(position, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		return err
+	}
+	err = writer.Sync()
 	if err != nil {
 		return err
 	}
+	tail := log.tail + uint32(position)
+	err = writeTail(log.file+vlogGcSuffix, tail)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(log.file+vlogTempSuffix, log.file)
+	if err != nil {
+		return err
+	}
+	log.tail = tail
+	return finishTruncation(log.file)
+}
 
-	_, err = io.Copy(fout, fin)
+//Finish the truncation of vlog that was interrupted by a crash
+//if the truncated file didn't replace the vlog yet then the vlog and its tail are intact and the truncation is dropped,
+//otherwise the new tail is moved to the tail file
+func finishTruncation(file string) error {
+	if _, err := os.Stat(file + vlogGcSuffix); errors.Is(err, os.ErrNotExist) {
+		//crash happened before the new tail was saved
+		removeFiles([]string{file + vlogTempSuffix, file + vlogGcSuffix + vlogTempSuffix})
+		return nil
+	}
+	//the new tail is removed first, truncated file without it is removed on the next open
+	if _, err := os.Stat(file + vlogTempSuffix); err == nil {
+		err := os.Remove(file + vlogGcSuffix)
+		if err != nil {
+			return err
+		}
+		return os.Remove(file + vlogTempSuffix)
+	}
+	tail, err := readTail(file + vlogGcSuffix)
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(file); err != nil {
-		panic(err)
+	err = writeTail(file+vlogTailSuffix, tail)
+	if err != nil {
+		return err
 	}
+	return os.Remove(file + vlogGcSuffix)
+}
 
-	if err := os.Rename(tempFileName, file); err != nil {
+//Offset of the first byte of vlog file, 0 if the file doesn't exist
+func readTail(path string) (uint32, error) {
+	content, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(content) < uint32Size {
+		return 0, fmt.Errorf("corrupted vlog tail %s", path)
+	}
+	return binary.BigEndian.Uint32(content), nil
+}
+
+//Save the tail in a temporary file and rename it so the tail file is never partially written
+func writeTail(path string, tail uint32) error {
+	content := make([]byte, uint32Size)
+	binary.BigEndian.PutUint32(content, tail)
+	file, err := os.Create(path + vlogTempSuffix)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer file.Close()
+	_, err = file.Write(content)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+vlogTempSuffix, path)
 }
 
-//Restore vlog to given memtable
-func (log *vlog) RestoreTo(headOffset uint32, memtable *Memtable) error {
-	reader, err := os.OpenFile(log.file, os.O_RDONLY|os.O_CREATE, 0666)
+//Restore entries of given column family from vlog to given memtable
+func (log *vlog) RestoreTo(headOffset uint32, family string, memtable *Memtable) error {
+	reader, err := os.Open(log.file)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
-	_, err = reader.Seek(int64(headOffset), 0)
+	//gc removes only entries that are not needed by the memtable of any column family
+	if headOffset < log.tail {
+		headOffset = log.tail
+	}
+	_, err = reader.Seek(int64(headOffset-log.tail), 0)
 	if err != nil {
 		return err
 	}
@@ -181,7 +464,7 @@ func (log *vlog) RestoreTo(headOffset uint32, memtable *Memtable) error {
 	if err != nil {
 		return err
 	}
-	length := stat.Size() - int64(headOffset)
+	length := stat.Size() - int64(headOffset-log.tail)
 	if length == 0 {
 		//head is the tail
 		return nil
@@ -194,31 +477,62 @@ func (log *vlog) RestoreTo(headOffset uint32, memtable *Memtable) error {
 	lastPosition := 0
 	nextOffset := uint32(0)
 	for lastPosition != len(buffer) {
-		keyLength := binary.BigEndian.Uint32(buffer[lastPosition : lastPosition+4])
-		valueLength := binary.BigEndian.Uint32(buffer[lastPosition+4 : lastPosition+8])
-		key := buffer[lastPosition+8 : lastPosition+8+int(keyLength)]
-		metaLength := uint32Size + uint32Size + int(keyLength) + int(valueLength)
-		err := memtable.Put(key, &ValueMeta{length: uint32(metaLength), offset: nextOffset + headOffset})
-		if err != nil {
-			return err
+		//the last entry can be partially written if the process crashed
+		//or if another process is writing it while vlog is restored by read only tree
+		if len(buffer)-lastPosition < int(vlogHeaderSize) || len(buffer)-lastPosition < entryLength(buffer[lastPosition:]) {
+			break
+		}
+		metaLength := entryLength(buffer[lastPosition:])
+		entry := decodeTableEntry(buffer[lastPosition : lastPosition+metaLength])
+		entries := []*TableEntry{entry}
+		offsets := []uint32{0}
+		if entry.kind == batchKind {
+			entries, offsets = decodeBatch(entry)
+		}
+		for i, entry := range entries {
+			//timestamps of all column families are observed because they share the vlog
+			log.observeTimestamp(entry.timestamp)
+			if entry.kind == rangeTombstoneKind {
+				log.observeTimestamp(decodeRangeTombstone(entry).timestamp)
+			}
+			if entry.family != family {
+				continue
+			}
+			meta := &ValueMeta{length: entry.length(), offset: nextOffset + headOffset + offsets[i], timestamp: entry.timestamp, expiresAt: entry.expiresAt, kind: entry.kind}
+			if err := restoreEntry(entry, meta, memtable); err != nil {
+				return err
+			}
 		}
 		nextOffset += uint32(metaLength)
-		lastPosition += uint32Size
-		lastPosition += uint32Size
-		lastPosition += int(keyLength)
-		lastPosition += int(valueLength)
+		lastPosition += metaLength
+	}
+	log.size = log.tail + uint32(stat.Size())
+	return nil
+}
+
+//Save restored vlog entry to memtable
+func restoreEntry(entry *TableEntry, meta *ValueMeta, memtable *Memtable) error {
+	switch entry.kind {
+	case rangeTombstoneKind:
+		memtable.DeleteRange(decodeRangeTombstone(entry))
+	case mergeOperandKind:
+		memtable.Merge(entry.key, meta, decodeOperands(entry.value))
+	case mergeOperandsKind:
+		meta.operands = decodeOperands(entry.value)
+		return memtable.Put(entry.key, meta)
+	default:
+		return memtable.Put(entry.key, meta)
 	}
-	log.size = uint32(stat.Size())
 	return nil
 }
 
 //Append new entry to the head of vlog
-//the binary format for entry is [klength,vlength,key,value]
+//the binary format for entry is [klength,vlength,kind,timestamp,expiresAt,flength,family,key,value]
 //we store key in vlog for garbage collection purposes
 // Example of signle entry in vlog
-//+------------+--------------+-----+-------+
-//| Key Length | Value length | Key | Value |
-//+------------+--------------+-----+-------+
+//+------------+--------------+------+-----------+-----------+---------------+--------+-----+-------+
+//| Key Length | Value length | Kind | Timestamp | ExpiresAt | Family Length | Family | Key | Value |
+//+------------+--------------+------+-----------+-----------+---------------+--------+-----+-------+
 func (log *vlog) Append(entry *TableEntry) (*ValueMeta, error) {
 	writer, err := os.OpenFile(log.file, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
@@ -229,13 +543,20 @@ func (log *vlog) Append(entry *TableEntry) (*ValueMeta, error) {
 	if err != nil {
 		return nil, err
 	}
-	meta := &ValueMeta{length: length, offset: log.size}
+	meta := &ValueMeta{length: length, offset: log.size, timestamp: entry.timestamp, expiresAt: entry.expiresAt, kind: entry.kind}
 	log.size += length
 	return meta, nil
 }
 
 //metadata of saved entry in vlog
 type ValueMeta struct {
-	length uint32 //value length in vlog file
-	offset uint32 //value offset in vlog file
+	length    uint32 //value length in vlog file
+	offset    uint32 //value offset in vlog file
+	timestamp uint64 //when value was written
+	expiresAt uint64 //when value expires, 0 if it never expires
+	kind      byte   //value or merge operands
+	//merge operands are kept in memory so they can be applied without reading the vlog
+	operands [][]byte
+	//value that operands are applied to,nil if it's not in memory
+	base *ValueMeta
 }
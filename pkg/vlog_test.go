@@ -18,7 +18,7 @@ func TestVlog_Append(t *testing.T) {
 	entries := FakeEntries()
 	//save entries
 	for _, entry := range entries {
-		length := uint32(uint32Size /*key length*/ + uint32Size /*value length*/ + len(entry.key) /*ANITA takes 5 bytes*/ + len(entry.value) /*DEVELOPER takes 8 bytes*/)
+		length := uint32(uint32Size /*key length*/ + uint32Size /*value length*/ + 1 /*kind*/ + int64Size /*timestamp*/ + int64Size /*expiration*/ + 1 /*family length*/ + len(entry.key) /*ANITA takes 5 bytes*/ + len(entry.value) /*DEVELOPER takes 8 bytes*/)
 		meta, err := vlog.Append(&entry)
 		if err != nil {
 			t.Error(err)
@@ -30,7 +30,7 @@ func TestVlog_Append(t *testing.T) {
 	currentOffset := uint32(0)
 	//search them
 	for _, entry := range entries {
-		length := uint32(uint32Size /*key length*/ + uint32Size /*value length*/ + len(entry.key) /*ANITA takes 5 bytes*/ + len(entry.value) /*DEVELOPER takes 8 bytes*/)
+		length := uint32(uint32Size /*key length*/ + uint32Size /*value length*/ + 1 /*kind*/ + int64Size /*timestamp*/ + int64Size /*expiration*/ + 1 /*family length*/ + len(entry.key) /*ANITA takes 5 bytes*/ + len(entry.value) /*DEVELOPER takes 8 bytes*/)
 		val, err := vlog.Get(ValueMeta{length: length, offset: currentOffset})
 		if err != nil {
 			t.Error(err)
@@ -44,3 +44,105 @@ func TestVlog_Append(t *testing.T) {
 		}
 	}
 }
+
+func TestVlog_Gc(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.file + vlogTailSuffix)
+	defer os.Remove(tree.log.checkpoint)
+	expected := map[string]string{}
+	put := func(key string, value string) {
+		entry := NewEntry([]byte(key), []byte(value))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = value
+	}
+	check := func(tree *LsmTree) {
+		for key, value := range expected {
+			if actual, found := mustGet(t, tree, []byte(key)); !found || string(actual) != value {
+				t.Fatalf("Key %s has value %s instead of %s after gc", key, actual, value)
+			}
+		}
+	}
+	put("a", "va")
+	put("b", "vb")
+	put("c", "vc")
+	put("b", "vb2")
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	put("d", "vd")
+	//writes wait for gc that holds the lock
+	done := make(chan error)
+	go func() {
+		entry := NewEntry([]byte("e"), []byte("ve"))
+		done <- tree.Put(&entry)
+	}()
+	if err := tree.CompressVlogEntries(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected["e"] = "ve"
+	if tree.log.tail == 0 || tree.log.fileSize() >= int64(tree.log.size) {
+		t.Fatalf("Vlog wasn't truncated, tail %d size %d", tree.log.tail, tree.log.fileSize())
+	}
+	check(tree)
+	//the second gc starts after the tail
+	if err := tree.CompressVlogEntries(100); err != nil {
+		t.Fatal(err)
+	}
+	check(tree)
+	put("f", "vf")
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree = NewLsmTree(NewVlog(tree.log.file, tree.log.checkpoint), tree.sstableDir, NewMemTable(1000), 30)
+	defer tree.Close()
+	check(tree)
+}
+
+func TestVlog_FinishTruncation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	file := dir + "/vlog"
+	if err := ioutil.WriteFile(file, []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	//truncated file didn't replace vlog so the truncation is dropped
+	if err := writeTail(file+vlogGcSuffix, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file+vlogTempSuffix, []byte("new"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	log, err := openVlog(file, dir+"/checkpoint", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.tail != 0 || log.size != 3 {
+		t.Fatalf("Interrupted truncation wasn't dropped, tail %d size %d", log.tail, log.size)
+	}
+	for _, path := range []string{file + vlogGcSuffix, file + vlogTempSuffix} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s wasn't removed", path)
+		}
+	}
+	//truncated file replaced vlog so the new tail is saved
+	if err := writeTail(file+vlogGcSuffix, 10); err != nil {
+		t.Fatal(err)
+	}
+	log, err = openVlog(file, dir+"/checkpoint", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.tail != 10 || log.size != 13 {
+		t.Fatalf("Interrupted truncation wasn't finished, tail %d size %d", log.tail, log.size)
+	}
+	if tail, err := readTail(file + vlogTailSuffix); err != nil || tail != 10 {
+		t.Fatalf("Tail %d wasn't saved, error %v", tail, err)
+	}
+}
@@ -0,0 +1,111 @@
+package wiskey
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrWatcherLagged = errors.New("watcher didn't read changes fast enough")
+)
+
+//Type of the change
+type ChangeType int
+
+const (
+	ChangePut ChangeType = iota
+	ChangeDelete
+	ChangeMerge
+	ChangeDeleteRange
+)
+
+//Write to column family
+type Change struct {
+	Type    ChangeType
+	Key     []byte //start of the range for range deletes
+	End     []byte //end of the range for range deletes
+	Value   []byte //value of put or operand of merge
+	Version uint64
+}
+
+//Stream of changes of column family
+//changes are sent without blocking writers so the watcher that doesn't read them
+//fast enough is closed with ErrWatcherLagged
+type Watcher struct {
+	lsm     *LsmTree
+	prefix  []byte
+	changes chan Change
+	err     error
+}
+
+//Watch changes of keys with given prefix, range deletes are sent to all watchers
+//buffer is the amount of changes that can wait until they are read
+func (lsm *LsmTree) Watch(prefix []byte, buffer int) *Watcher {
+	lsm.rwm.Lock()
+	defer lsm.rwm.Unlock()
+	watcher := &Watcher{lsm: lsm, prefix: prefix, changes: make(chan Change, buffer)}
+	if lsm.state.closed {
+		watcher.stop(ErrClosed)
+		return watcher
+	}
+	if lsm.watchers == nil {
+		lsm.watchers = make(map[*Watcher]bool)
+	}
+	lsm.watchers[watcher] = true
+	return watcher
+}
+
+//Channel of changes, it's closed when the watcher is closed
+func (watcher *Watcher) Changes() <-chan Change {
+	return watcher.changes
+}
+
+//Reason why the channel of changes was closed, nil if it was closed by Close
+func (watcher *Watcher) Err() error {
+	watcher.lsm.rwm.RLock()
+	defer watcher.lsm.rwm.RUnlock()
+	return watcher.err
+}
+
+//Stop watching
+func (watcher *Watcher) Close() {
+	watcher.lsm.rwm.Lock()
+	defer watcher.lsm.rwm.Unlock()
+	if _, found := watcher.lsm.watchers[watcher]; found {
+		watcher.stop(nil)
+	}
+}
+
+//Close the channel, caller has to hold the write lock
+func (watcher *Watcher) stop(err error) {
+	delete(watcher.lsm.watchers, watcher)
+	watcher.err = err
+	close(watcher.changes)
+}
+
+//Send the change to watchers, caller has to hold the write lock
+func (lsm *LsmTree) publish(change Change) {
+	for watcher := range lsm.watchers {
+		if change.Type != ChangeDeleteRange && !bytes.HasPrefix(change.Key, watcher.prefix) {
+			continue
+		}
+		select {
+		case watcher.changes <- change:
+		default:
+			watcher.stop(ErrWatcherLagged)
+		}
+	}
+}
+
+//Change that is saved by the entry
+func entryChange(entry *TableEntry) Change {
+	change := Change{Type: ChangePut, Key: entry.key, Value: entry.value, Version: entry.timestamp}
+	if entry.kind == mergeOperandKind {
+		change.Type = ChangeMerge
+		change.Value = decodeOperands(entry.value)[0]
+	} else if isTombstone(entry.value) {
+		change.Type = ChangeDelete
+		change.Value = nil
+	}
+	return change
+}
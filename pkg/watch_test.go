@@ -0,0 +1,69 @@
+package wiskey
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestLsmTree_Watch(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	watcher := tree.Watch([]byte("users/"), 10)
+	for _, key := range []string{"users/1", "orders/1"} {
+		entry := NewEntry([]byte(key), []byte("DEVELOPER"))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Delete([]byte("users/1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.DeleteRange([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	put := <-watcher.Changes()
+	if put.Type != ChangePut || string(put.Key) != "users/1" || string(put.Value) != "DEVELOPER" || put.Version == 0 {
+		t.Fatalf("Wrong put change %+v", put)
+	}
+	//change of key without the prefix is skipped
+	if deleted := <-watcher.Changes(); deleted.Type != ChangeDelete || string(deleted.Key) != "users/1" {
+		t.Fatalf("Wrong delete change %+v", deleted)
+	}
+	if deletedRange := <-watcher.Changes(); deletedRange.Type != ChangeDeleteRange || string(deletedRange.End) != "b" {
+		t.Fatalf("Wrong range delete change %+v", deletedRange)
+	}
+	watcher.Close()
+	if _, open := <-watcher.Changes(); open || watcher.Err() != nil {
+		t.Fatal("Closed watcher is still open")
+	}
+}
+
+func TestLsmTree_WatchLagged(t *testing.T) {
+	tree := InitTestLsmWithMeta(1000, 30)
+	defer os.RemoveAll(tree.sstableDir)
+	defer os.Remove(tree.log.file)
+	defer os.Remove(tree.log.checkpoint)
+	lagged := tree.Watch(nil, 1)
+	closed := tree.Watch(nil, 10)
+	for i := 0; i < 2; i++ {
+		entry := NewEntry([]byte("ANITA"), []byte("DEVELOPER"))
+		if err := tree.Put(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-lagged.Changes()
+	if _, open := <-lagged.Changes(); open || !errors.Is(lagged.Err(), ErrWatcherLagged) {
+		t.Fatalf("Slow watcher wasn't closed, error %v", lagged.Err())
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range closed.Changes() {
+	}
+	if !errors.Is(closed.Err(), ErrClosed) {
+		t.Fatalf("Watcher wasn't closed with the tree, error %v", closed.Err())
+	}
+}
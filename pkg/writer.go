@@ -9,6 +9,7 @@ type SSTableWriter struct {
 	size                 uint32 //how many bytes were written to file
 	writeCloser          io.WriteCloser
 	inMemoryIndex        []tableIndex
+	rangeTombstones      []*rangeTombstone
 }
 
 //create new writeCloser
@@ -26,12 +27,25 @@ func NewWriter(w io.WriteCloser,blockLength uint32) *SSTableWriter {
 func (w *SSTableWriter) Close() error {
 	//if there are still some remaining bytes then save them in the index
 	w.closeBlock()
+	indexOffset := w.size
 	err := w.writeIndex()
 	if err != nil {
 		return err
 	}
-	footer := Footer{indexOffset: w.size}
+	rangeTombstoneOffset := w.size
+	err = w.writeRangeTombstones()
+	if err != nil {
+		return err
+	}
+	footer := Footer{indexOffset: indexOffset, rangeTombstoneOffset: rangeTombstoneOffset}
 	footer.writeTo(w.writeCloser)
+	//files are synced so the checkpoint never points past a lost sstable
+	if syncer, ok := w.writeCloser.(interface{ Sync() error }); ok {
+		err = syncer.Sync()
+		if err != nil {
+			return err
+		}
+	}
 	return w.writeCloser.Close()
 }
 
@@ -49,6 +63,11 @@ func (w *SSTableWriter) WriteEntry(e *sstableEntry) (uint32, error) {
 	return length, nil
 }
 
+//Range tombstones are kept in memory and saved in a separate block after the index
+func (w *SSTableWriter) WriteRangeTombstone(tombstone *rangeTombstone) {
+	w.rangeTombstones = append(w.rangeTombstones, tombstone)
+}
+
 func (w *SSTableWriter) blockIsFull() bool {
 	return w.maxBlockLength <= w.blockCapacity()
 }
@@ -67,7 +86,19 @@ func (w *SSTableWriter) closeBlock() {
 
 func (w *SSTableWriter) writeIndex() error {
 	for _, index := range w.inMemoryIndex {
-		err := index.WriteTo(w.writeCloser)
+		err := index.writeTo(w.writeCloser)
+		if err != nil {
+			return err
+		}
+		w.size += indexSize
+	}
+	return nil
+}
+
+func (w *SSTableWriter) writeRangeTombstones() error {
+	for _, tombstone := range w.rangeTombstones {
+		length, err := tombstone.writeTo(w.writeCloser)
+		w.size += length
 		if err != nil {
 			return err
 		}
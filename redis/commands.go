@@ -0,0 +1,735 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wiskey/http"
+	. "wiskey/pkg"
+)
+
+const (
+	defaultScanCount = 10    //keys returned by SCAN without COUNT
+	maxCursors       = 10000 //SCAN cursors that are kept, the oldest ones are forgotten
+)
+
+var (
+	errSyntax     = errors.New("ERR syntax error")
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errNoAuth     = errors.New("NOAUTH Authentication required.")
+	errWrongPass  = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errKeyNoPerm  = errors.New("NOPERM this token has no permissions to access one of the keys used as arguments")
+)
+
+//Connection of a client
+type session struct {
+	server *Server
+	id     int64
+	writer *writer
+	grant  *http.Grant //nil until the client is authenticated or if auth is disabled
+}
+
+type command struct {
+	//number of arguments including the name, negative means at least that many
+	arity int
+	//operation of the grant that the command needs, empty for commands that don't touch keys
+	operation string
+	//keys that the grant has to allow
+	keys    func(args [][]byte) [][]byte
+	handler func(s *session, args [][]byte) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":   {-1, "", noKeys, ping},
+		"echo":   {2, "", noKeys, echo},
+		"hello":  {-1, "", noKeys, hello},
+		"auth":   {-2, "", noKeys, auth},
+		"select": {2, "", noKeys, selectDb},
+		"client": {-2, "", noKeys, client},
+		"get":    {2, http.PermissionRead, firstKey, get},
+		"set":    {-3, http.PermissionWrite, firstKey, set},
+		"del":    {-2, http.PermissionWrite, allKeys, del},
+		"exists": {-2, http.PermissionRead, allKeys, exists},
+		"mget":   {-2, http.PermissionRead, allKeys, mget},
+		"mset":   {-3, http.PermissionWrite, pairKeys, mset},
+		"scan":   {-2, http.PermissionRead, noKeys, scan},
+		"expire": {3, http.PermissionWrite, firstKey, expire},
+		"incr":   {2, http.PermissionWrite, firstKey, incr},
+	}
+}
+
+func noKeys(args [][]byte) [][]byte {
+	return nil
+}
+
+func firstKey(args [][]byte) [][]byte {
+	return args[1:2]
+}
+
+func allKeys(args [][]byte) [][]byte {
+	return args[1:]
+}
+
+//Keys of key value pairs
+func pairKeys(args [][]byte) [][]byte {
+	var keys [][]byte
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+//Run the command and write its reply, returns true if the client asked to close the connection
+func (s *session) execute(args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	if name == "quit" {
+		s.writer.simple("OK")
+		return true
+	}
+	cmd, found := commands[name]
+	if !found {
+		s.writer.error("ERR unknown command '" + string(args[0]) + "', with args beginning with: " + quoteArgs(args[1:]))
+		return false
+	}
+	//clients that aren't authenticated can only authenticate
+	if s.server.auth != nil && s.grant == nil && name != "auth" && name != "hello" {
+		s.writer.error(errNoAuth.Error())
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		s.writer.error("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+	if err := s.authorize(name, cmd, args); err != nil {
+		s.writer.error(err.Error())
+		return false
+	}
+	if err := cmd.handler(s, args); err != nil {
+		s.writer.error(errorMessage(err))
+	}
+	return false
+}
+
+//Check that the grant allows the operation of the command and its keys
+func (s *session) authorize(name string, cmd command, args [][]byte) error {
+	if s.grant == nil || cmd.operation == "" {
+		return nil
+	}
+	if !s.grant.Allows(cmd.operation) {
+		return errors.New("NOPERM this token has no permissions to run the '" + name + "' command")
+	}
+	for _, key := range cmd.keys(args) {
+		if !s.grant.AllowsKey(key) {
+			return errKeyNoPerm
+		}
+	}
+	return nil
+}
+
+//Find the grant of the token, username is ignored because tokens aren't bound to users
+func (s *session) authenticate(token []byte) error {
+	if s.server.auth == nil {
+		return errors.New("AUTH called without any password configured for the default user")
+	}
+	grant, err := s.server.auth.Authenticate(string(token))
+	if err != nil {
+		return errWrongPass
+	}
+	s.grant = grant
+	return nil
+}
+
+//Values bigger than max value size are rejected
+func (s *session) checkValueSize(value []byte) error {
+	if s.server.maxValueSize != 0 && int64(len(value)) > s.server.maxValueSize {
+		return errors.New("value is bigger than " + strconv.FormatInt(s.server.maxValueSize, 10) + " bytes")
+	}
+	return nil
+}
+
+//Arguments of unknown command the way redis shows them
+func quoteArgs(args [][]byte) string {
+	var quoted strings.Builder
+	for _, arg := range args {
+		quoted.WriteString("'" + string(arg) + "' ")
+	}
+	return quoted.String()
+}
+
+//Error reply with the code that matches the error of the tree
+func errorMessage(err error) string {
+	switch {
+	case errors.Is(err, errSyntax), errors.Is(err, errNotInteger), errors.Is(err, errNoAuth), errors.Is(err, errWrongPass):
+		return err.Error()
+	case errors.Is(err, ErrReadOnly):
+		return "READONLY " + err.Error()
+	case errors.Is(err, ErrWriteStall):
+		return "TRYAGAIN " + err.Error()
+	}
+	return "ERR " + err.Error()
+}
+
+func ping(s *session, args [][]byte) error {
+	if len(args) > 2 {
+		return errors.New("wrong number of arguments for 'ping' command")
+	}
+	if len(args) == 2 {
+		s.writer.bulk(args[1])
+	} else {
+		s.writer.simple("PONG")
+	}
+	return nil
+}
+
+func echo(s *session, args [][]byte) error {
+	s.writer.bulk(args[1])
+	return nil
+}
+
+//HELLO [protover [AUTH username password] [SETNAME name]], switches between RESP2 and RESP3
+//password of AUTH is the token, username is ignored
+func hello(s *session, args [][]byte) error {
+	proto := s.writer.proto
+	var token []byte
+	if len(args) > 1 {
+		version, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return errors.New("Protocol version is not an integer or out of range")
+		}
+		if version != 2 && version != 3 {
+			s.writer.error("NOPROTO unsupported protocol version")
+			return nil
+		}
+		proto = version
+		for i := 2; i < len(args); i++ {
+			switch strings.ToLower(string(args[i])) {
+			case "auth":
+				i += 2
+				if i < len(args) {
+					token = args[i]
+				}
+			case "setname":
+				i++
+			default:
+				return errSyntax
+			}
+			if i >= len(args) {
+				return errSyntax
+			}
+		}
+	}
+	if token != nil {
+		if err := s.authenticate(token); err != nil {
+			return err
+		}
+	}
+	if s.server.auth != nil && s.grant == nil {
+		return errNoAuth
+	}
+	s.writer.proto = proto
+	s.writer.mapHeader(7)
+	s.writer.bulk([]byte("server"))
+	s.writer.bulk([]byte("wiskey"))
+	s.writer.bulk([]byte("version"))
+	s.writer.bulk([]byte("7.0.0"))
+	s.writer.bulk([]byte("proto"))
+	s.writer.integer(int64(proto))
+	s.writer.bulk([]byte("id"))
+	s.writer.integer(s.id)
+	s.writer.bulk([]byte("mode"))
+	s.writer.bulk([]byte("standalone"))
+	s.writer.bulk([]byte("role"))
+	s.writer.bulk([]byte("master"))
+	s.writer.bulk([]byte("modules"))
+	s.writer.array(0)
+	return nil
+}
+
+//AUTH [username] password, password is the token of auth config
+func auth(s *session, args [][]byte) error {
+	if len(args) > 3 {
+		return errSyntax
+	}
+	if err := s.authenticate(args[len(args)-1]); err != nil {
+		return err
+	}
+	s.writer.simple("OK")
+	return nil
+}
+
+//Only database 0 exists, it's the default column family
+func selectDb(s *session, args [][]byte) error {
+	db, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return errNotInteger
+	}
+	if db != 0 {
+		return errors.New("DB index is out of range")
+	}
+	s.writer.simple("OK")
+	return nil
+}
+
+//Client libraries set the name and the version of the library when they connect, it's ignored
+func client(s *session, args [][]byte) error {
+	switch strings.ToLower(string(args[1])) {
+	case "setname", "setinfo":
+		s.writer.simple("OK")
+	case "id":
+		s.writer.integer(s.id)
+	case "getname":
+		s.writer.null()
+	default:
+		return errors.New("unknown subcommand '" + string(args[1]) + "'")
+	}
+	return nil
+}
+
+func get(s *session, args [][]byte) error {
+	value, found, err := s.server.lsm.Get(args[1])
+	if err != nil {
+		return err
+	}
+	if found {
+		s.writer.bulk(value)
+	} else {
+		s.writer.null()
+	}
+	return nil
+}
+
+//SET key value [NX|XX] [GET] [EX seconds|PX milliseconds]
+func set(s *session, args [][]byte) error {
+	var nx, xx, returnOld bool
+	var ttl time.Duration
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "get":
+			returnOld = true
+		case "ex", "px":
+			if ttl != 0 || i+1 == len(args) {
+				return errSyntax
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			unit := time.Second
+			if strings.ToLower(string(args[i])) == "px" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				return errors.New("invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+	if err := s.checkValueSize(args[2]); err != nil {
+		return err
+	}
+	lsm := s.server.lsm
+	entry := NewEntry(args[1], args[2])
+	if ttl != 0 {
+		entry = NewEntryWithTTL(args[1], args[2], ttl)
+	}
+	if !nx && !xx && !returnOld {
+		if err := lsm.Put(&entry); err != nil {
+			return err
+		}
+		s.writer.simple("OK")
+		return nil
+	}
+	for {
+		old, version, found, err := lsm.GetWithVersion(args[1])
+		if err != nil {
+			return err
+		}
+		if (nx && found) || (xx && !found) {
+			if returnOld && found {
+				s.writer.bulk(old)
+			} else {
+				s.writer.null()
+			}
+			return nil
+		}
+		_, err = lsm.CompareAndPut(&entry, version)
+		if errors.Is(err, ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !returnOld {
+			s.writer.simple("OK")
+		} else if found {
+			s.writer.bulk(old)
+		} else {
+			s.writer.null()
+		}
+		return nil
+	}
+}
+
+//Replies with the number of keys that existed
+func del(s *session, args [][]byte) error {
+	lsm := s.server.lsm
+	deleted := int64(0)
+	for _, key := range args[1:] {
+		for {
+			_, version, found, err := lsm.GetWithVersion(key)
+			if err != nil {
+				return err
+			}
+			if !found {
+				break
+			}
+			err = lsm.DeleteIfVersion(key, version)
+			if errors.Is(err, ErrVersionMismatch) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			deleted++
+			break
+		}
+	}
+	s.writer.integer(deleted)
+	return nil
+}
+
+//Replies with the number of existing keys, repeated keys are counted every time
+func exists(s *session, args [][]byte) error {
+	count := int64(0)
+	for _, key := range args[1:] {
+		_, found, err := s.server.lsm.Get(key)
+		if err != nil {
+			return err
+		}
+		if found {
+			count++
+		}
+	}
+	s.writer.integer(count)
+	return nil
+}
+
+//Values are read before the reply is written so a failed read doesn't leave the reply incomplete
+func mget(s *session, args [][]byte) error {
+	results, err := s.server.lsm.MultiGet(args[1:])
+	if err != nil {
+		return err
+	}
+	s.writer.array(len(results))
+	for _, result := range results {
+		if result.Found {
+			s.writer.bulk(result.Value)
+		} else {
+			s.writer.null()
+		}
+	}
+	return nil
+}
+
+//All keys are saved atomically in a transaction
+func mset(s *session, args [][]byte) error {
+	if len(args)%2 != 1 {
+		return errors.New("wrong number of arguments for 'mset' command")
+	}
+	for i := 2; i < len(args); i += 2 {
+		if err := s.checkValueSize(args[i]); err != nil {
+			return err
+		}
+	}
+	tx := s.server.lsm.Begin()
+	for i := 1; i < len(args); i += 2 {
+		if err := tx.Put(args[i], args[i+1]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.writer.simple("OK")
+	return nil
+}
+
+//SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+//cursor remembers the last returned key so keys that exist during the whole scan are returned once
+//MATCH is applied after COUNT keys are taken so a page can be empty while the cursor isn't 0
+//keys that the grant doesn't allow are skipped the same way
+func scan(s *session, args [][]byte) error {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return errors.New("invalid cursor")
+	}
+	var pattern []byte
+	count := defaultScanCount
+	onlyStrings := true
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return errSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return errNotInteger
+			}
+			if count < 1 {
+				return errSyntax
+			}
+		case "type":
+			//all values are strings
+			onlyStrings = strings.ToLower(string(args[i+1])) == "string"
+		default:
+			return errSyntax
+		}
+	}
+	var last []byte
+	if cursor != 0 {
+		var found bool
+		last, found = s.server.cursors.get(cursor)
+		if !found {
+			return errors.New("invalid cursor")
+		}
+	}
+	iterator := s.server.lsm.NewIterator(last, nil)
+	var keys [][]byte
+	taken := 0
+	more := false
+	for iterator.Next() {
+		key := iterator.Key()
+		if last != nil && bytes.Equal(key, last) {
+			continue
+		}
+		if taken == count {
+			more = true
+			break
+		}
+		taken++
+		last = key
+		if onlyStrings && (pattern == nil || match(pattern, key)) && (s.grant == nil || s.grant.AllowsKey(key)) {
+			keys = append(keys, key)
+		}
+	}
+	if err := iterator.Err(); err != nil {
+		return err
+	}
+	next := uint64(0)
+	if more {
+		next = s.server.cursors.add(last)
+	}
+	s.writer.array(2)
+	s.writer.bulk([]byte(strconv.FormatUint(next, 10)))
+	s.writer.array(len(keys))
+	for _, key := range keys {
+		s.writer.bulk(key)
+	}
+	return nil
+}
+
+//EXPIRE key seconds, non positive ttl deletes the key
+//replies with 1 if the key exists and 0 otherwise
+func expire(s *session, args [][]byte) error {
+	seconds, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	if seconds > math.MaxInt64/int64(time.Second) {
+		return errors.New("invalid expire time in 'expire' command")
+	}
+	lsm := s.server.lsm
+	for {
+		value, version, found, err := lsm.GetWithVersion(args[1])
+		if err != nil {
+			return err
+		}
+		if !found {
+			s.writer.integer(0)
+			return nil
+		}
+		if seconds <= 0 {
+			err = lsm.DeleteIfVersion(args[1], version)
+		} else {
+			entry := NewEntryWithTTL(args[1], value, time.Duration(seconds)*time.Second)
+			_, err = lsm.CompareAndPut(&entry, version)
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.writer.integer(1)
+		return nil
+	}
+}
+
+//Increment the decimal value, missing key is treated as 0
+//the new value keeps ttl of the old one
+func incr(s *session, args [][]byte) error {
+	lsm := s.server.lsm
+	for {
+		value, version, expiresAt, found, err := lsm.GetWithExpiration(args[1])
+		if err != nil {
+			return err
+		}
+		n := int64(0)
+		if found {
+			n, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+		}
+		if n == math.MaxInt64 {
+			return errors.New("increment or decrement would overflow")
+		}
+		n++
+		value = []byte(strconv.FormatInt(n, 10))
+		entry := NewEntry(args[1], value)
+		if !expiresAt.IsZero() {
+			entry = NewEntryWithTTL(args[1], value, time.Until(expiresAt))
+		}
+		_, err = lsm.CompareAndPut(&entry, version)
+		if errors.Is(err, ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.writer.integer(n)
+		return nil
+	}
+}
+
+//Last keys of SCAN pages, clients get only numbers as cursors
+//cursors are shared by connections because client libraries can continue the scan on another connection
+type cursors struct {
+	lock  sync.Mutex
+	next  uint64
+	keys  map[uint64][]byte
+	order []uint64
+}
+
+func newCursors() *cursors {
+	return &cursors{keys: make(map[uint64][]byte)}
+}
+
+//Remember the key and return the cursor for it, 0 is never used because it starts the scan
+func (c *cursors) add(key []byte) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.next++
+	c.keys[c.next] = key
+	c.order = append(c.order, c.next)
+	if len(c.order) > maxCursors {
+		delete(c.keys, c.order[0])
+		c.order = c.order[1:]
+	}
+	return c.next
+}
+
+func (c *cursors) get(cursor uint64) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key, found := c.keys[cursor]
+	return key, found
+}
+
+//Glob-style match of redis: * and ? wildcards, [abc], [^abc], [a-z] classes and \ escapes
+func match(pattern []byte, key []byte) bool {
+	for len(pattern) != 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if match(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], key[0])
+			if !matched {
+				return false
+			}
+			key = key[1:]
+			pattern = rest
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(key) == 0
+}
+
+//Match the byte with the class that follows '[', returns the pattern after ']'
+func matchClass(pattern []byte, b byte) (bool, []byte) {
+	negate := len(pattern) != 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) != 0 && pattern[0] != ']' {
+		if pattern[0] == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			matched = matched || pattern[0] == b
+			pattern = pattern[1:]
+		} else if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+			low, high := pattern[0], pattern[2]
+			if low > high {
+				low, high = high, low
+			}
+			matched = matched || (b >= low && b <= high)
+			pattern = pattern[3:]
+		} else {
+			matched = matched || pattern[0] == b
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) != 0 {
+		pattern = pattern[1:]
+	}
+	return matched != negate, pattern
+}
@@ -0,0 +1,202 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	maxBulkLength = 512 * 1024 * 1024 //max size of the argument, the same as the default of redis
+	maxArguments  = 1024 * 1024       //max number of arguments of the command
+	maxInlineSize = 64 * 1024         //max size of the inline command
+	bulkChunk     = 64 * 1024         //bigger bulk strings are read in chunks so memory is taken only for received data
+)
+
+//Client sent something that isn't RESP, the connection is closed after the error is sent
+type protocolError string
+
+func (err protocolError) Error() string {
+	return "Protocol error: " + string(err)
+}
+
+//Read the next command, it's either an array of bulk strings or an inline command separated by spaces
+//bulk strings longer than maxBulk are rejected before they are read
+func readCommand(reader *bufio.Reader, maxBulk int) ([][]byte, error) {
+	for {
+		prefix, err := reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if prefix[0] != '*' {
+			line, err := readLine(reader, maxInlineSize)
+			if err != nil {
+				return nil, err
+			}
+			fields := bytes.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			return fields, nil
+		}
+		reader.Discard(1)
+		count, err := readLength(reader, maxArguments)
+		if err != nil {
+			return nil, err
+		}
+		if count <= 0 {
+			continue
+		}
+		//arguments are appended as they are read so a big count alone doesn't allocate memory
+		capacity := count
+		if capacity > 16 {
+			capacity = 16
+		}
+		args := make([][]byte, 0, capacity)
+		for i := 0; i < count; i++ {
+			marker, err := reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if marker != '$' {
+				return nil, protocolError("expected '$', got '" + string(marker) + "'")
+			}
+			length, err := readLength(reader, maxBulk)
+			if err != nil {
+				return nil, err
+			}
+			if length < 0 {
+				return nil, protocolError("invalid bulk length")
+			}
+			arg, err := readBulk(reader, length)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+}
+
+//Read bulk string with its CRLF, big strings are read in chunks
+//so a client that sends only the length can't make the server allocate it
+func readBulk(reader *bufio.Reader, length int) ([]byte, error) {
+	var arg []byte
+	if length+2 <= bulkChunk {
+		arg = make([]byte, length+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return nil, err
+		}
+	} else {
+		var buffer bytes.Buffer
+		if _, err := io.CopyN(&buffer, reader, int64(length+2)); err != nil {
+			return nil, err
+		}
+		arg = buffer.Bytes()
+	}
+	if arg[length] != '\r' || arg[length+1] != '\n' {
+		return nil, protocolError("bulk string is not terminated by CRLF")
+	}
+	return arg[:length], nil
+}
+
+//Read the line without CRLF
+func readLine(reader *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+		if len(line) > limit {
+			return nil, protocolError("too big inline request")
+		}
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	if len(line) > limit {
+		return nil, protocolError("too big inline request")
+	}
+	return line, nil
+}
+
+//Read the length of an array or a bulk string, -1 means null
+func readLength(reader *bufio.Reader, limit int) (int, error) {
+	line, err := readLine(reader, 32)
+	if err != nil {
+		return 0, err
+	}
+	length, err := strconv.Atoi(string(line))
+	if err != nil || length < -1 {
+		return 0, protocolError("invalid length")
+	}
+	if length > limit {
+		return 0, protocolError("length is bigger than " + strconv.Itoa(limit))
+	}
+	return length, nil
+}
+
+//Writes replies in RESP2 or RESP3 depending on the protocol the client chose with HELLO
+type writer struct {
+	*bufio.Writer
+	proto int
+}
+
+func (w *writer) simple(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+//Error reply, the message starts with the error code like ERR or WRONGTYPE
+func (w *writer) error(message string) {
+	w.WriteByte('-')
+	w.WriteString(message)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+func (w *writer) bulk(value []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(value)))
+	w.WriteString("\r\n")
+	w.Write(value)
+	w.WriteString("\r\n")
+}
+
+//Missing value
+func (w *writer) null() {
+	if w.proto == 3 {
+		w.WriteString("_\r\n")
+	} else {
+		w.WriteString("$-1\r\n")
+	}
+}
+
+//Header of an array, it's followed by its elements
+func (w *writer) array(length int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(length))
+	w.WriteString("\r\n")
+}
+
+//Header of a map, it's followed by keys and values, RESP2 clients get a flat array
+func (w *writer) mapHeader(length int) {
+	if w.proto == 3 {
+		w.WriteByte('%')
+		w.WriteString(strconv.Itoa(length))
+		w.WriteString("\r\n")
+	} else {
+		w.array(2 * length)
+	}
+}
@@ -0,0 +1,70 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		args  []string
+	}{
+		"array":        {"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", []string{"GET", "key"}},
+		"inline":       {"GET key\r\n", []string{"GET", "key"}},
+		"empty bulk":   {"*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", []string{"ECHO", ""}},
+		"empty array":  {"*0\r\n*1\r\n$4\r\nPING\r\n", []string{"PING"}},
+		"empty inline": {"\r\nPING\r\n", []string{"PING"}},
+		"big bulk":     {"*1\r\n$70000\r\n" + strings.Repeat("a", 70000) + "\r\n", []string{strings.Repeat("a", 70000)}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			args, err := readCommand(bufio.NewReader(strings.NewReader(test.input)), maxBulkLength)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(args) != len(test.args) {
+				t.Fatalf("expected %d arguments, got %d", len(test.args), len(args))
+			}
+			for i := range args {
+				if string(args[i]) != test.args[i] {
+					t.Fatalf("expected %q, got %q", test.args[i], args[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadCommand_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"bulk over limit":      "*1\r\n$100001\r\n",
+		"too many arguments":   "*1048577\r\n",
+		"not a bulk":           "*1\r\n:1\r\n",
+		"negative bulk":        "*1\r\n$-1\r\n",
+		"not terminated":       "*1\r\n$3\r\nkeyXY",
+		"big not terminated":   "*1\r\n$70000\r\n" + strings.Repeat("a", 70000) + "XY",
+		"invalid length":       "*x\r\n",
+		"too long inline":      strings.Repeat("a", maxInlineSize+1) + "\r\n",
+		"too long bulk length": "*1\r\n$" + strings.Repeat("1", 40) + "\r\n",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readCommand(bufio.NewReader(strings.NewReader(input)), 100000)
+			var protocolErr protocolError
+			if !errors.As(err, &protocolErr) {
+				t.Fatalf("expected protocol error, got %v", err)
+			}
+		})
+	}
+}
+
+//Length of a big bulk string alone doesn't make the command readable
+func TestReadCommand_Truncated(t *testing.T) {
+	_, err := readCommand(bufio.NewReader(strings.NewReader("*1\r\n$536870912\r\nabc")), maxBulkLength)
+	if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"wiskey/http"
+	. "wiskey/pkg"
+	"wiskey/tcp"
+)
+
+//Server of Redis protocol, it works with the default column family
+//Serve and Shutdown are the ones of tcp server
+type Server struct {
+	*tcp.Server
+	lsm          *LsmTree
+	cursors      *cursors
+	auth         *http.Authenticator //nil if auth is disabled
+	maxValueSize int64               //0 means unlimited
+}
+
+//Listen on tcp address, requests are served after Serve is called
+//tokens, TLS and max value size are the same as in the config of http server, nil config means defaults
+func Listen(db *DB, addr string, config *http.Config) (*Server, error) {
+	if config == nil {
+		config = http.DefaultConfig()
+	}
+	auth, err := config.Auth()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{lsm: db.LsmTree, cursors: newCursors(), auth: auth, maxValueSize: config.MaxValueSize}
+	listener, err := tcp.Listen(addr, tlsConfig, server.handle)
+	if err != nil {
+		return nil, err
+	}
+	server.Server = listener
+	return server, nil
+}
+
+//Max length of bulk strings, clients that aren't authenticated yet can send only short commands
+//keys can be bigger than small values so the limit is never lower than the limit of inline commands
+func (server *Server) maxBulkLength(s *session) int {
+	if server.auth != nil && s.grant == nil {
+		return maxInlineSize
+	}
+	if server.maxValueSize == 0 || server.maxValueSize > maxBulkLength {
+		return maxBulkLength
+	}
+	if server.maxValueSize < maxInlineSize {
+		return maxInlineSize
+	}
+	return int(server.maxValueSize)
+}
+
+//Read commands and write replies, pipelined replies are flushed when there are no buffered commands
+func (server *Server) handle(conn *tcp.Conn) {
+	reader := bufio.NewReader(conn)
+	session := &session{server: server, id: conn.Id, writer: &writer{Writer: bufio.NewWriter(conn), proto: 2}}
+	for {
+		args, err := readCommand(reader, server.maxBulkLength(session))
+		if err != nil {
+			var protocolErr protocolError
+			if errors.As(err, &protocolErr) {
+				server.lsm.Logger().Debug("redis protocol error", "remote", conn.RemoteAddr().String(), "error", err)
+				session.writer.error("ERR " + protocolErr.Error())
+				session.writer.Flush()
+			}
+			return
+		}
+		quit := session.execute(args)
+		if quit || reader.Buffered() == 0 {
+			if err := session.writer.Flush(); err != nil {
+				return
+			}
+		}
+		if quit || (conn.Closing() && reader.Buffered() == 0) {
+			return
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wiskey/http"
+	. "wiskey/pkg"
+)
+
+//Connection to the test server
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+//Open the store in temporary directory and serve it on a random port
+func startTestServer(t *testing.T, config *http.Config) (*DB, string) {
+	options := DefaultOptions()
+	options.Logger = NopLogger()
+	db, err := Open(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := Listen(db, "127.0.0.1:0", config)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() {
+		server.Shutdown(context.Background())
+		db.Close()
+	})
+	return db, server.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+//Send the command and read its reply
+//simple strings and errors keep their prefix, bulk strings don't have it and null is "(nil)"
+func (c *testClient) do(args ...string) interface{} {
+	c.t.Helper()
+	command := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		command += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	if _, err := c.conn.Write([]byte(command)); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+func (c *testClient) read() interface{} {
+	c.t.Helper()
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		length, _ := strconv.Atoi(line[1:])
+		if length < 0 {
+			return "(nil)"
+		}
+		bulk := make([]byte, length+2)
+		if _, err := io.ReadFull(c.reader, bulk); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(bulk[:length])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '*', '%':
+		length, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			length *= 2
+		}
+		var elements []interface{}
+		for i := 0; i < length; i++ {
+			elements = append(elements, c.read())
+		}
+		return elements
+	case '_':
+		return "(nil)"
+	}
+	return line
+}
+
+func assertReply(t *testing.T, reply interface{}, expected interface{}) {
+	t.Helper()
+	if fmt.Sprint(reply) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, reply)
+	}
+}
+
+func assertError(t *testing.T, reply interface{}, prefix string) {
+	t.Helper()
+	if s, ok := reply.(string); !ok || !strings.HasPrefix(s, "-"+prefix) {
+		t.Fatalf("expected %s error, got %v", prefix, reply)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	_, addr := startTestServer(t, nil)
+	c := dial(t, addr)
+	assertReply(t, c.do("PING"), "+PONG")
+	assertReply(t, c.do("ECHO", "hello"), "hello")
+	assertReply(t, c.do("GET", "a"), "(nil)")
+	assertReply(t, c.do("SET", "a", "1"), "+OK")
+	assertReply(t, c.do("GET", "a"), "1")
+	assertReply(t, c.do("SET", "a", "2", "NX"), "(nil)")
+	assertReply(t, c.do("SET", "a", "2", "XX", "GET"), "1")
+	assertReply(t, c.do("MSET", "b", "3", "c", "4"), "+OK")
+	assertReply(t, c.do("MGET", "a", "b", "x", "c"), []interface{}{"2", "3", "(nil)", "4"})
+	assertReply(t, c.do("EXISTS", "a", "x", "a"), 2)
+	assertReply(t, c.do("INCR", "a"), 3)
+	assertReply(t, c.do("INCR", "counter"), 1)
+	assertError(t, c.do("INCR", "c", "d"), "ERR wrong number of arguments")
+	assertReply(t, c.do("DEL", "a", "b", "x"), 2)
+	assertReply(t, c.do("GET", "a"), "(nil)")
+	assertError(t, c.do("UNKNOWN"), "ERR unknown command")
+	assertError(t, c.do("AUTH", "token"), "ERR AUTH called without any password")
+	assertReply(t, c.do("HELLO", "3"), []interface{}{"server", "wiskey", "version", "7.0.0", "proto", 3, "id", 1, "mode", "standalone", "role", "master", "modules", []interface{}{}})
+	assertReply(t, c.do("GET", "a"), "(nil)")
+}
+
+func TestIncr_KeepsTTL(t *testing.T) {
+	db, addr := startTestServer(t, nil)
+	c := dial(t, addr)
+	assertReply(t, c.do("SET", "counter", "41", "EX", "100"), "+OK")
+	assertReply(t, c.do("INCR", "counter"), 42)
+	_, _, expiresAt, found, err := db.GetWithExpiration([]byte("counter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || time.Until(expiresAt) <= 90*time.Second || time.Until(expiresAt) > 100*time.Second {
+		t.Fatalf("unexpected expiration %v", expiresAt)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	db, addr := startTestServer(t, nil)
+	c := dial(t, addr)
+	assertReply(t, c.do("EXPIRE", "a", "10"), 0)
+	assertReply(t, c.do("SET", "a", "1"), "+OK")
+	assertReply(t, c.do("EXPIRE", "a", "10"), 1)
+	_, _, expiresAt, _, err := db.GetWithExpiration([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresAt.IsZero() {
+		t.Fatal("key doesn't expire")
+	}
+	assertReply(t, c.do("EXPIRE", "a", "0"), 1)
+	assertReply(t, c.do("GET", "a"), "(nil)")
+}
+
+//Pages of SCAN return every key once
+func TestScan(t *testing.T) {
+	_, addr := startTestServer(t, nil)
+	c := dial(t, addr)
+	for i := 0; i < 25; i++ {
+		assertReply(t, c.do("SET", fmt.Sprintf("key%02d", i), "value"), "+OK")
+	}
+	assertReply(t, c.do("SET", "other", "value"), "+OK")
+	var keys []string
+	cursor := "0"
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "key*", "COUNT", "7").([]interface{})
+		for _, key := range reply[1].([]interface{}) {
+			keys = append(keys, key.(string))
+		}
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(keys) != 25 {
+		t.Fatalf("expected 25 keys, got %v", keys)
+	}
+	for i, key := range keys {
+		if key != fmt.Sprintf("key%02d", i) {
+			t.Fatalf("unexpected key %s at %d", key, i)
+		}
+	}
+	assertError(t, c.do("SCAN", "12345"), "ERR invalid cursor")
+	assertReply(t, c.do("SCAN", "0", "TYPE", "hash", "COUNT", "100"), []interface{}{"0", []interface{}{}})
+}
+
+func TestMaxValueSize(t *testing.T) {
+	config := http.DefaultConfig()
+	config.MaxValueSize = 8
+	_, addr := startTestServer(t, config)
+	c := dial(t, addr)
+	assertReply(t, c.do("SET", "key", "12345678"), "+OK")
+	assertError(t, c.do("SET", "key", "123456789"), "ERR value is bigger than 8 bytes")
+	assertError(t, c.do("MSET", "a", "1", "key", "123456789"), "ERR value is bigger than 8 bytes")
+	assertReply(t, c.do("GET", "a"), "(nil)")
+	//bulk string bigger than any argument closes the connection before it's read
+	if _, err := c.conn.Write([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$100000\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	assertError(t, c.read(), "ERR Protocol error")
+	if _, err := c.reader.ReadByte(); err == nil {
+		t.Fatal("connection isn't closed")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	content, err := json.Marshal(http.AuthConfig{Tokens: []http.StaticToken{
+		{Token: "reader", Grant: http.Grant{Name: "reader", Operations: []string{http.PermissionRead}, Prefixes: []string{"a"}}},
+		{Token: "writer", Grant: http.Grant{Name: "writer", Operations: []string{http.PermissionRead, http.PermissionWrite}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := http.DefaultConfig()
+	config.AuthConfig = filepath.Join(t.TempDir(), "auth.json")
+	if err := ioutil.WriteFile(config.AuthConfig, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startTestServer(t, config)
+
+	writer := dial(t, addr)
+	assertError(t, writer.do("GET", "a1"), "NOAUTH")
+	assertError(t, writer.do("PING"), "NOAUTH")
+	assertError(t, writer.do("HELLO", "3"), "NOAUTH")
+	assertError(t, writer.do("AUTH", "unknown"), "WRONGPASS")
+	assertError(t, writer.do("HELLO", "2", "AUTH", "default", "unknown"), "WRONGPASS")
+	assertReply(t, writer.do("AUTH", "default", "writer"), "+OK")
+	assertReply(t, writer.do("MSET", "a1", "1", "b1", "2"), "+OK")
+
+	reader := dial(t, addr)
+	//only short commands are read before the client is authenticated
+	if _, err := reader.conn.Write([]byte("*2\r\n$3\r\nGET\r\n$100000\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	assertError(t, reader.read(), "ERR Protocol error")
+	reader = dial(t, addr)
+	reply := reader.do("HELLO", "3", "AUTH", "default", "reader").([]interface{})
+	assertReply(t, reply[0], "server")
+	assertReply(t, reader.do("GET", "a1"), "1")
+	assertError(t, reader.do("GET", "b1"), "NOPERM")
+	assertError(t, reader.do("MGET", "a1", "b1"), "NOPERM")
+	assertError(t, reader.do("SET", "a1", "2"), "NOPERM")
+	assertError(t, reader.do("INCR", "a1"), "NOPERM")
+	assertReply(t, reader.do("SCAN", "0"), []interface{}{"0", []interface{}{"a1"}})
+}
@@ -0,0 +1,128 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+//Serves the connection, it should return after the current request when Closing returns true
+type Handler func(conn *Conn)
+
+//Connection of a client
+type Conn struct {
+	net.Conn
+	Id     int64 //unique number of the connection
+	server *Server
+}
+
+//Server is shutting down, the connection has to be closed after the current request
+func (conn *Conn) Closing() bool {
+	conn.server.lock.Lock()
+	defer conn.server.lock.Unlock()
+	return conn.server.closing
+}
+
+//Server of a text protocol, it tracks connections so they can be drained on shutdown
+type Server struct {
+	listener net.Listener
+	handler  Handler
+	lock     sync.Mutex
+	conns    map[*Conn]bool
+	closing  bool
+	lastId   int64
+	wg       sync.WaitGroup
+}
+
+//Listen on tcp address, connections are accepted after Serve is called
+//connections use TLS if the config isn't nil
+func Listen(addr string, tlsConfig *tls.Config, handler Handler) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return &Server{listener: listener, handler: handler, conns: make(map[*Conn]bool)}, nil
+}
+
+//Address the server listens on
+func (server *Server) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+//Accept connections until the server is stopped
+func (server *Server) Serve() error {
+	for {
+		netConn, err := server.listener.Accept()
+		if err != nil {
+			server.lock.Lock()
+			closing := server.closing
+			server.lock.Unlock()
+			if closing {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		server.lock.Lock()
+		if server.closing {
+			server.lock.Unlock()
+			netConn.Close()
+			return nil
+		}
+		server.lastId++
+		conn := &Conn{Conn: netConn, Id: server.lastId, server: server}
+		server.conns[conn] = true
+		server.wg.Add(1)
+		server.lock.Unlock()
+		go server.handle(conn)
+	}
+}
+
+func (server *Server) handle(conn *Conn) {
+	defer func() {
+		server.lock.Lock()
+		delete(server.conns, conn)
+		server.lock.Unlock()
+		conn.Close()
+		server.wg.Done()
+	}()
+	server.handler(conn)
+}
+
+//Stop accepting connections, let running requests finish and close connections
+//connections that are still busy when the context is done are closed right away
+func (server *Server) Shutdown(ctx context.Context) {
+	server.lock.Lock()
+	server.closing = true
+	server.listener.Close()
+	//idle connections are waiting for the next request, wake them up
+	for conn := range server.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	server.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		server.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		server.lock.Lock()
+		for conn := range server.conns {
+			conn.Close()
+		}
+		server.lock.Unlock()
+		<-done
+	}
+}